@@ -0,0 +1,63 @@
+// Package config config/clock.go
+package config
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Clock 服务、数据表时间戳和模拟链使用的时间来源，
+// 测试和模拟环境使用 FakeClock 控制时间窗口相关的逻辑
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock 系统时钟
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time { return time.Now() }
+
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// FakeClock 只在 Set、Advance 时前进的时钟，Sleep 不阻塞而是直接推进时间
+type FakeClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now}
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+}
+
+// Advance 将时间推进 d 并返回推进后的时间，d 为负数时不回拨
+func (c *FakeClock) Advance(d time.Duration) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if d > 0 {
+		c.now = c.now.Add(d)
+	}
+	return c.now
+}
+
+// Set 将时间设置为 t，不允许回拨，避免已记录的时间戳晚于当前时间
+func (c *FakeClock) Set(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if t.Before(c.now) {
+		return fmt.Errorf("cannot move clock back from %s to %s", c.now.Format(time.RFC3339), t.Format(time.RFC3339))
+	}
+	c.now = t
+	return nil
+}
@@ -0,0 +1,348 @@
+// Package config config/crypto.go
+package config
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultConfirmations 未配置的网络使用的确认数
+	defaultConfirmations = 6
+
+	// defaultAddressCoolingOff 新加入地址簿的地址需等待的时间
+	defaultAddressCoolingOff = 24 * time.Hour
+
+	// defaultApprovalExpiry 大额提现等待审批的最长时间
+	defaultApprovalExpiry = 24 * time.Hour
+
+	// defaultSweepInterval 归集和热钱包额度检查的间隔
+	defaultSweepInterval = time.Minute
+
+	// defaultBroadcastInterval 广播器扫描发件箱的间隔
+	defaultBroadcastInterval = 2 * time.Second
+
+	// defaultBroadcastMaxAttempts 广播失败的最大尝试次数，用尽后释放冻结资金
+	defaultBroadcastMaxAttempts = 5
+
+	// defaultBroadcastRetryBackoff 首次重试的等待时间，之后每次翻倍
+	defaultBroadcastRetryBackoff = 5 * time.Second
+
+	// defaultAddressGapLimit 每个钱包最多持有的未使用充值地址数
+	defaultAddressGapLimit = 20
+
+	// defaultPriceInterval 从价格源刷新价格的间隔
+	defaultPriceInterval = time.Minute
+
+	// defaultBlockInterval 模拟链的出块间隔
+	defaultBlockInterval = 5 * time.Second
+
+	// defaultTrackerInterval 检查已广播提现确认数的间隔
+	defaultTrackerInterval = 5 * time.Second
+
+	// defaultReorgInterval 检查已入账交易是否被链重组移除的间隔
+	defaultReorgInterval = 10 * time.Second
+)
+
+type CryptoConfig struct {
+	Confirmations ConfirmationPolicy
+
+	// AddressCoolingOff 新地址加入地址簿后多久才能用于白名单提现
+	AddressCoolingOff time.Duration
+
+	Approvals ApprovalPolicy
+
+	Treasury      TreasuryPolicy
+	SweepInterval time.Duration
+
+	Broadcast BroadcastPolicy
+
+	// TrackerInterval 提现确认跟踪的轮询间隔
+	TrackerInterval time.Duration
+	// ReorgInterval 链重组检测的轮询间隔
+	ReorgInterval time.Duration
+
+	// DepositModes 按网络配置充值模式，未配置的网络为每个钱包分配独立地址
+	DepositModes map[models.Network]string
+
+	// AddressGapLimit 钱包未收到过充值的地址数达到该值后不再生成新地址，0 表示不限制
+	AddressGapLimit int
+
+	Pricing PricingPolicy
+
+	// ChainStateDir 模拟链状态和快照的保存目录，为空时链状态只保存在内存中
+	ChainStateDir string
+
+	// BlockInterval 模拟链出块间隔，为 0 时只手动出块
+	BlockInterval time.Duration
+
+	// MockChainAPI 开启 /api/mock-chain 管理接口（注资、出块、推进时钟等），
+	// 只在所有网络都使用模拟链时生效
+	MockChainAPI bool
+
+	// MockRPC 按网络配置模拟链 JSON-RPC 接口的监听地址（如 ETH: ":8545"），
+	// 未配置的网络不开启，只支持 ETH 和 BSC
+	MockRPC map[models.Network]string
+
+	// Nodes 按网络配置真实节点，配置了节点的网络不再使用模拟链，
+	// 支持 ETH、BSC（以太坊 JSON-RPC）和 BTC（Bitcoin Core，需开启 txindex）
+	Nodes map[models.Network]NodePolicy
+
+	// KeyEncryptionSecret 加密保存签名私钥的口令，未配置时服务拒绝启动
+	KeyEncryptionSecret string
+	// AllowPlaintextKeys 允许在未配置口令时以明文保存私钥，仅用于开发和模拟链环境
+	AllowPlaintextKeys bool
+
+	// Clock 服务和模拟链的时间来源，应与数据库配置使用同一个时钟
+	Clock Clock
+}
+
+// 充值模式
+const (
+	DepositModeUniqueAddress = "unique_address" // 每个钱包一个充值地址
+	DepositModeSharedAddress = "shared_address" // 所有钱包共用一个地址，按备注入账
+)
+
+// SharedDepositAddress 判断网络是否使用共享充值地址
+func (c *CryptoConfig) SharedDepositAddress(network models.Network) bool {
+	return c.DepositModes[network] == DepositModeSharedAddress
+}
+
+func DefaultCryptoConfig() *CryptoConfig {
+	return &CryptoConfig{
+		Confirmations:     DefaultConfirmationPolicy(),
+		AddressCoolingOff: defaultAddressCoolingOff,
+		Approvals:         DefaultApprovalPolicy(),
+		Treasury:          DefaultTreasuryPolicy(),
+		SweepInterval:     defaultSweepInterval,
+		Broadcast: BroadcastPolicy{
+			Interval:     defaultBroadcastInterval,
+			MaxAttempts:  defaultBroadcastMaxAttempts,
+			RetryBackoff: defaultBroadcastRetryBackoff,
+		},
+		TrackerInterval: defaultTrackerInterval,
+		ReorgInterval:   defaultReorgInterval,
+		DepositModes:    map[models.Network]string{},
+		AddressGapLimit: defaultAddressGapLimit,
+		Pricing: PricingPolicy{
+			Interval:   defaultPriceInterval,
+			Currencies: []string{"EUR"},
+		},
+		BlockInterval: defaultBlockInterval,
+		Clock:         SystemClock{},
+	}
+}
+
+// PricingPolicy 价格预言机的刷新配置
+type PricingPolicy struct {
+	Interval   time.Duration `json:"interval"`
+	SourceFile string        `json:"source_file"` // 文件价格源，为空时只使用通过接口录入的价格
+	Currencies []string      `json:"currencies"`  // 除美元外需要刷新汇率的法币
+}
+
+// NodePolicy 节点连接配置，请求失败时按顺序切换到下一个节点，
+// 所有节点都失败后等待 RetryBackoff（每轮翻倍）再重试
+type NodePolicy struct {
+	URLs         []string      `json:"urls"`
+	Timeout      time.Duration `json:"timeout"`       // 单次请求超时，默认 10 秒
+	MaxRetries   int           `json:"max_retries"`   // 所有节点都失败后的重试轮数
+	RetryBackoff time.Duration `json:"retry_backoff"` // 默认 500 毫秒
+	// Chain 比特币节点所在的网络，与节点 getblockchaininfo 返回的 chain 一致：
+	// main、test、signet 或 regtest，默认 main。决定地址的 bech32 前缀和 Base58 版本字节
+	Chain string `json:"chain"`
+}
+
+// BroadcastPolicy 发件箱广播的重试策略
+type BroadcastPolicy struct {
+	Interval     time.Duration `json:"interval"`
+	MaxAttempts  int           `json:"max_attempts"`
+	RetryBackoff time.Duration `json:"retry_backoff"`
+}
+
+// NextAttempt 返回第 attempts 次失败后的重试等待时间
+func (p BroadcastPolicy) NextAttempt(attempts int) time.Duration {
+	backoff := p.RetryBackoff
+	for i := 1; i < attempts; i++ {
+		backoff *= 2
+	}
+	return backoff
+}
+
+// NetworkTreasuryPolicy 单个网络的资金归集和热钱包额度策略。
+// 热钱包原生币余额低于 HotMin 时由冷钱包补足到 HotTarget，高于 HotMax 时将超出 HotTarget 的部分转入冷钱包
+type NetworkTreasuryPolicy struct {
+	SweepThresholds map[string]float64 `json:"sweep_thresholds"` // 按资产符号，扣除手续费后不低于该值才归集
+	HotMin          float64            `json:"hot_min"`
+	HotTarget       float64            `json:"hot_target"`
+	HotMax          float64            `json:"hot_max"`
+}
+
+type TreasuryPolicy map[models.Network]NetworkTreasuryPolicy
+
+func DefaultTreasuryPolicy() TreasuryPolicy {
+	stablecoins := func(native string, threshold float64) map[string]float64 {
+		return map[string]float64{native: threshold, "USDT": 10, "USDC": 10}
+	}
+	return TreasuryPolicy{
+		models.NetworkBTC: {
+			SweepThresholds: map[string]float64{"BTC": 0.001},
+			HotMin:          1,
+			HotTarget:       5,
+			HotMax:          10,
+		},
+		models.NetworkETH: {
+			SweepThresholds: stablecoins("ETH", 0.01),
+			HotMin:          10,
+			HotTarget:       50,
+			HotMax:          100,
+		},
+		models.NetworkBSC: {
+			SweepThresholds: stablecoins("BNB", 0.05),
+			HotMin:          50,
+			HotTarget:       200,
+			HotMax:          500,
+		},
+		models.NetworkTRON: {
+			SweepThresholds: stablecoins("TRX", 100),
+			HotMin:          100000,
+			HotTarget:       500000,
+			HotMax:          1000000,
+		},
+	}
+}
+
+// ApprovalPolicy 大额提现的 M-of-N 审批规则：金额不低于资产阈值的提现
+// 需要 Operators 中 Required 名不同的操作员批准
+type ApprovalPolicy struct {
+	Thresholds map[string]float64 `json:"thresholds"` // 按资产符号配置，未配置的资产不需要审批
+	Required   int                `json:"required"`
+	Operators  []string           `json:"operators"`
+	Expiry     time.Duration      `json:"expiry"`
+	// Tokens 操作员 ID 到访问令牌，操作员调用审批和指派接口时以令牌证明身份。
+	// 令牌属于密钥，不随策略输出
+	Tokens map[string]string `json:"-"`
+}
+
+func DefaultApprovalPolicy() ApprovalPolicy {
+	return ApprovalPolicy{
+		Thresholds: map[string]float64{
+			"BTC":  1,
+			"ETH":  10,
+			"BNB":  50,
+			"TRX":  100000,
+			"USDT": 10000,
+			"USDC": 10000,
+		},
+		Required:  2,
+		Operators: []string{"operator-1", "operator-2", "operator-3"},
+		Expiry:    defaultApprovalExpiry,
+	}
+}
+
+// RequiresApproval 判断提现是否需要审批
+func (p ApprovalPolicy) RequiresApproval(asset string, amount float64) bool {
+	threshold, exists := p.Thresholds[asset]
+	return exists && p.Required > 0 && amount >= threshold
+}
+
+// IsOperator 判断是否为有审批权限的操作员
+func (p ApprovalPolicy) IsOperator(operatorID string) bool {
+	for _, operator := range p.Operators {
+		if operator == operatorID {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate 返回持有访问令牌的操作员 ID，令牌无效时返回 false
+func (p ApprovalPolicy) Authenticate(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for operatorID, expected := range p.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return operatorID, true
+		}
+	}
+	return "", false
+}
+
+// ParseOperatorTokens 解析 "操作员ID=令牌" 以逗号分隔的列表
+func ParseOperatorTokens(value string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		operatorID, token, found := strings.Cut(entry, "=")
+		if !found || operatorID == "" || token == "" {
+			return nil, fmt.Errorf("invalid operator token entry, expected <operator>=<token>")
+		}
+		tokens[operatorID] = token
+	}
+	return tokens, nil
+}
+
+// ConfirmationTier Asset 的金额不低于 MinAmount 时需要的确认数，Asset 为空时为网络原生币
+type ConfirmationTier struct {
+	Asset         string  `json:"asset,omitempty"`
+	MinAmount     float64 `json:"min_amount"`
+	Confirmations int     `json:"confirmations"`
+}
+
+// NetworkConfirmationPolicy 单个网络的确认要求，Tiers 用于大额交易提高确认数
+type NetworkConfirmationPolicy struct {
+	Confirmations int                `json:"confirmations"`
+	Tiers         []ConfirmationTier `json:"tiers,omitempty"`
+}
+
+type ConfirmationPolicy map[models.Network]NetworkConfirmationPolicy
+
+func DefaultConfirmationPolicy() ConfirmationPolicy {
+	return ConfirmationPolicy{
+		models.NetworkBTC: {
+			Confirmations: 6,
+			Tiers: []ConfirmationTier{
+				{MinAmount: 10, Confirmations: 12},
+			},
+		},
+		models.NetworkETH: {Confirmations: 12},
+		models.NetworkBSC: {Confirmations: 15},
+		// 小额 TRON 充值数个区块即可入账，大额等待 19 个区块固化
+		models.NetworkTRON: {
+			Confirmations: 3,
+			Tiers: []ConfirmationTier{
+				{MinAmount: 100000, Confirmations: 19},
+				{Asset: "USDT", MinAmount: 10000, Confirmations: 19},
+				{Asset: "USDC", MinAmount: 10000, Confirmations: 19},
+			},
+		},
+	}
+}
+
+// Required 返回指定网络上 asset 金额为 amount 的交易需要的确认数，只比较该资产的分档
+func (p ConfirmationPolicy) Required(network models.Network, asset string, amount float64) int {
+	policy, exists := p[network]
+	if !exists || policy.Confirmations <= 0 {
+		return defaultConfirmations
+	}
+
+	if asset == network.NativeSymbol() {
+		asset = ""
+	}
+	required := policy.Confirmations
+	tiers := append([]ConfirmationTier(nil), policy.Tiers...)
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].MinAmount < tiers[j].MinAmount })
+	for _, tier := range tiers {
+		if tier.Asset == asset && amount >= tier.MinAmount && tier.Confirmations > required {
+			required = tier.Confirmations
+		}
+	}
+	return required
+}
@@ -8,6 +8,7 @@ import (
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -18,6 +19,7 @@ type Config struct {
 	Password string // MySQL password
 	Host     string // MySQL host
 	Port     int    // MySQL port
+	Clock    Clock  // 数据表 created_at/updated_at 的时间来源，为空时使用系统时间
 }
 
 func (c *Config) GetMySQLDSN() string {
@@ -77,7 +79,11 @@ func NewDB(cfg *Config) (*gorm.DB, error) {
 		return nil, fmt.Errorf("unsupported database type: %s", cfg.DBType)
 	}
 
-	db, err := gorm.Open(dialector, &gorm.Config{})
+	gormConfig := &gorm.Config{}
+	if cfg.Clock != nil {
+		gormConfig.NowFunc = func() time.Time { return cfg.Clock.Now().Local() }
+	}
+	db, err := gorm.Open(dialector, gormConfig)
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect database: %v", err)
 	}
@@ -104,6 +110,21 @@ func migrateDB(db *gorm.DB) error {
 		&models.CryptoWallet{},
 		&models.CryptoTransaction{},
 		&models.CryptoReconciliation{},
+		&models.CryptoAlert{},
+		&models.AddressNonce{},
+		&models.Asset{},
+		&models.CryptoWalletBalance{},
+		&models.AddressBookEntry{},
+		&models.AddressBookSetting{},
+		&models.AddressBookAudit{},
+		&models.WithdrawalApproval{},
+		&models.SystemWallet{},
+		&models.InternalTransfer{},
+		&models.BroadcastIntent{},
+		&models.UnassignedDeposit{},
+		&models.CryptoWalletAddress{},
+		&models.Price{},
+		&models.SigningKey{},
 	}
 
 	// 迁移所有表
@@ -114,6 +135,16 @@ func migrateDB(db *gorm.DB) error {
 		}
 	}
 
+	// 共享充值地址模式下多个钱包使用同一地址，早期建立的地址唯一索引改为普通索引
+	if err := relaxUniqueIndex(db, &models.CryptoWallet{}, "idx_crypto_wallets_address"); err != nil {
+		return err
+	}
+
+	// 支持多地址之前创建的钱包补建地址记录
+	if err := backfillWalletAddresses(db); err != nil {
+		return err
+	}
+
 	// 创建索引
 	dialectName := db.Dialector.Name()
 	var baseIndexQueries, cryptoIndexQueries []string
@@ -169,3 +200,45 @@ func migrateDB(db *gorm.DB) error {
 
 	return nil
 }
+
+// relaxUniqueIndex 将已存在的同名唯一索引按模型当前的定义重建
+func relaxUniqueIndex(db *gorm.DB, model interface{}, name string) error {
+	indexes, err := db.Migrator().GetIndexes(model)
+	if err != nil {
+		return fmt.Errorf("failed to inspect indexes: %v", err)
+	}
+	for _, index := range indexes {
+		if unique, _ := index.Unique(); index.Name() != name || !unique {
+			continue
+		}
+		if err := db.Migrator().DropIndex(model, name); err != nil {
+			return fmt.Errorf("failed to drop index %s: %v", name, err)
+		}
+		if err := db.Migrator().CreateIndex(model, name); err != nil {
+			return fmt.Errorf("failed to create index %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// backfillWalletAddresses 为没有地址记录的钱包以其当前地址创建第 0 个地址
+func backfillWalletAddresses(db *gorm.DB) error {
+	var wallets []models.CryptoWallet
+	err := db.Where("id NOT IN (?)", db.Model(&models.CryptoWalletAddress{}).Select("wallet_id")).
+		Find(&wallets).Error
+	if err != nil {
+		return fmt.Errorf("failed to find wallets without addresses: %v", err)
+	}
+	for _, wallet := range wallets {
+		address := models.CryptoWalletAddress{
+			WalletID: wallet.ID,
+			Network:  wallet.Network,
+			Address:  wallet.Address,
+			Status:   models.WalletAddressActive,
+		}
+		if err := db.Create(&address).Error; err != nil {
+			return fmt.Errorf("failed to backfill address of wallet %d: %v", wallet.ID, err)
+		}
+	}
+	return nil
+}
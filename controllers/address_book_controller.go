@@ -0,0 +1,144 @@
+// Package controllers/address_book_controller.go
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/panaceacode/wallet-demo/models"
+	"github.com/panaceacode/wallet-demo/services"
+	"net/http"
+	"strconv"
+)
+
+type AddressBookController struct {
+	addressBook *services.AddressBookService
+}
+
+func NewAddressBookController(addressBook *services.AddressBookService) *AddressBookController {
+	return &AddressBookController{addressBook: addressBook}
+}
+
+type AddAddressBookEntryRequest struct {
+	UserID  uint           `json:"user_id" binding:"required"`
+	Network models.Network `json:"network" binding:"required"`
+	Address string         `json:"address" binding:"required"`
+	Label   string         `json:"label" binding:"max=100"`
+}
+
+type SetWhitelistOnlyRequest struct {
+	UserID  uint `json:"user_id" binding:"required"`
+	Enabled bool `json:"enabled"`
+}
+
+func (c *AddressBookController) AddEntry(ctx *gin.Context) {
+	var req AddAddressBookEntryRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	entry, err := c.addressBook.AddEntry(req.UserID, req.Network, req.Address, req.Label)
+	var addrErr *services.AddressError
+	if errors.As(err, &addrErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": addrErr.Message, "code": addrErr.Code})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, entry)
+}
+
+func (c *AddressBookController) RemoveEntry(ctx *gin.Context) {
+	entryID, err := strconv.ParseUint(ctx.Param("entryId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid entry id"})
+		return
+	}
+	userID, err := strconv.ParseUint(ctx.Query("user_id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	if err := c.addressBook.RemoveEntry(uint(userID), uint(entryID)); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "address removed successfully"})
+}
+
+func (c *AddressBookController) ListEntries(ctx *gin.Context) {
+	userID, err := strconv.ParseUint(ctx.Query("user_id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	entries, err := c.addressBook.ListEntries(uint(userID), models.Network(ctx.Query("network")))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	setting, err := c.addressBook.GetSetting(uint(userID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"entries":              entries,
+		"whitelist_only":       setting.WhitelistOnly,
+		"whitelist_disable_at": setting.WhitelistDisableAt,
+	})
+}
+
+func (c *AddressBookController) SetWhitelistOnly(ctx *gin.Context) {
+	var req SetWhitelistOnlyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	setting, err := c.addressBook.SetWhitelistOnly(req.UserID, req.Enabled)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, setting)
+}
+
+func (c *AddressBookController) GetAuditLog(ctx *gin.Context) {
+	userID, err := strconv.ParseUint(ctx.Query("user_id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	audits, total, err := c.addressBook.GetAuditLog(uint(userID), page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"audits":    audits,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
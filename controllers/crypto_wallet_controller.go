@@ -2,6 +2,7 @@
 package controllers
 
 import (
+	"errors"
 	"github.com/gin-gonic/gin"
 	"github.com/panaceacode/wallet-demo/models"
 	"github.com/panaceacode/wallet-demo/services"
@@ -33,12 +34,50 @@ type CryptoDepositRequest struct {
 
 type CryptoWithdrawRequest struct {
 	ToAddress string  `json:"to_address" binding:"required"`
+	Asset     string  `json:"asset"`
 	Amount    float64 `json:"amount" binding:"required,gt=0"`
+	FeeSpeed  string  `json:"fee_speed" binding:"omitempty,oneof=slow normal fast"`
+	MaxFee    float64 `json:"max_fee" binding:"omitempty,gt=0"`
+	Memo      string  `json:"memo" binding:"max=64"`
 }
 
+type CryptoSharedDepositRequest struct {
+	Network models.Network `json:"network" binding:"required"`
+	TxHash  string         `json:"tx_hash" binding:"required"`
+}
+
+type CryptoAssignDepositRequest struct {
+	WalletID uint `json:"wallet_id" binding:"required"`
+}
+
+type CryptoValidateAddressRequest struct {
+	Network models.Network `json:"network" binding:"required"`
+	Address string         `json:"address" binding:"required"`
+}
+
+type CryptoApprovalRequest struct {
+	Comment string `json:"comment" binding:"max=255"`
+}
+
+type CryptoReplaceWithdrawalRequest struct {
+	FeeSpeed string `json:"fee_speed" binding:"omitempty,oneof=slow normal fast"`
+}
+
+type CryptoRegisterAssetRequest struct {
+	Network         models.Network `json:"network" binding:"required"`
+	Symbol          string         `json:"symbol" binding:"required"`
+	ContractAddress string         `json:"contract_address" binding:"required"`
+	Decimals        int32          `json:"decimals" binding:"gte=0,lte=36"`
+	Standard        string         `json:"standard" binding:"omitempty,oneof=ERC20 BEP20 TRC20"`
+}
+
+// CryptoReconciliationRequest 对账窗口为时间范围或区块范围，同时给出区块范围时按区块对账
 type CryptoReconciliationRequest struct {
-	StartTime time.Time `json:"start_time" binding:"required"`
-	EndTime   time.Time `json:"end_time" binding:"required"`
+	Asset      string    `json:"asset"`
+	StartTime  time.Time `json:"start_time"`
+	EndTime    time.Time `json:"end_time"`
+	StartBlock *uint64   `json:"start_block"`
+	EndBlock   *uint64   `json:"end_block"`
 }
 
 func (c *CryptoWalletController) CreateWallet(ctx *gin.Context) {
@@ -57,6 +96,109 @@ func (c *CryptoWalletController) CreateWallet(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, wallet)
 }
 
+func (c *CryptoWalletController) GetConfirmationPolicy(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{
+		"policy": c.walletService.GetConfirmationPolicy(),
+	})
+}
+
+func (c *CryptoWalletController) EstimateFees(ctx *gin.Context) {
+	network := models.Network(ctx.Param("network"))
+
+	quotes, err := c.walletService.EstimateFees(network, ctx.Query("asset"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"network": network,
+		"fees":    quotes,
+	})
+}
+
+func (c *CryptoWalletController) ListAssets(ctx *gin.Context) {
+	assets, err := c.walletService.GetAssets().List(models.Network(ctx.Query("network")))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"assets": assets})
+}
+
+func (c *CryptoWalletController) RegisterAsset(ctx *gin.Context) {
+	var req CryptoRegisterAssetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	asset := &models.Asset{
+		Network:         req.Network,
+		Symbol:          req.Symbol,
+		ContractAddress: req.ContractAddress,
+		Decimals:        req.Decimals,
+		Standard:        req.Standard,
+	}
+	if err := c.walletService.GetAssets().Register(asset); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, asset)
+}
+
+func (c *CryptoWalletController) GetBalances(ctx *gin.Context) {
+	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid wallet id"})
+		return
+	}
+
+	balances, err := c.walletService.GetBalances(uint(walletID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"balances": balances})
+}
+
+// NewDepositAddress 为钱包生成新的充值地址，旧地址继续监听入账
+func (c *CryptoWalletController) NewDepositAddress(ctx *gin.Context) {
+	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid wallet id"})
+		return
+	}
+
+	address, err := c.walletService.NewDepositAddress(uint(walletID))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, address)
+}
+
+// ListAddresses 返回钱包的所有地址及其收款情况
+func (c *CryptoWalletController) ListAddresses(ctx *gin.Context) {
+	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid wallet id"})
+		return
+	}
+
+	addresses, err := c.walletService.ListAddresses(uint(walletID))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"addresses": addresses})
+}
+
 func (c *CryptoWalletController) ProcessDeposit(ctx *gin.Context) {
 	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
@@ -78,6 +220,86 @@ func (c *CryptoWalletController) ProcessDeposit(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, gin.H{"message": "deposit processed successfully"})
 }
 
+// ProcessSharedDeposit 处理转入共享充值地址的交易，备注无法匹配钱包时进入待分配队列
+func (c *CryptoWalletController) ProcessSharedDeposit(ctx *gin.Context) {
+	var req CryptoSharedDepositRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := c.walletService.ProcessSharedDeposit(req.Network, req.TxHash)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	message := "deposit processed successfully"
+	if result.Held != nil {
+		message = "deposit is held for manual assignment"
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     message,
+		"transaction": result.Transaction,
+		"held":        result.Held,
+	})
+}
+
+func (c *CryptoWalletController) ListUnassignedDeposits(ctx *gin.Context) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	deposits, total, err := c.walletService.ListUnassignedDeposits(
+		models.Network(ctx.Query("network")), ctx.Query("status"), page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"deposits":  deposits,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (c *CryptoWalletController) AssignDeposit(ctx *gin.Context) {
+	depositID, err := strconv.ParseUint(ctx.Param("depositId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid deposit id"})
+		return
+	}
+
+	var req CryptoAssignDepositRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	record, err := c.walletService.AssignDeposit(uint(depositID), req.WalletID, operatorID(ctx))
+	if errors.Is(err, services.ErrNotApprover) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message":     "deposit assigned successfully",
+		"transaction": record,
+	})
+}
+
 func (c *CryptoWalletController) Withdraw(ctx *gin.Context) {
 	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
@@ -91,18 +313,192 @@ func (c *CryptoWalletController) Withdraw(ctx *gin.Context) {
 		return
 	}
 
-	txHash, err := c.walletService.Withdraw(uint(walletID), req.ToAddress, req.Amount)
+	record, err := c.walletService.Withdraw(uint(walletID), req.ToAddress, req.Amount, services.WithdrawOptions{
+		Asset:  req.Asset,
+		Speed:  services.FeeSpeed(req.FeeSpeed),
+		MaxFee: req.MaxFee,
+		Memo:   req.Memo,
+	})
+	var addrErr *services.AddressError
+	if errors.As(err, &addrErr) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": addrErr.Message, "code": addrErr.Code})
+		return
+	}
+	if errors.Is(err, services.ErrAddressNotWhitelisted) || errors.Is(err, services.ErrAddressCoolingOff) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	message := "withdrawal initiated successfully"
+	if record.Status == models.CryptoTxStatusPendingApproval {
+		message = "withdrawal is pending approval"
+	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"message": "withdrawal initiated successfully",
+		"message":        message,
+		"transaction_id": record.ID,
+		"status":         record.Status,
+		"tx_hash":        record.TxHash,
+	})
+}
+
+func (c *CryptoWalletController) ListPendingApprovals(ctx *gin.Context) {
+	pending, err := c.walletService.ListPendingApprovals()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"withdrawals": pending})
+}
+
+// ListBroadcastIntents 查看发件箱中的广播意图，可按状态过滤
+func (c *CryptoWalletController) ListBroadcastIntents(ctx *gin.Context) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	intents, total, err := c.walletService.GetBroadcaster().ListIntents(ctx.Query("status"), page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"intents":   intents,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+func (c *CryptoWalletController) ApproveWithdrawal(ctx *gin.Context) {
+	transactionID, err := strconv.ParseUint(ctx.Param("txId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		return
+	}
+
+	var req CryptoApprovalRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	record, err := c.walletService.ApproveWithdrawal(uint(transactionID), operatorID(ctx), req.Comment)
+	if errors.Is(err, services.ErrNotApprover) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, record)
+}
+
+func (c *CryptoWalletController) RejectWithdrawal(ctx *gin.Context) {
+	transactionID, err := strconv.ParseUint(ctx.Param("txId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		return
+	}
+
+	var req CryptoApprovalRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	record, err := c.walletService.RejectWithdrawal(uint(transactionID), operatorID(ctx), req.Comment)
+	if errors.Is(err, services.ErrNotApprover) {
+		ctx.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, record)
+}
+
+func (c *CryptoWalletController) ValidateAddress(ctx *gin.Context) {
+	var req CryptoValidateAddressRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err := c.walletService.GetAddressValidator().ValidateAddress(req.Network, req.Address)
+	var addrErr *services.AddressError
+	if errors.As(err, &addrErr) {
+		ctx.JSON(http.StatusOK, gin.H{
+			"valid":   false,
+			"network": req.Network,
+			"address": req.Address,
+			"code":    addrErr.Code,
+			"error":   addrErr.Message,
+		})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"valid":   true,
+		"network": req.Network,
+		"address": req.Address,
+	})
+}
+
+func (c *CryptoWalletController) ReplaceWithdrawal(ctx *gin.Context) {
+	transactionID, err := strconv.ParseUint(ctx.Param("txId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid transaction id"})
+		return
+	}
+
+	var req CryptoReplaceWithdrawalRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	txHash, err := c.walletService.ReplaceWithdrawal(uint(transactionID), services.FeeSpeed(req.FeeSpeed))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"message": "withdrawal replaced successfully",
 		"tx_hash": txHash,
 	})
 }
 
+func (c *CryptoWalletController) InspectNonces(ctx *gin.Context) {
+	report, err := c.walletService.InspectNonces(models.Network(ctx.Param("network")), ctx.Param("address"))
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, report)
+}
+
 func (c *CryptoWalletController) GetTransactions(ctx *gin.Context) {
 	walletID, err := strconv.ParseUint(ctx.Param("id"), 10, 32)
 	if err != nil {
@@ -147,11 +543,26 @@ func (c *CryptoWalletController) PerformReconciliation(ctx *gin.Context) {
 		return
 	}
 
-	reconciliation, err := c.reconciliationService.PerformReconciliation(
-		uint(walletID),
-		req.StartTime,
-		req.EndTime,
-	)
+	var reconciliation *models.CryptoReconciliation
+	switch {
+	case req.StartBlock != nil && req.EndBlock != nil:
+		reconciliation, err = c.reconciliationService.PerformBlockReconciliation(
+			uint(walletID),
+			req.Asset,
+			*req.StartBlock,
+			*req.EndBlock,
+		)
+	case !req.StartTime.IsZero() && !req.EndTime.IsZero():
+		reconciliation, err = c.reconciliationService.PerformReconciliation(
+			uint(walletID),
+			req.Asset,
+			req.StartTime,
+			req.EndTime,
+		)
+	default:
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "start_time and end_time, or start_block and end_block, are required"})
+		return
+	}
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
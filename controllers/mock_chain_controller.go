@@ -0,0 +1,333 @@
+// Package controllers/mock_chain_controller.go
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/panaceacode/wallet-demo/models"
+	"github.com/panaceacode/wallet-demo/services"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// MockChainController 模拟链的管理接口，用于测试场景的准备和重置
+type MockChainController struct {
+	store *services.MockChainStore // 未配置状态目录时为 nil
+	admin *services.MockChainAdmin
+}
+
+func NewMockChainController(store *services.MockChainStore, admin *services.MockChainAdmin) *MockChainController {
+	return &MockChainController{store: store, admin: admin}
+}
+
+type MockChainSnapshotRequest struct {
+	Name string `json:"name" binding:"required"`
+}
+
+type MockFaucetRequest struct {
+	Address string  `json:"address" binding:"required"`
+	Asset   string  `json:"asset"` // 为空时为原生币
+	Amount  float64 `json:"amount" binding:"required,gt=0"`
+}
+
+type MockTransferRequest struct {
+	From   string  `json:"from"` // 为空时随机生成外部地址
+	To     string  `json:"to" binding:"required"`
+	Asset  string  `json:"asset"`
+	Amount float64 `json:"amount" binding:"required,gt=0"`
+	Memo   string  `json:"memo"`
+}
+
+type MockMineRequest struct {
+	Blocks int `json:"blocks"` // 为 0 时产生一个区块
+}
+
+type MockFaultsRequest struct {
+	ErrorRate   float64 `json:"error_rate"`   // 返回错误的调用比例（%）
+	LatencyRate float64 `json:"latency_rate"` // 增加延迟的调用比例（%）
+	LatencyMs   int64   `json:"latency_ms"`
+}
+
+// MockClockRequest 推进模拟时钟，Seconds 和 To 二选一
+type MockClockRequest struct {
+	Seconds int64      `json:"seconds"` // 推进的秒数
+	To      *time.Time `json:"to"`      // 设置到的时间（RFC3339），不能早于当前时间
+}
+
+// requireStore 未开启链状态持久化时返回错误响应
+func (c *MockChainController) requireStore(ctx *gin.Context) bool {
+	if c.store == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "chain state persistence is not enabled"})
+		return false
+	}
+	return true
+}
+
+func (c *MockChainController) ListSnapshots(ctx *gin.Context) {
+	if !c.requireStore(ctx) {
+		return
+	}
+
+	snapshots, err := c.store.ListSnapshots()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"snapshots": snapshots})
+}
+
+// SaveSnapshot 保存所有模拟链的当前状态，同名快照会被覆盖
+func (c *MockChainController) SaveSnapshot(ctx *gin.Context) {
+	if !c.requireStore(ctx) {
+		return
+	}
+
+	var req MockChainSnapshotRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	snapshot, err := c.store.SaveSnapshot(req.Name)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, snapshot)
+}
+
+// RestoreSnapshot 将所有模拟链恢复到快照时的状态
+func (c *MockChainController) RestoreSnapshot(ctx *gin.Context) {
+	if !c.requireStore(ctx) {
+		return
+	}
+
+	if err := c.store.RestoreSnapshot(ctx.Param("name")); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "snapshot restored"})
+}
+
+func (c *MockChainController) DeleteSnapshot(ctx *gin.Context) {
+	if !c.requireStore(ctx) {
+		return
+	}
+
+	if err := c.store.DeleteSnapshot(ctx.Param("name")); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "snapshot deleted"})
+}
+
+// GetStatus 返回模拟链的链顶、内存池和故障注入设置
+func (c *MockChainController) GetStatus(ctx *gin.Context) {
+	status, err := c.admin.Status(models.Network(ctx.Param("network")))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, status)
+}
+
+// Faucet 向地址注资，交易出块后即可作为充值处理
+func (c *MockChainController) Faucet(ctx *gin.Context) {
+	var req MockFaucetRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	txHash, err := c.admin.Faucet(models.Network(ctx.Param("network")), req.Address, req.Asset, req.Amount)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"tx_hash": txHash})
+}
+
+// Transfer 模拟外部地址的转入
+func (c *MockChainController) Transfer(ctx *gin.Context) {
+	var req MockTransferRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	txHash, err := c.admin.Transfer(models.Network(ctx.Param("network")), req.From, req.To, req.Asset, req.Amount, req.Memo)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"tx_hash": txHash})
+}
+
+// MineBlocks 立即出块
+func (c *MockChainController) MineBlocks(ctx *gin.Context) {
+	var req MockMineRequest
+	if ctx.Request.ContentLength > 0 {
+		if err := ctx.ShouldBindJSON(&req); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if req.Blocks == 0 {
+		req.Blocks = 1
+	}
+
+	blocks, err := c.admin.MineBlocks(models.Network(ctx.Param("network")), req.Blocks)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+// FailTransaction 使内存池中的交易执行失败
+func (c *MockChainController) FailTransaction(ctx *gin.Context) {
+	err := c.admin.FailTransaction(models.Network(ctx.Param("network")), ctx.Param("hash"))
+	if !c.handleTxError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "transaction failed"})
+}
+
+// HoldTransaction 使内存池中的交易保持待确认
+func (c *MockChainController) HoldTransaction(ctx *gin.Context) {
+	err := c.admin.HoldTransaction(models.Network(ctx.Param("network")), ctx.Param("hash"), true)
+	if !c.handleTxError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "transaction held"})
+}
+
+// ReleaseTransaction 恢复被挂起交易的打包
+func (c *MockChainController) ReleaseTransaction(ctx *gin.Context) {
+	err := c.admin.HoldTransaction(models.Network(ctx.Param("network")), ctx.Param("hash"), false)
+	if !c.handleTxError(ctx, err) {
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "transaction released"})
+}
+
+// handleTxError 写入交易操作的错误响应，无错误时返回 true
+func (c *MockChainController) handleTxError(ctx *gin.Context, err error) bool {
+	if errors.Is(err, services.ErrTransactionNotFound) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return false
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// SetFaults 设置 RPC 故障注入，所有比例为 0 时关闭
+func (c *MockChainController) SetFaults(ctx *gin.Context) {
+	var req MockFaultsRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	network := models.Network(ctx.Param("network"))
+	err := c.admin.SetFaults(network, req.ErrorRate, req.LatencyRate, time.Duration(req.LatencyMs)*time.Millisecond)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "faults updated"})
+}
+
+// GetBlocks 返回从链顶往下的区块，默认 20 个
+func (c *MockChainController) GetBlocks(ctx *gin.Context) {
+	count, err := strconv.Atoi(ctx.DefaultQuery("count", "20"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid count"})
+		return
+	}
+
+	blocks, err := c.admin.GetBlocks(models.Network(ctx.Param("network")), count)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"blocks": blocks})
+}
+
+func (c *MockChainController) GetBlock(ctx *gin.Context) {
+	number, err := strconv.ParseUint(ctx.Param("number"), 10, 64)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid block number"})
+		return
+	}
+
+	block, err := c.admin.GetBlock(models.Network(ctx.Param("network")), number)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, block)
+}
+
+func (c *MockChainController) GetMempool(ctx *gin.Context) {
+	transactions, err := c.admin.GetMempool(models.Network(ctx.Param("network")))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"transactions": transactions})
+}
+
+// GetClock 返回服务和模拟链当前使用的时间
+func (c *MockChainController) GetClock(ctx *gin.Context) {
+	now, controllable := c.admin.Clock()
+	ctx.JSON(http.StatusOK, gin.H{"now": now, "controllable": controllable})
+}
+
+// AdvanceClock 推进模拟时钟，只在服务使用 FakeClock 时可用
+func (c *MockChainController) AdvanceClock(ctx *gin.Context) {
+	var req MockClockRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if (req.Seconds != 0) == (req.To != nil) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "exactly one of seconds and to is required"})
+		return
+	}
+
+	var (
+		now time.Time
+		err error
+	)
+	if req.To != nil {
+		now, err = c.admin.SetClock(*req.To)
+	} else {
+		now, err = c.admin.AdvanceClock(time.Duration(req.Seconds) * time.Second)
+	}
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"now": now})
+}
@@ -0,0 +1,36 @@
+// Package controllers/operator_auth.go
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/panaceacode/wallet-demo/config"
+	"net/http"
+	"strings"
+)
+
+// operatorIDKey 认证通过的操作员 ID 在请求上下文中的键
+const operatorIDKey = "operator_id"
+
+// RequireOperator 校验 Authorization: Bearer <令牌> 请求头，令牌无效时拒绝请求，
+// 有效时把令牌所属的操作员 ID 写入请求上下文
+func RequireOperator(policy config.ApprovalPolicy) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		token, found := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer ")
+		if !found {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "operator token is required"})
+			return
+		}
+		operatorID, ok := policy.Authenticate(strings.TrimSpace(token))
+		if !ok {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "invalid operator token"})
+			return
+		}
+		ctx.Set(operatorIDKey, operatorID)
+		ctx.Next()
+	}
+}
+
+// operatorID 返回 RequireOperator 认证的操作员 ID
+func operatorID(ctx *gin.Context) string {
+	return ctx.GetString(operatorIDKey)
+}
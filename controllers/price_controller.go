@@ -0,0 +1,155 @@
+// Package controllers/price_controller.go
+package controllers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/panaceacode/wallet-demo/services"
+	"github.com/shopspring/decimal"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type PriceController struct {
+	oracle *services.PriceOracle
+}
+
+func NewPriceController(oracle *services.PriceOracle) *PriceController {
+	return &PriceController{oracle: oracle}
+}
+
+type RecordPriceRequest struct {
+	Symbol     string          `json:"symbol" binding:"required"`
+	Price      decimal.Decimal `json:"price"`
+	ObservedAt time.Time       `json:"observed_at"` // 为空时取当前时间，可用于补录历史价格
+}
+
+// RecordPrice 录入一条美元价格
+func (c *PriceController) RecordPrice(ctx *gin.Context) {
+	var req RecordPriceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	price, err := c.oracle.RecordPrice(req.Symbol, req.Price, req.ObservedAt, "manual")
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, price)
+}
+
+// Refresh 立即从价格源刷新价格
+func (c *PriceController) Refresh(ctx *gin.Context) {
+	if err := c.oracle.Refresh(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "prices refreshed"})
+}
+
+// GetPrice 返回 at 时刻（默认当前）的美元价格
+func (c *PriceController) GetPrice(ctx *gin.Context) {
+	at, err := queryTime(ctx, "at", c.oracle.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	price, err := c.oracle.PriceAt(ctx.Param("symbol"), at)
+	if errors.Is(err, services.ErrPriceUnavailable) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, price)
+}
+
+// GetPriceHistory 返回时间范围内的价格历史，默认最近 24 小时
+func (c *PriceController) GetPriceHistory(ctx *gin.Context) {
+	endTime, err := queryTime(ctx, "end_time", c.oracle.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	startTime, err := queryTime(ctx, "start_time", endTime.Add(-24*time.Hour))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	prices, err := c.oracle.GetPriceHistory(ctx.Param("symbol"), startTime, endTime)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"prices": prices})
+}
+
+// Convert 按 at 时刻（默认当前）的价格换算金额
+func (c *PriceController) Convert(ctx *gin.Context) {
+	amount, err := decimal.NewFromString(ctx.Query("amount"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid amount"})
+		return
+	}
+	at, err := queryTime(ctx, "at", c.oracle.Now())
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	from, to := ctx.Query("from"), ctx.Query("to")
+	value, err := c.oracle.Convert(amount, from, to, at)
+	if errors.Is(err, services.ErrPriceUnavailable) {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"amount": amount,
+		"from":   from,
+		"to":     to,
+		"value":  value,
+		"at":     at,
+	})
+}
+
+// GetPortfolio 返回用户所有钱包按报告货币（默认 USD）汇总的价值
+func (c *PriceController) GetPortfolio(ctx *gin.Context) {
+	userID, err := strconv.ParseUint(ctx.Param("userId"), 10, 32)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "invalid user id"})
+		return
+	}
+
+	portfolio, err := c.oracle.GetPortfolio(uint(userID), ctx.DefaultQuery("currency", "USD"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, portfolio)
+}
+
+// queryTime 解析 RFC3339 格式的时间查询参数，缺省时返回 def
+func queryTime(ctx *gin.Context, key string, def time.Time) (time.Time, error) {
+	value := ctx.Query(key)
+	if value == "" {
+		return def, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
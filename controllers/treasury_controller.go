@@ -0,0 +1,63 @@
+// Package controllers/treasury_controller.go
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/panaceacode/wallet-demo/models"
+	"github.com/panaceacode/wallet-demo/services"
+	"net/http"
+	"strconv"
+)
+
+type TreasuryController struct {
+	treasury *services.TreasuryService
+}
+
+func NewTreasuryController(treasury *services.TreasuryService) *TreasuryController {
+	return &TreasuryController{treasury: treasury}
+}
+
+func (c *TreasuryController) ListSystemWallets(ctx *gin.Context) {
+	wallets, err := c.treasury.ListSystemWallets()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, wallets)
+}
+
+func (c *TreasuryController) GetTransfers(ctx *gin.Context) {
+	page, err := strconv.Atoi(ctx.DefaultQuery("page", "1"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	pageSize, err := strconv.Atoi(ctx.DefaultQuery("page_size", "10"))
+	if err != nil || pageSize < 1 {
+		pageSize = 10
+	}
+
+	transfers, total, err := c.treasury.GetTransfers(models.Network(ctx.Query("network")), page, pageSize)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{
+		"transfers": transfers,
+		"total":     total,
+		"page":      page,
+		"page_size": pageSize,
+	})
+}
+
+// Run 立即执行一轮归集和热/冷钱包再平衡
+func (c *TreasuryController) Run(ctx *gin.Context) {
+	if err := c.treasury.Run(); err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"message": "treasury run completed"})
+}
@@ -109,7 +109,7 @@ func (c *WalletController) GetTransactions(ctx *gin.Context) {
 	//endTime := ctx.GetTime("end_time")
 
 	startTime := time.Time{}
-	endTime := time.Now()
+	endTime := c.walletService.Now()
 
 	if page == 0 {
 		page = 1
@@ -1,14 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/panaceacode/wallet-demo/config"
 	"github.com/panaceacode/wallet-demo/controllers"
 	"github.com/panaceacode/wallet-demo/services"
+	"log"
+	"net/http"
+	"os"
 )
 
 func main() {
+	// 服务、数据表时间戳和模拟链共用的时钟。模拟环境可改用 config.NewFakeClock(time.Now())，
+	// 通过 /api/mock-chain/clock 推进时间
+	clock := config.Clock(config.SystemClock{})
+
 	cfg := &config.Config{
 		DBType:   "mysql",
 		DBName:   "wallet",
@@ -16,11 +24,13 @@ func main() {
 		Password: "970827", // 替换为你的 MySQL 密码
 		Host:     "localhost",
 		Port:     3306,
+		Clock:    clock,
 	}
 	// 或者使用 SQLite
 	// cfg := &config.Config{
 	//     DBType: "sqlite",
 	//     DBPath: "wallet.db",
+	//     Clock:  clock,
 	// }
 
 	db, err := config.NewDB(cfg)
@@ -33,9 +43,107 @@ func main() {
 	reconciliationService := services.NewReconciliationService(db)
 	walletController := controllers.NewWalletController(walletService, reconciliationService)
 
-	cryptoWalletService := services.NewCryptoWalletService(db)
-	cryptoReconciliationService := services.NewCryptoReconciliationService(db, cryptoWalletService.GetBlockchain())
+	// 加密货币相关配置，确认数等策略可按网络调整
+	cryptoCfg := config.DefaultCryptoConfig()
+	cryptoCfg.Clock = clock
+	// 签名私钥的加密口令，只在开发和模拟链环境下才可设置 WALLET_ALLOW_PLAINTEXT_KEYS=1 以明文保存
+	cryptoCfg.KeyEncryptionSecret = os.Getenv("WALLET_KEY_ENCRYPTION_SECRET")
+	cryptoCfg.AllowPlaintextKeys = os.Getenv("WALLET_ALLOW_PLAINTEXT_KEYS") == "1"
+	// 模拟链管理接口只用于开发和测试环境，设置 WALLET_MOCK_CHAIN_API=1 开启
+	cryptoCfg.MockChainAPI = os.Getenv("WALLET_MOCK_CHAIN_API") == "1"
+	// 审批和指派接口的操作员访问令牌，格式为 operator-1=<令牌>,operator-2=<令牌>
+	cryptoCfg.Approvals.Tokens, err = config.ParseOperatorTokens(os.Getenv("WALLET_OPERATOR_TOKENS"))
+	if err != nil {
+		panic(fmt.Sprintf("failed to read operator tokens: %v", err))
+	}
+
+	// 配置了节点的网络连接真实节点，其余使用模拟链
+	chains, err := services.NewChains(cryptoCfg)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create chains: %v", err))
+	}
+	if cryptoCfg.MockChainAPI && !chains.AllMock() {
+		log.Printf("mock chain API is disabled because some networks use real nodes")
+	}
+	cryptoWalletService, err := services.NewCryptoWalletService(db, chains, cryptoCfg)
+	if err != nil {
+		panic(fmt.Sprintf("failed to create crypto wallet service: %v", err))
+	}
+
+	// 模拟链状态持久化，重启后恢复链上交易和余额
+	var chainStore *services.MockChainStore
+	if cryptoCfg.ChainStateDir != "" {
+		chainStore, err = services.NewMockChainStore(cryptoCfg.ChainStateDir, cryptoWalletService.GetChains())
+		if err != nil {
+			panic(fmt.Sprintf("failed to open chain store: %v", err))
+		}
+		if err := chainStore.Load(); err != nil {
+			panic(fmt.Sprintf("failed to load chain state: %v", err))
+		}
+	}
+	mockChainAdmin := services.NewMockChainAdmin(cryptoWalletService.GetChains(), cryptoWalletService.GetAssets(), clock)
+	mockChainController := controllers.NewMockChainController(chainStore, mockChainAdmin)
+
+	// 模拟链 JSON-RPC 接口，供以太坊工具把模拟链当作本地节点使用
+	for network, addr := range cryptoCfg.MockRPC {
+		rpcServer, err := services.NewMockRPCServer(cryptoWalletService.GetChains(), network)
+		if err != nil {
+			panic(fmt.Sprintf("failed to create %s JSON-RPC server: %v", network, err))
+		}
+		server := &http.Server{Addr: addr, Handler: rpcServer}
+		go func() {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("mock %s JSON-RPC server: %v", network, err)
+			}
+		}()
+		defer server.Close()
+	}
+
+	// 模拟链定时出块
+	blockProducer := services.NewBlockProducer(cryptoWalletService.GetChains(), cryptoCfg.BlockInterval)
+	blockProducer.Start()
+	defer blockProducer.Stop()
+
+	if err := cryptoWalletService.GetAssets().SeedDefaults(); err != nil {
+		panic(fmt.Sprintf("failed to seed assets: %v", err))
+	}
+	treasury := cryptoWalletService.GetTreasury()
+	if err := treasury.EnsureSystemWallets(); err != nil {
+		panic(fmt.Sprintf("failed to create system wallets: %v", err))
+	}
+	cryptoReconciliationService := services.NewCryptoReconciliationService(db, cryptoWalletService.GetChains(), clock)
 	cryptoWalletController := controllers.NewCryptoWalletController(cryptoWalletService, cryptoReconciliationService)
+	addressBookController := controllers.NewAddressBookController(cryptoWalletService.GetAddressBook())
+	treasuryController := controllers.NewTreasuryController(treasury)
+
+	// 价格预言机，配置了价格文件时定期刷新，否则只使用通过接口录入的价格
+	var priceSource services.PriceSource
+	if cryptoCfg.Pricing.SourceFile != "" {
+		priceSource = services.NewFilePriceSource(cryptoCfg.Pricing.SourceFile)
+	}
+	priceOracle := services.NewPriceOracle(db, priceSource, cryptoCfg)
+	priceOracle.Start()
+	defer priceOracle.Stop()
+	priceController := controllers.NewPriceController(priceOracle)
+
+	// 发件箱广播，重试提交后未能立即发出的交易
+	broadcaster := cryptoWalletService.GetBroadcaster()
+	broadcaster.Start()
+	defer broadcaster.Stop()
+
+	// 提现确认跟踪
+	withdrawalTracker := services.NewWithdrawalTracker(db, cryptoWalletService.GetChains(), cryptoCfg, cryptoCfg.TrackerInterval)
+	withdrawalTracker.Start()
+	defer withdrawalTracker.Stop()
+
+	// 链重组检测
+	reorgDetector := services.NewReorgDetector(db, cryptoWalletService.GetChains(), cryptoCfg.ReorgInterval)
+	reorgDetector.Start()
+	defer reorgDetector.Stop()
+
+	// 用户地址归集及热/冷钱包再平衡
+	treasury.Start()
+	defer treasury.Stop()
 
 	r := gin.Default()
 
@@ -58,13 +166,77 @@ func main() {
 		cryptoWallets := api.Group("/crypto-wallets")
 		{
 			cryptoWallets.POST("/", cryptoWalletController.CreateWallet)
+			cryptoWallets.GET("/confirmation-policy", cryptoWalletController.GetConfirmationPolicy)
+			cryptoWallets.GET("/fees/:network", cryptoWalletController.EstimateFees)
+			cryptoWallets.GET("/assets", cryptoWalletController.ListAssets)
+			cryptoWallets.POST("/assets", cryptoWalletController.RegisterAsset)
+			cryptoWallets.GET("/nonces/:network/:address", cryptoWalletController.InspectNonces)
+			cryptoWallets.POST("/validate-address", cryptoWalletController.ValidateAddress)
+			cryptoWallets.GET("/address-book", addressBookController.ListEntries)
+			cryptoWallets.POST("/address-book", addressBookController.AddEntry)
+			cryptoWallets.DELETE("/address-book/:entryId", addressBookController.RemoveEntry)
+			cryptoWallets.PUT("/address-book/whitelist", addressBookController.SetWhitelistOnly)
+			cryptoWallets.GET("/address-book/audit", addressBookController.GetAuditLog)
+			cryptoWallets.POST("/transactions/:txId/replace", cryptoWalletController.ReplaceWithdrawal)
+			cryptoWallets.GET("/approvals", cryptoWalletController.ListPendingApprovals)
+			cryptoWallets.GET("/outbox", cryptoWalletController.ListBroadcastIntents)
+			cryptoWallets.POST("/deposits/shared", cryptoWalletController.ProcessSharedDeposit)
+			cryptoWallets.GET("/deposits/unassigned", cryptoWalletController.ListUnassignedDeposits)
+
+			// 操作员接口，操作员身份取自访问令牌
+			operator := cryptoWallets.Group("", controllers.RequireOperator(cryptoCfg.Approvals))
+			operator.POST("/deposits/unassigned/:depositId/assign", cryptoWalletController.AssignDeposit)
+			operator.POST("/transactions/:txId/approve", cryptoWalletController.ApproveWithdrawal)
+			operator.POST("/transactions/:txId/reject", cryptoWalletController.RejectWithdrawal)
+
+			cryptoWallets.GET("/treasury/wallets", treasuryController.ListSystemWallets)
+			cryptoWallets.GET("/treasury/transfers", treasuryController.GetTransfers)
+			cryptoWallets.POST("/treasury/run", treasuryController.Run)
 			cryptoWallets.POST("/:id/deposit", cryptoWalletController.ProcessDeposit)
 			cryptoWallets.POST("/:id/withdraw", cryptoWalletController.Withdraw)
+			cryptoWallets.GET("/:id/balances", cryptoWalletController.GetBalances)
+			cryptoWallets.GET("/:id/addresses", cryptoWalletController.ListAddresses)
+			cryptoWallets.POST("/:id/addresses", cryptoWalletController.NewDepositAddress)
 			cryptoWallets.GET("/:id/transactions", cryptoWalletController.GetTransactions)
 			cryptoWallets.POST("/:id/reconciliation", cryptoWalletController.PerformReconciliation)
 			cryptoWallets.GET("/:id/reconciliation/history", cryptoWalletController.GetReconciliationHistory)
 		}
 
+		// 价格和资产估值路由
+		prices := api.Group("/prices")
+		{
+			prices.POST("/", priceController.RecordPrice)
+			prices.POST("/refresh", priceController.Refresh)
+			prices.GET("/convert", priceController.Convert)
+			prices.GET("/:symbol", priceController.GetPrice)
+			prices.GET("/:symbol/history", priceController.GetPriceHistory)
+		}
+		api.GET("/users/:userId/portfolio", priceController.GetPortfolio)
+
+		// 模拟链管理路由，只在显式开启且所有网络都使用模拟链时注册
+		if cryptoCfg.MockChainAPI && chains.AllMock() {
+			mockChain := api.Group("/mock-chain")
+			mockChain.GET("/snapshots", mockChainController.ListSnapshots)
+			mockChain.POST("/snapshots", mockChainController.SaveSnapshot)
+			mockChain.POST("/snapshots/:name/restore", mockChainController.RestoreSnapshot)
+			mockChain.DELETE("/snapshots/:name", mockChainController.DeleteSnapshot)
+
+			mockChain.GET("/clock", mockChainController.GetClock)
+			mockChain.POST("/clock", mockChainController.AdvanceClock)
+
+			mockChain.GET("/:network", mockChainController.GetStatus)
+			mockChain.POST("/:network/faucet", mockChainController.Faucet)
+			mockChain.POST("/:network/transfers", mockChainController.Transfer)
+			mockChain.POST("/:network/mine", mockChainController.MineBlocks)
+			mockChain.POST("/:network/transactions/:hash/fail", mockChainController.FailTransaction)
+			mockChain.POST("/:network/transactions/:hash/hold", mockChainController.HoldTransaction)
+			mockChain.POST("/:network/transactions/:hash/release", mockChainController.ReleaseTransaction)
+			mockChain.PUT("/:network/faults", mockChainController.SetFaults)
+			mockChain.GET("/:network/blocks", mockChainController.GetBlocks)
+			mockChain.GET("/:network/blocks/:number", mockChainController.GetBlock)
+			mockChain.GET("/:network/mempool", mockChainController.GetMempool)
+		}
+
 	}
 
 	err = r.Run(":8080")
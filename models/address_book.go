@@ -0,0 +1,40 @@
+package models
+
+import "time"
+
+// 地址簿审计动作
+const (
+	AddressBookActionAdded             = "added"
+	AddressBookActionRemoved           = "removed"
+	AddressBookActionWhitelistEnabled  = "whitelist_enabled"
+	AddressBookActionWhitelistDisabled = "whitelist_disabled"
+)
+
+// AddressBookEntry 用户保存的提现地址，ActiveAt 之前处于冷静期，不能用于白名单提现
+type AddressBookEntry struct {
+	Base
+	UserID   uint      `gorm:"not null;uniqueIndex:idx_address_book_user_network_address"`
+	Network  Network   `gorm:"size:10;not null;uniqueIndex:idx_address_book_user_network_address"`
+	Address  string    `gorm:"size:100;not null;uniqueIndex:idx_address_book_user_network_address"`
+	Label    string    `gorm:"size:100"`
+	ActiveAt time.Time `gorm:"not null"`
+}
+
+// AddressBookSetting 用户的提现地址策略，WhitelistOnly 时只能提现到地址簿中已生效的地址
+type AddressBookSetting struct {
+	Base
+	UserID        uint `gorm:"not null;uniqueIndex"`
+	WhitelistOnly bool `gorm:"not null;default:false"`
+	// WhitelistDisableAt 关闭白名单模式的生效时间，关闭同样需要经过冷静期，在此之前仍按白名单模式校验
+	WhitelistDisableAt *time.Time
+}
+
+// AddressBookAudit 地址簿变更的审计记录
+type AddressBookAudit struct {
+	Base
+	UserID  uint    `gorm:"not null;index"`
+	Action  string  `gorm:"size:30;not null"`
+	Network Network `gorm:"size:10"`
+	Address string  `gorm:"size:100"`
+	Label   string  `gorm:"size:100"`
+}
@@ -0,0 +1,9 @@
+package models
+
+// AddressNonce 账户模型网络上发送地址的下一个可用 nonce
+type AddressNonce struct {
+	Base
+	Network   Network `gorm:"size:10;not null;uniqueIndex:idx_address_nonces_network_address"`
+	Address   string  `gorm:"size:100;not null;uniqueIndex:idx_address_nonces_network_address"`
+	NextNonce uint64  `gorm:"not null;default:0"`
+}
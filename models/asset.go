@@ -0,0 +1,24 @@
+package models
+
+// 资产标准
+const (
+	AssetStandardNative = "native"
+	AssetStandardERC20  = "ERC20"
+	AssetStandardBEP20  = "BEP20"
+	AssetStandardTRC20  = "TRC20"
+)
+
+// Asset 某个网络上的资产，原生币的 ContractAddress 为空
+type Asset struct {
+	Base
+	Network         Network `gorm:"size:10;not null;uniqueIndex:idx_assets_network_symbol"`
+	Symbol          string  `gorm:"size:20;not null;uniqueIndex:idx_assets_network_symbol"`
+	ContractAddress string  `gorm:"size:100;index"`
+	Decimals        int32   `gorm:"not null"`
+	Standard        string  `gorm:"size:10;not null"`
+}
+
+// IsNative 是否为网络原生币
+func (a *Asset) IsNative() bool {
+	return a.ContractAddress == ""
+}
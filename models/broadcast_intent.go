@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// 广播意图状态
+const (
+	BroadcastIntentPending = "pending" // 等待广播或重试
+	BroadcastIntentSent    = "sent"    // 已被链接受
+	BroadcastIntentFailed  = "failed"  // 重试耗尽，资金已释放
+)
+
+// 广播意图的来源
+const (
+	BroadcastSourceWithdrawal       = "withdrawal"        // 提现，对应 CryptoTransaction
+	BroadcastSourceReplacement      = "replacement"       // 提现加速替换，对应 CryptoTransaction
+	BroadcastSourceInternalTransfer = "internal_transfer" // 内部划转，对应 InternalTransfer
+)
+
+// BroadcastIntent 待发送的链上交易（发件箱）。业务事务内只扣款并写入意图，
+// 由广播器在事务外签名、发送并回写结果。金额和费用均以链上最小单位记录
+type BroadcastIntent struct {
+	Base
+	SourceType     string  `gorm:"size:20;not null;index:idx_broadcast_intents_source"`
+	SourceID       uint    `gorm:"not null;index:idx_broadcast_intents_source"`
+	Network        Network `gorm:"size:10;not null"`
+	FromAddress    string  `gorm:"size:100;not null"`
+	ToAddress      string  `gorm:"size:100;not null"`
+	Memo           string  `gorm:"size:64"`
+	Amount         string  `gorm:"size:80;not null"`
+	TokenContract  string  `gorm:"size:100"`
+	Fee            string  `gorm:"size:50"`
+	GasPrice       string  `gorm:"size:50"` // EIP-1559 交易为单价上限（max fee per gas）
+	MaxPriorityFee string  `gorm:"size:50"` // EIP-1559 小费上限，其余网络为空
+	GasLimit       uint64  `gorm:"default:0"`
+	ExtraFee       string  `gorm:"size:50"` // 替换交易在原交易之外额外冻结的手续费
+	Nonce          *uint64 // 签名时分配，替换交易沿用原交易的 nonce
+	TxHash         string  `gorm:"size:100;index"` // 签名后即写入，发送前已可追溯
+	RawTx          string  `gorm:"type:text"`
+	Status         string  `gorm:"size:20;not null;index"`
+	Attempts       int     `gorm:"default:0"`
+	NextAttemptAt  time.Time
+	LastError      string `gorm:"size:255"`
+}
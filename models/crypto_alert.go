@@ -0,0 +1,20 @@
+package models
+
+type AlertType string
+
+const (
+	AlertTypeReorgClawback  AlertType = "reorg_clawback"
+	AlertTypeReorgRefund    AlertType = "reorg_refund"
+	AlertTypeReorgReconfirm AlertType = "reorg_reconfirm"
+)
+
+// CryptoAlert 需要人工关注的异常事件，例如重组导致的入账回滚
+type CryptoAlert struct {
+	Base
+	WalletID      uint      `gorm:"not null;index"`
+	TransactionID uint      `gorm:"not null;index"`
+	TxHash        string    `gorm:"size:100;index"`
+	Type          AlertType `gorm:"not null;size:30"`
+	Message       string    `gorm:"type:text"`
+	Resolved      bool      `gorm:"not null;default:false"`
+}
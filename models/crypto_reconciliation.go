@@ -4,13 +4,23 @@ import "time"
 
 type CryptoReconciliation struct {
 	Base
-	WalletID       uint `gorm:"not null;index"`
-	StartTime      time.Time
-	EndTime        time.Time
-	SystemBalance  float64
-	ChainBalance   float64
-	Status         ReconciliationStatus
-	Difference     float64
-	MismatchReason string `gorm:"type:text"`
-	UnmatchedTxs   string `gorm:"type:text"` // JSON array of unmatched transaction hashes
+	WalletID  uint   `gorm:"not null;index"`
+	Asset     string `gorm:"size:20;not null;default:''"` // 对账的资产符号
+	StartTime time.Time
+	EndTime   time.Time
+	// StartBlock、EndBlock 按区块范围对账时的区块范围，按时间对账时为空；
+	// 按区块对账时 StartTime、EndTime 为范围内链上交易的时间范围
+	StartBlock    *uint64
+	EndBlock      *uint64
+	SystemBalance float64
+	// OpeningChainBalance 期初的链上余额，ChainBalance 为期末的链上余额
+	OpeningChainBalance float64
+	ChainBalance        float64
+	Status              ReconciliationStatus
+	Difference          float64
+	// InternalAdjustment 归集、gas 补充和热钱包提现等不经过用户地址的资金流动，
+	// Difference = SystemBalance + InternalAdjustment - ChainBalance
+	InternalAdjustment float64
+	MismatchReason     string `gorm:"type:text"`
+	UnmatchedTxs       string `gorm:"type:text"` // JSON array of unmatched transaction hashes
 }
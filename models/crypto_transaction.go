@@ -1,18 +1,54 @@
 package models
 
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// 加密货币交易状态，提现交易按 created -> broadcast -> confirming -> completed/failed 流转，
+// 大额提现在 created 之前先经过 pending_approval
+const (
+	CryptoTxStatusPendingApproval = "pending_approval"
+	CryptoTxStatusApproved        = "approved" // 审批通过但手续费无法重新冻结，再次批准时重试
+	CryptoTxStatusCreated         = "created"
+	CryptoTxStatusBroadcast       = "broadcast"
+	CryptoTxStatusConfirming      = "confirming"
+	CryptoTxStatusCompleted       = "completed"
+	CryptoTxStatusFailed          = "failed"
+	CryptoTxStatusReversed        = "reversed" // 入账后所在区块被重组丢弃
+	CryptoTxStatusRejected        = "rejected" // 审批被拒绝
+	CryptoTxStatusExpired         = "expired"  // 审批超时
+)
+
 type CryptoTransaction struct {
 	Base
-	WalletID      uint            `gorm:"not null;index"`
-	Type          TransactionType `gorm:"not null;size:20"`
-	Network       Network         `gorm:"size:10;not null"`
-	FromAddress   string          `gorm:"size:100;not null"`
-	ToAddress     string          `gorm:"size:100;not null"`
-	Amount        float64         `gorm:"not null"`
-	Status        string          `gorm:"not null;default:'pending'"`
-	TxHash        string          `gorm:"size:100;index"`
-	Confirmations int             `gorm:"default:0"`
-	BlockNumber   uint64          `gorm:"default:0"`
-	GasPrice      string          `gorm:"size:50"`
-	GasUsed       uint64          `gorm:"default:0"`
-	Raw           string          `gorm:"type:text"`
+	WalletID              uint            `gorm:"not null;index"`
+	Type                  TransactionType `gorm:"not null;size:20"`
+	Network               Network         `gorm:"size:10;not null"`
+	Asset                 string          `gorm:"size:20;not null;default:''"` // 资产符号，早期的原生币记录为空
+	FromAddress           string          `gorm:"size:100;not null"`
+	ToAddress             string          `gorm:"size:100;not null"`
+	Memo                  string          `gorm:"size:64"` // 备注/标签，共享地址充值据此识别收款钱包
+	Amount                float64         `gorm:"not null"`
+	Status                string          `gorm:"not null;default:'pending'"`
+	TxHash                string          `gorm:"size:100;index"`
+	Confirmations         int             `gorm:"default:0"`
+	RequiredConfirmations int             `gorm:"default:0"` // 按确认策略计算的所需确认数
+	BlockNumber           uint64          `gorm:"default:0"`
+	BlockHash             string          `gorm:"size:100"`
+	MissingSinceBlock     uint64          `gorm:"default:0"`          // 交易在链上查不到（提现且无法重新发送）时首次发现的链高度，0 表示正常
+	Fee                   float64         `gorm:"not null;default:0"` // 实际手续费，以原生币计
+	GasPrice              string          `gorm:"size:50"`
+	GasUsed               uint64          `gorm:"default:0"`
+	Nonce                 *uint64         // 账户模型网络的发送方 nonce
+	ReplacedTxHash        string          `gorm:"size:100"` // 被加速替换掉的原交易哈希
+	Raw                   string          `gorm:"type:text"`
+	RefTransactionID      uint            `gorm:"default:0;index"` // 补偿记录关联的原始交易
+	FailureReason         string          `gorm:"size:255"`
+	ApprovalsRequired     int             `gorm:"default:0"` // 大额提现需要的审批人数
+	ApprovalExpiresAt     *time.Time      // 审批截止时间，超时未批准的提现将被取消
+	FiatCurrency          string          `gorm:"size:10"`                       // 估值货币，没有可用价格时为空
+	FiatPrice             decimal.Decimal `gorm:"type:decimal(36,18);default:0"` // 记录创建时资产的单价
+	FiatValue             decimal.Decimal `gorm:"type:decimal(36,18);default:0"` // 金额按单价折算的价值
+	PricedAt              *time.Time      // 所用价格的观测时间
 }
@@ -9,11 +9,36 @@ const (
 	NetworkTRON Network = "TRON"
 )
 
+// Decimals 返回网络原生币的精度（最小单位的位数）
+func (n Network) Decimals() int32 {
+	switch n {
+	case NetworkBTC:
+		return 8 // satoshi
+	case NetworkTRON:
+		return 6 // sun
+	default:
+		return 18 // wei
+	}
+}
+
+// NativeSymbol 返回网络原生币的符号
+func (n Network) NativeSymbol() string {
+	switch n {
+	case NetworkBSC:
+		return "BNB"
+	case NetworkTRON:
+		return "TRX"
+	default:
+		return string(n)
+	}
+}
+
 type CryptoWallet struct {
 	Base
 	UserID      uint    `gorm:"not null;index"`
 	Network     Network `gorm:"size:10;not null"`
-	Address     string  `gorm:"size:100;not null;uniqueIndex"`
+	Address     string  `gorm:"size:100;not null;index"` // 当前充值地址，共享充值地址模式下多个钱包使用同一地址
+	Memo        string  `gorm:"size:64;index"`           // 共享充值地址模式下分配给钱包的充值备注
 	Balance     float64 `gorm:"not null;default:0"`
 	PrivateKey  string  `gorm:"size:255"`  // Consider encryption
 	AddressPath string  `gorm:"size:50"`   // BIP44 derivation path
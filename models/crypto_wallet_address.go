@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// 钱包地址状态
+const (
+	WalletAddressActive  = "active"  // 当前对外展示的充值地址
+	WalletAddressRetired = "retired" // 已轮换，仍继续监听入账
+)
+
+// CryptoWalletAddress 钱包拥有的充值地址。轮换后旧地址保留并继续归属于该钱包，
+// CryptoWallet.Address 始终为当前的充值地址
+type CryptoWalletAddress struct {
+	Base
+	WalletID  uint    `gorm:"not null;index"`
+	Network   Network `gorm:"size:10;not null"`
+	Address   string  `gorm:"size:100;not null;index"`
+	Sequence  int     `gorm:"not null;default:0"` // 钱包内的地址序号，从 0 开始
+	Status    string  `gorm:"size:20;not null"`
+	RetiredAt *time.Time
+}
@@ -0,0 +1,9 @@
+package models
+
+// CryptoWalletBalance 钱包地址上代币资产的余额，原生币余额仍记录在 CryptoWallet.Balance
+type CryptoWalletBalance struct {
+	Base
+	WalletID uint    `gorm:"not null;uniqueIndex:idx_crypto_wallet_balances_wallet_asset"`
+	Asset    string  `gorm:"size:20;not null;uniqueIndex:idx_crypto_wallet_balances_wallet_asset"`
+	Balance  float64 `gorm:"not null;default:0"`
+}
@@ -0,0 +1,31 @@
+package models
+
+// 内部划转类型
+const (
+	InternalTransferSweep    = "sweep"      // 用户充值地址归集到热钱包
+	InternalTransferGas      = "gas_top_up" // 热钱包为充值地址补充代币归集所需的手续费
+	InternalTransferTopUp    = "top_up"     // 冷钱包补充热钱包
+	InternalTransferOverflow = "overflow"   // 热钱包超额部分转入冷钱包
+)
+
+// InternalTransfer 平台地址之间的资金划转，不影响用户的入账余额。
+// 状态沿用 CryptoTransaction 的 created/broadcast/completed/failed
+type InternalTransfer struct {
+	Base
+	Network     Network `gorm:"size:10;not null;index"`
+	Asset       string  `gorm:"size:20;not null"`
+	Kind        string  `gorm:"size:20;not null"`
+	WalletID    uint    `gorm:"default:0;index"` // 涉及的用户钱包，热冷钱包之间的划转为 0
+	FromAddress string  `gorm:"size:100;not null;index"`
+	ToAddress   string  `gorm:"size:100;not null;index"`
+	Amount      float64 `gorm:"not null"`
+	Fee         float64 `gorm:"not null;default:0"` // 以原生币计，由 FromAddress 支付
+	TxHash      string  `gorm:"size:100;index"`
+	Nonce       *uint64
+	BlockNumber uint64 `gorm:"default:0"`
+	BlockHash   string `gorm:"size:100"`
+	// 交易在链上查不到且无法重新发送时首次发现的链高度，0 表示正常
+	MissingSinceBlock uint64 `gorm:"default:0"`
+	Status            string `gorm:"size:20;not null"`
+	FailureReason     string `gorm:"size:255"`
+}
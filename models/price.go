@@ -0,0 +1,18 @@
+package models
+
+import (
+	"github.com/shopspring/decimal"
+	"time"
+)
+
+// PriceCurrency 价格统一以美元计价，其他法币通过各自的美元汇率换算
+const PriceCurrency = "USD"
+
+// Price 资产或法币在某一时刻的美元价格，按观测时间保留历史
+type Price struct {
+	Base
+	Symbol     string          `gorm:"size:20;not null;index:idx_prices_symbol_observed"`
+	Price      decimal.Decimal `gorm:"type:decimal(36,18);not null"`
+	Source     string          `gorm:"size:20;not null"`
+	ObservedAt time.Time       `gorm:"not null;index:idx_prices_symbol_observed"`
+}
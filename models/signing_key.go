@@ -0,0 +1,10 @@
+package models
+
+// SigningKey 平台托管地址的签名私钥，每个地址一把。
+// 配置了加密口令时 PrivateKey 为 AES-GCM 密文，否则为十六进制明文
+type SigningKey struct {
+	Base
+	Network    Network `gorm:"size:10;not null"`
+	Address    string  `gorm:"size:100;not null;uniqueIndex"`
+	PrivateKey string  `gorm:"size:255;not null"`
+}
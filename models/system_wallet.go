@@ -0,0 +1,17 @@
+package models
+
+// 平台钱包层级
+const (
+	WalletTierHot  = "hot"  // 在线签名，用于提现
+	WalletTierCold = "cold" // 离线保管，存放超出热钱包额度的资金
+
+	WalletTierDeposit = "deposit" // 共享充值地址，按备注区分收款钱包
+)
+
+// SystemWallet 平台持有的热/冷钱包及共享充值地址，每个网络每个层级一个
+type SystemWallet struct {
+	Base
+	Network Network `gorm:"size:10;not null;uniqueIndex:idx_system_wallets_network_tier"`
+	Tier    string  `gorm:"size:10;not null;uniqueIndex:idx_system_wallets_network_tier"`
+	Address string  `gorm:"size:100;not null;uniqueIndex"`
+}
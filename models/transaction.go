@@ -8,6 +8,8 @@ type TransactionType string
 const (
 	TransactionDeposit  TransactionType = "deposit"
 	TransactionWithdraw TransactionType = "withdraw"
+	TransactionRefund   TransactionType = "refund"
+	TransactionReversal TransactionType = "reversal"
 )
 
 type Transaction struct {
@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// 待分配充值状态
+const (
+	UnassignedDepositPending  = "pending"
+	UnassignedDepositAssigned = "assigned"
+)
+
+// 充值进入待分配队列的原因
+const (
+	UnassignedReasonMissingMemo = "missing_memo"
+	UnassignedReasonUnknownMemo = "unknown_memo"
+)
+
+// UnassignedDeposit 转入共享充值地址但备注缺失或无法匹配钱包的充值，
+// 等待操作员人工分配后才入账
+type UnassignedDeposit struct {
+	Base
+	Network       Network `gorm:"size:10;not null;index"`
+	Asset         string  `gorm:"size:20;not null"`
+	FromAddress   string  `gorm:"size:100;not null"`
+	ToAddress     string  `gorm:"size:100;not null"`
+	Amount        float64 `gorm:"not null"`
+	Memo          string  `gorm:"size:64"`
+	TxHash        string  `gorm:"size:100;not null;uniqueIndex"`
+	BlockNumber   uint64  `gorm:"default:0"`
+	Reason        string  `gorm:"size:20;not null"`
+	Status        string  `gorm:"size:20;not null;index"`
+	WalletID      uint    `gorm:"default:0"` // 分配到的钱包
+	TransactionID uint    `gorm:"default:0"` // 分配后生成的充值记录
+	AssignedBy    string  `gorm:"size:50"`
+	AssignedAt    *time.Time
+}
@@ -0,0 +1,16 @@
+package models
+
+// 审批决定
+const (
+	ApprovalDecisionApproved = "approved"
+	ApprovalDecisionRejected = "rejected"
+)
+
+// WithdrawalApproval 操作员对大额提现的一次审批，同一操作员对同一笔提现只能审批一次
+type WithdrawalApproval struct {
+	Base
+	TransactionID uint   `gorm:"not null;uniqueIndex:idx_withdrawal_approvals_tx_operator"`
+	OperatorID    string `gorm:"size:50;not null;uniqueIndex:idx_withdrawal_approvals_tx_operator"`
+	Decision      string `gorm:"size:10;not null"`
+	Comment       string `gorm:"size:255"`
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"time"
+)
+
+var (
+	// ErrAddressNotWhitelisted 白名单模式下目标地址不在地址簿中
+	ErrAddressNotWhitelisted = errors.New("destination address is not in the address book")
+	// ErrAddressCoolingOff 目标地址仍处于冷静期
+	ErrAddressCoolingOff = errors.New("destination address is still in its cooling-off period")
+)
+
+// AddressBookService 管理用户的提现地址簿和白名单模式，所有变更写入审计记录
+type AddressBookService struct {
+	db        *gorm.DB
+	validator *AddressValidator
+	cfg       *config.CryptoConfig
+}
+
+func NewAddressBookService(db *gorm.DB, validator *AddressValidator, cfg *config.CryptoConfig) *AddressBookService {
+	return &AddressBookService{db: db, validator: validator, cfg: cfg}
+}
+
+// AddEntry 添加地址，地址需通过网络校验，冷静期结束后才能用于白名单提现
+func (s *AddressBookService) AddEntry(userID uint, network models.Network, address, label string) (*models.AddressBookEntry, error) {
+	if err := s.validator.ValidateAddress(network, address); err != nil {
+		return nil, err
+	}
+
+	entry := &models.AddressBookEntry{
+		UserID:   userID,
+		Network:  network,
+		Address:  address,
+		Label:    label,
+		ActiveAt: s.cfg.Clock.Now().Add(s.cfg.AddressCoolingOff),
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.AddressBookEntry{}).
+			Where("user_id = ? AND network = ? AND address = ?", userID, network, address).
+			Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return fmt.Errorf("address already exists in the address book")
+		}
+
+		if err := tx.Create(entry).Error; err != nil {
+			return err
+		}
+		return s.audit(tx, userID, models.AddressBookActionAdded, network, address, label)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// RemoveEntry 删除地址
+func (s *AddressBookService) RemoveEntry(userID, entryID uint) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var entry models.AddressBookEntry
+		if err := tx.Where("id = ? AND user_id = ?", entryID, userID).First(&entry).Error; err != nil {
+			return fmt.Errorf("address book entry not found: %v", err)
+		}
+
+		if err := tx.Delete(&entry).Error; err != nil {
+			return err
+		}
+		return s.audit(tx, userID, models.AddressBookActionRemoved, entry.Network, entry.Address, entry.Label)
+	})
+}
+
+// ListEntries 返回用户的地址簿，network 为空时返回全部网络
+func (s *AddressBookService) ListEntries(userID uint, network models.Network) ([]models.AddressBookEntry, error) {
+	var entries []models.AddressBookEntry
+	query := s.db.Where("user_id = ?", userID).Order("created_at DESC")
+	if network != "" {
+		query = query.Where("network = ?", network)
+	}
+	err := query.Find(&entries).Error
+	return entries, err
+}
+
+// GetSetting 返回用户的地址簿策略，未设置时默认关闭白名单模式
+func (s *AddressBookService) GetSetting(userID uint) (*models.AddressBookSetting, error) {
+	setting := models.AddressBookSetting{UserID: userID}
+	err := s.db.Where("user_id = ?", userID).First(&setting).Error
+	if err != nil && err != gorm.ErrRecordNotFound {
+		return nil, err
+	}
+	s.settle(&setting)
+	return &setting, nil
+}
+
+// settle 关闭申请的冷静期已过时，将策略视为已关闭白名单模式
+func (s *AddressBookService) settle(setting *models.AddressBookSetting) {
+	if setting.WhitelistDisableAt != nil && !s.cfg.Clock.Now().Before(*setting.WhitelistDisableAt) {
+		setting.WhitelistOnly = false
+		setting.WhitelistDisableAt = nil
+	}
+}
+
+// SetWhitelistOnly 开启或关闭白名单提现模式。开启立即生效并撤销待生效的关闭；
+// 关闭需经过地址冷静期，防止账户被盗后立即关闭白名单并提现
+func (s *AddressBookService) SetWhitelistOnly(userID uint, enabled bool) (*models.AddressBookSetting, error) {
+	setting := &models.AddressBookSetting{UserID: userID}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).FirstOrCreate(setting).Error; err != nil {
+			return err
+		}
+		s.settle(setting)
+
+		if enabled {
+			if setting.WhitelistOnly && setting.WhitelistDisableAt == nil {
+				return nil
+			}
+			setting.WhitelistOnly = true
+			setting.WhitelistDisableAt = nil
+		} else {
+			// 未开启或关闭已在冷静期中时不重新计时
+			if !setting.WhitelistOnly || setting.WhitelistDisableAt != nil {
+				return nil
+			}
+			disableAt := s.cfg.Clock.Now().Add(s.cfg.AddressCoolingOff)
+			setting.WhitelistDisableAt = &disableAt
+		}
+
+		if err := tx.Model(setting).Updates(map[string]interface{}{
+			"whitelist_only":       setting.WhitelistOnly,
+			"whitelist_disable_at": setting.WhitelistDisableAt,
+		}).Error; err != nil {
+			return err
+		}
+		action := models.AddressBookActionWhitelistDisabled
+		if enabled {
+			action = models.AddressBookActionWhitelistEnabled
+		}
+		return s.audit(tx, userID, action, "", "", "")
+	})
+	if err != nil {
+		return nil, err
+	}
+	return setting, nil
+}
+
+// GetAuditLog 分页返回用户地址簿的审计记录
+func (s *AddressBookService) GetAuditLog(userID uint, page, pageSize int) ([]models.AddressBookAudit, int64, error) {
+	var (
+		audits []models.AddressBookAudit
+		total  int64
+	)
+
+	if err := s.db.Model(&models.AddressBookAudit{}).
+		Where("user_id = ?", userID).
+		Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := s.db.Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&audits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return audits, total, nil
+}
+
+// CheckWithdrawal 白名单模式下（包括关闭尚在冷静期中）校验目标地址是否在地址簿中且已过冷静期
+func (s *AddressBookService) CheckWithdrawal(tx *gorm.DB, userID uint, network models.Network, address string) error {
+	var setting models.AddressBookSetting
+	err := tx.Where("user_id = ?", userID).First(&setting).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	s.settle(&setting)
+	if !setting.WhitelistOnly {
+		return nil
+	}
+
+	var entry models.AddressBookEntry
+	err = tx.Where("user_id = ? AND network = ? AND address = ?", userID, network, address).First(&entry).Error
+	if err == gorm.ErrRecordNotFound {
+		return ErrAddressNotWhitelisted
+	}
+	if err != nil {
+		return err
+	}
+	if s.cfg.Clock.Now().Before(entry.ActiveAt) {
+		return fmt.Errorf("%w: usable after %s", ErrAddressCoolingOff, entry.ActiveAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+func (s *AddressBookService) audit(tx *gorm.DB, userID uint, action string, network models.Network, address, label string) error {
+	return tx.Create(&models.AddressBookAudit{
+		UserID:  userID,
+		Action:  action,
+		Network: network,
+		Address: address,
+		Label:   label,
+	}).Error
+}
@@ -0,0 +1,237 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/crypto/sha3"
+	"math/big"
+	"strings"
+)
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+// base58Encode 按比特币字母表编码，前导零字节编码为 '1'
+func base58Encode(data []byte) string {
+	value := new(big.Int).SetBytes(data)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+
+	var encoded []byte
+	for value.Sign() > 0 {
+		value.DivMod(value, radix, mod)
+		encoded = append(encoded, base58Alphabet[mod.Int64()])
+	}
+	for _, b := range data {
+		if b != 0 {
+			break
+		}
+		encoded = append(encoded, base58Alphabet[0])
+	}
+
+	for i, j := 0, len(encoded)-1; i < j; i, j = i+1, j-1 {
+		encoded[i], encoded[j] = encoded[j], encoded[i]
+	}
+	return string(encoded)
+}
+
+// base58Decode 解码 Base58 字符串
+func base58Decode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty base58 string")
+	}
+
+	value := new(big.Int)
+	radix := big.NewInt(58)
+	for _, c := range s {
+		digit := strings.IndexRune(base58Alphabet, c)
+		if digit < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", c)
+		}
+		value.Mul(value, radix)
+		value.Add(value, big.NewInt(int64(digit)))
+	}
+
+	leadingZeros := 0
+	for leadingZeros < len(s) && s[leadingZeros] == base58Alphabet[0] {
+		leadingZeros++
+	}
+	return append(make([]byte, leadingZeros), value.Bytes()...), nil
+}
+
+// base58CheckEncode 追加双 SHA-256 校验和后进行 Base58 编码
+func base58CheckEncode(payload []byte) string {
+	return base58Encode(append(append([]byte{}, payload...), doubleSHA256(payload)[:4]...))
+}
+
+// base58CheckDecode 解码并校验 Base58Check 字符串，返回去掉校验和的数据，
+// 校验和不一致时 checksumOK 为 false
+func base58CheckDecode(s string) (payload []byte, checksumOK bool, err error) {
+	decoded, err := base58Decode(s)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(decoded) < 5 {
+		return nil, false, fmt.Errorf("base58check data too short")
+	}
+
+	payload, checksum := decoded[:len(decoded)-4], decoded[len(decoded)-4:]
+	return payload, bytes.Equal(checksum, doubleSHA256(payload)[:4]), nil
+}
+
+func doubleSHA256(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// bech32 编码（BIP-173）及 bech32m 编码（BIP-350）
+const bech32Charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+type bech32Encoding uint32
+
+const (
+	encodingBech32  bech32Encoding = 1
+	encodingBech32m bech32Encoding = 0x2bc830a3
+)
+
+func bech32Polymod(values []byte) uint32 {
+	generator := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= generator[i]
+			}
+		}
+	}
+	return chk
+}
+
+func bech32HRPExpand(hrp string) []byte {
+	expanded := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]>>5)
+	}
+	expanded = append(expanded, 0)
+	for i := 0; i < len(hrp); i++ {
+		expanded = append(expanded, hrp[i]&31)
+	}
+	return expanded
+}
+
+// bech32Encode 将 5 位分组的数据编码为 bech32/bech32m 字符串
+func bech32Encode(hrp string, data []byte, encoding bech32Encoding) string {
+	values := append(bech32HRPExpand(hrp), data...)
+	polymod := bech32Polymod(append(values, 0, 0, 0, 0, 0, 0)) ^ uint32(encoding)
+
+	var sb strings.Builder
+	sb.WriteString(hrp)
+	sb.WriteByte('1')
+	for _, d := range data {
+		sb.WriteByte(bech32Charset[d])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(bech32Charset[(polymod>>uint(5*(5-i)))&31])
+	}
+	return sb.String()
+}
+
+// bech32Decode 解码 bech32/bech32m 字符串，返回 hrp、5 位分组的数据（不含校验和）及编码方式。
+// 校验和对两种编码都不成立时 checksumOK 为 false
+func bech32Decode(s string) (hrp string, data []byte, encoding bech32Encoding, checksumOK bool, err error) {
+	if len(s) > 90 {
+		return "", nil, 0, false, fmt.Errorf("bech32 string too long")
+	}
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, 0, false, fmt.Errorf("bech32 string has mixed case")
+	}
+	s = strings.ToLower(s)
+
+	separator := strings.LastIndexByte(s, '1')
+	if separator < 1 || separator+7 > len(s) {
+		return "", nil, 0, false, fmt.Errorf("invalid bech32 separator position")
+	}
+
+	hrp = s[:separator]
+	for _, c := range hrp {
+		if c < 33 || c > 126 {
+			return "", nil, 0, false, fmt.Errorf("invalid bech32 hrp character")
+		}
+	}
+	for _, c := range s[separator+1:] {
+		digit := strings.IndexRune(bech32Charset, c)
+		if digit < 0 {
+			return "", nil, 0, false, fmt.Errorf("invalid bech32 character %q", c)
+		}
+		data = append(data, byte(digit))
+	}
+
+	switch bech32Encoding(bech32Polymod(append(bech32HRPExpand(hrp), data...))) {
+	case encodingBech32:
+		encoding, checksumOK = encodingBech32, true
+	case encodingBech32m:
+		encoding, checksumOK = encodingBech32m, true
+	}
+	return hrp, data[:len(data)-6], encoding, checksumOK, nil
+}
+
+// convertBits 在不同位宽的分组之间转换，如 8 位字节与 bech32 的 5 位分组
+func convertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc    uint32
+		bits   uint
+		result []byte
+		maxv   = uint32(1)<<toBits - 1
+	)
+	for _, value := range data {
+		if uint32(value)>>fromBits != 0 {
+			return nil, fmt.Errorf("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(value)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			result = append(result, byte(acc>>bits&maxv))
+		}
+	}
+
+	if pad {
+		if bits > 0 {
+			result = append(result, byte(acc<<(toBits-bits)&maxv))
+		}
+	} else if bits >= fromBits || acc<<(toBits-bits)&maxv != 0 {
+		return nil, fmt.Errorf("invalid padding")
+	}
+	return result, nil
+}
+
+// encodeSegwitAddress 编码隔离见证地址，版本 0 使用 bech32，其余版本使用 bech32m
+func encodeSegwitAddress(hrp string, version byte, program []byte) string {
+	encoding := encodingBech32
+	if version > 0 {
+		encoding = encodingBech32m
+	}
+	converted, _ := convertBits(program, 8, 5, true)
+	return bech32Encode(hrp, append([]byte{version}, converted...), encoding)
+}
+
+// toChecksumAddress 按 EIP-55 返回带大小写校验的 0x 地址
+func toChecksumAddress(address string) string {
+	lower := strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+
+	hasher := sha3.NewLegacyKeccak256()
+	hasher.Write([]byte(lower))
+	hash := hex.EncodeToString(hasher.Sum(nil))
+
+	checksummed := []byte(lower)
+	for i, c := range checksummed {
+		if c >= 'a' && c <= 'f' && hash[i] >= '8' {
+			checksummed[i] = c - 'a' + 'A'
+		}
+	}
+	return "0x" + string(checksummed)
+}
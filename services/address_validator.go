@@ -0,0 +1,211 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"regexp"
+	"strings"
+)
+
+// 地址校验的错误码
+const (
+	AddressErrEmpty              = "address_empty"
+	AddressErrInvalidFormat      = "invalid_address_format"
+	AddressErrInvalidChecksum    = "invalid_address_checksum"
+	AddressErrWrongNetwork       = "wrong_network_address"
+	AddressErrUnsupportedNetwork = "unsupported_network"
+)
+
+const tronAddressPrefix = 0x41
+
+// BitcoinParams 比特币网络的地址编码参数，Chain 为 getblockchaininfo 返回的网络名
+type BitcoinParams struct {
+	Chain        string
+	HRP          string // 隔离见证地址的 bech32 前缀
+	P2PKHVersion byte
+	P2SHVersion  byte
+}
+
+// BitcoinMainnet 主网参数，未配置比特币节点时模拟链使用主网地址
+var BitcoinMainnet = &BitcoinParams{Chain: "main", HRP: "bc", P2PKHVersion: 0x00, P2SHVersion: 0x05}
+
+// bitcoinNetworks 支持的比特币网络，signet 与测试网的地址格式相同
+var bitcoinNetworks = []*BitcoinParams{
+	BitcoinMainnet,
+	{Chain: "test", HRP: "tb", P2PKHVersion: 0x6f, P2SHVersion: 0xc4},
+	{Chain: "signet", HRP: "tb", P2PKHVersion: 0x6f, P2SHVersion: 0xc4},
+	{Chain: "regtest", HRP: "bcrt", P2PKHVersion: 0x6f, P2SHVersion: 0xc4},
+}
+
+// bitcoinParams 返回配置的比特币节点所在网络的参数，未配置节点时为主网
+func bitcoinParams(cfg *config.CryptoConfig) (*BitcoinParams, error) {
+	policy, exists := cfg.Nodes[models.NetworkBTC]
+	if !exists || policy.Chain == "" {
+		return BitcoinMainnet, nil
+	}
+	for _, params := range bitcoinNetworks {
+		if params.Chain == policy.Chain {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("unsupported bitcoin chain: %s", policy.Chain)
+}
+
+var evmAddressPattern = regexp.MustCompile(`^0[xX][0-9a-fA-F]{40}$`)
+
+// AddressError 地址校验失败的原因，Code 为稳定的错误码
+type AddressError struct {
+	Code    string         `json:"code"`
+	Network models.Network `json:"network"`
+	Message string         `json:"message"`
+}
+
+func (e *AddressError) Error() string {
+	return e.Message
+}
+
+func newAddressError(code string, network models.Network, format string, args ...interface{}) *AddressError {
+	return &AddressError{Code: code, Network: network, Message: fmt.Sprintf(format, args...)}
+}
+
+// AddressValidator 校验各网络的地址，BTC 地址须属于配置的比特币网络
+type AddressValidator struct {
+	btc *BitcoinParams
+}
+
+func NewAddressValidator(btc *BitcoinParams) *AddressValidator {
+	return &AddressValidator{btc: btc}
+}
+
+// ValidateAddress 校验地址是否为 network 上的有效地址，失败时返回 *AddressError。
+// 地址格式不符但属于其他网络时返回 wrong_network_address
+func (v *AddressValidator) ValidateAddress(network models.Network, address string) error {
+	if address == "" {
+		return newAddressError(AddressErrEmpty, network, "address is required")
+	}
+
+	err := v.validateNetworkAddress(network, address)
+	if err == nil {
+		return nil
+	}
+	if err.Code != AddressErrInvalidFormat {
+		return err
+	}
+
+	for _, other := range []models.Network{models.NetworkBTC, models.NetworkETH, models.NetworkTRON} {
+		if other == network || (isEVMNetwork(other) && isEVMNetwork(network)) {
+			continue
+		}
+		if v.validateNetworkAddress(other, address) == nil {
+			return newAddressError(AddressErrWrongNetwork, network,
+				"address %s belongs to %s, not %s", address, other, network)
+		}
+	}
+	return err
+}
+
+func (v *AddressValidator) validateNetworkAddress(network models.Network, address string) *AddressError {
+	switch network {
+	case models.NetworkBTC:
+		return validateBTCAddress(v.btc, address)
+	case models.NetworkETH, models.NetworkBSC:
+		return validateEVMAddress(network, address)
+	case models.NetworkTRON:
+		return validateTRONAddress(address)
+	default:
+		return newAddressError(AddressErrUnsupportedNetwork, network, "unsupported network: %s", network)
+	}
+}
+
+func isEVMNetwork(network models.Network) bool {
+	return network == models.NetworkETH || network == models.NetworkBSC
+}
+
+// validateBTCAddress 支持 P2PKH/P2SH（Base58Check）及隔离见证地址（bech32/bech32m），
+// 地址须属于 params 对应的比特币网络
+func validateBTCAddress(params *BitcoinParams, address string) *AddressError {
+	lower := strings.ToLower(address)
+	if strings.HasPrefix(lower, params.HRP+"1") {
+		return validateSegwitAddress(address)
+	}
+	for _, other := range bitcoinNetworks {
+		if strings.HasPrefix(lower, other.HRP+"1") {
+			return newAddressError(AddressErrWrongNetwork, models.NetworkBTC,
+				"BTC %s address is not allowed on %s", other.Chain, params.Chain)
+		}
+	}
+
+	payload, checksumOK, err := base58CheckDecode(address)
+	if err != nil || len(payload) != 21 {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "invalid BTC address format")
+	}
+	if !checksumOK {
+		return newAddressError(AddressErrInvalidChecksum, models.NetworkBTC, "BTC address checksum mismatch")
+	}
+	if payload[0] == params.P2PKHVersion || payload[0] == params.P2SHVersion {
+		return nil
+	}
+	for _, other := range bitcoinNetworks {
+		if payload[0] == other.P2PKHVersion || payload[0] == other.P2SHVersion {
+			return newAddressError(AddressErrWrongNetwork, models.NetworkBTC,
+				"BTC %s address is not allowed on %s", other.Chain, params.Chain)
+		}
+	}
+	return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "unknown BTC address version 0x%02x", payload[0])
+}
+
+func validateSegwitAddress(address string) *AddressError {
+	_, data, encoding, checksumOK, err := bech32Decode(address)
+	if err != nil {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "invalid BTC address format: %v", err)
+	}
+	if !checksumOK {
+		return newAddressError(AddressErrInvalidChecksum, models.NetworkBTC, "BTC address checksum mismatch")
+	}
+	if len(data) < 1 || data[0] > 16 {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "invalid witness version")
+	}
+
+	version := data[0]
+	program, err := convertBits(data[1:], 5, 8, false)
+	if err != nil || len(program) < 2 || len(program) > 40 {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "invalid witness program")
+	}
+	if version == 0 && len(program) != 20 && len(program) != 32 {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkBTC, "invalid witness v0 program length")
+	}
+	// BIP-350：版本 0 必须使用 bech32，版本 1 及以上必须使用 bech32m
+	if (version == 0) != (encoding == encodingBech32) {
+		return newAddressError(AddressErrInvalidChecksum, models.NetworkBTC, "BTC address uses the wrong bech32 variant")
+	}
+	return nil
+}
+
+// validateEVMAddress 校验 0x 地址，大小写混合时按 EIP-55 校验
+func validateEVMAddress(network models.Network, address string) *AddressError {
+	if !evmAddressPattern.MatchString(address) {
+		return newAddressError(AddressErrInvalidFormat, network, "invalid %s address format", network)
+	}
+
+	hexPart := address[2:]
+	if hexPart == strings.ToLower(hexPart) || hexPart == strings.ToUpper(hexPart) {
+		return nil
+	}
+	if toChecksumAddress(address) != "0x"+hexPart {
+		return newAddressError(AddressErrInvalidChecksum, network, "%s address fails EIP-55 checksum", network)
+	}
+	return nil
+}
+
+// validateTRONAddress 校验 0x41 前缀的 Base58Check 地址
+func validateTRONAddress(address string) *AddressError {
+	payload, checksumOK, err := base58CheckDecode(address)
+	if err != nil || len(payload) != 21 || payload[0] != tronAddressPrefix {
+		return newAddressError(AddressErrInvalidFormat, models.NetworkTRON, "invalid TRON address format")
+	}
+	if !checksumOK {
+		return newAddressError(AddressErrInvalidChecksum, models.NetworkTRON, "TRON address checksum mismatch")
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"strings"
+)
+
+// defaultAssets 默认注册的原生币和稳定币
+var defaultAssets = []models.Asset{
+	{Network: models.NetworkBTC, Symbol: "BTC", Decimals: 8, Standard: models.AssetStandardNative},
+	{Network: models.NetworkETH, Symbol: "ETH", Decimals: 18, Standard: models.AssetStandardNative},
+	{Network: models.NetworkBSC, Symbol: "BNB", Decimals: 18, Standard: models.AssetStandardNative},
+	{Network: models.NetworkTRON, Symbol: "TRX", Decimals: 6, Standard: models.AssetStandardNative},
+
+	{Network: models.NetworkETH, Symbol: "USDT", ContractAddress: "0xdAC17F958D2ee523a2206206994597C13D831ec7", Decimals: 6, Standard: models.AssetStandardERC20},
+	{Network: models.NetworkETH, Symbol: "USDC", ContractAddress: "0xA0b86991c6218b36c1d19D4a2e9Eb0cE3606eB48", Decimals: 6, Standard: models.AssetStandardERC20},
+	{Network: models.NetworkBSC, Symbol: "USDT", ContractAddress: "0x55d398326f99059fF775485246999027B3197955", Decimals: 18, Standard: models.AssetStandardBEP20},
+	{Network: models.NetworkBSC, Symbol: "USDC", ContractAddress: "0x8AC76a51cc950d9822D68b83fE1Ad97B32Cd580d", Decimals: 18, Standard: models.AssetStandardBEP20},
+	{Network: models.NetworkTRON, Symbol: "USDT", ContractAddress: "TR7NHqjeKQxGTCi8q8ZY4pL8otSzgjLj6t", Decimals: 6, Standard: models.AssetStandardTRC20},
+	{Network: models.NetworkTRON, Symbol: "USDC", ContractAddress: "TEkxiTehnzSmSe2XqrBj4w32RUN966rdz8", Decimals: 6, Standard: models.AssetStandardTRC20},
+}
+
+// AssetRegistry 管理各网络支持的资产
+type AssetRegistry struct {
+	db *gorm.DB
+}
+
+func NewAssetRegistry(db *gorm.DB) *AssetRegistry {
+	return &AssetRegistry{db: db}
+}
+
+// SeedDefaults 注册默认资产，已存在的跳过
+func (r *AssetRegistry) SeedDefaults() error {
+	for _, asset := range defaultAssets {
+		asset := asset
+		if err := r.db.Where("network = ? AND symbol = ?", asset.Network, asset.Symbol).
+			FirstOrCreate(&asset).Error; err != nil {
+			return fmt.Errorf("failed to seed asset %s/%s: %v", asset.Network, asset.Symbol, err)
+		}
+	}
+	return nil
+}
+
+// Register 注册新的代币资产
+func (r *AssetRegistry) Register(asset *models.Asset) error {
+	asset.Symbol = strings.ToUpper(asset.Symbol)
+	if asset.ContractAddress == "" {
+		return fmt.Errorf("contract address is required")
+	}
+	if asset.Network == models.NetworkBTC {
+		return fmt.Errorf("network %s does not support tokens", asset.Network)
+	}
+	if asset.Standard == "" {
+		asset.Standard = tokenStandard(asset.Network)
+	}
+	return r.db.Create(asset).Error
+}
+
+// Get 按网络和符号查询资产，symbol 为空时返回原生币
+func (r *AssetRegistry) Get(network models.Network, symbol string) (*models.Asset, error) {
+	if symbol == "" {
+		symbol = network.NativeSymbol()
+	}
+
+	var asset models.Asset
+	err := r.db.Where("network = ? AND symbol = ?", network, strings.ToUpper(symbol)).First(&asset).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("unsupported asset %s on %s", symbol, network)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// FindByContract 按合约地址查询代币
+func (r *AssetRegistry) FindByContract(network models.Network, contract string) (*models.Asset, error) {
+	var asset models.Asset
+	err := r.db.Where("network = ? AND LOWER(contract_address) = ?", network, strings.ToLower(contract)).
+		First(&asset).Error
+	if err != nil {
+		return nil, err
+	}
+	return &asset, nil
+}
+
+// List 返回资产列表，network 为空时返回全部
+func (r *AssetRegistry) List(network models.Network) ([]models.Asset, error) {
+	var assets []models.Asset
+	query := r.db.Order("network ASC, symbol ASC")
+	if network != "" {
+		query = query.Where("network = ?", network)
+	}
+	err := query.Find(&assets).Error
+	return assets, err
+}
+
+func tokenStandard(network models.Network) string {
+	switch network {
+	case models.NetworkBSC:
+		return models.AssetStandardBEP20
+	case models.NetworkTRON:
+		return models.AssetStandardTRC20
+	default:
+		return models.AssetStandardERC20
+	}
+}
@@ -0,0 +1,42 @@
+package services
+
+import (
+	"time"
+)
+
+// blockMiner 由可以手动出块的模拟链实现
+type blockMiner interface {
+	MineBlocks(n int) []MockBlock
+}
+
+// BlockProducer 按固定间隔为所有模拟链出块，打包内存池中的交易
+type BlockProducer struct {
+	chains Chains
+
+	*poller
+}
+
+func NewBlockProducer(chains Chains, interval time.Duration) *BlockProducer {
+	return &BlockProducer{
+		chains: chains,
+		poller: newPoller(interval),
+	}
+}
+
+// Start 启动定时出块，间隔为 0 时只能通过 MineBlocks 手动出块
+func (p *BlockProducer) Start() {
+	if p.interval <= 0 {
+		return
+	}
+	p.run("block producer", p.Produce)
+}
+
+// Produce 为每条模拟链产生一个区块
+func (p *BlockProducer) Produce() error {
+	for _, chain := range p.chains {
+		if miner, ok := chain.(blockMiner); ok {
+			miner.MineBlocks(1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"time"
+)
+
+// ErrTransactionNotFound is returned when a hash is unknown to the chain,
+// including transactions dropped by a reorg
+var ErrTransactionNotFound = errors.New("transaction not found")
+
+// ErrTransactionKnown is returned when a raw transaction has already been
+// accepted by the chain, so rebroadcasting it is a no-op
+var ErrTransactionKnown = errors.New("transaction already known")
+
+// Blockchain is the chain access used by the crypto services, one
+// implementation per network
+type Blockchain interface {
+	GetTransaction(txHash string) (*BlockchainTransaction, error)
+	// SendRawTransaction broadcasts a transaction signed by the network's
+	// Signer and returns its hash
+	SendRawTransaction(raw string) (string, error)
+	GetTransactionHistory(address string, startTime, endTime time.Time) ([]*BlockchainTransaction, error)
+	GetAddressBalance(address string) (*big.Int, error)
+	// GetAddressBalanceAt returns the confirmed balance after block number
+	GetAddressBalanceAt(address string, number uint64) (*big.Int, error)
+	// GetAddressBalanceAtTime returns the confirmed balance after the last
+	// block mined at or before t, the genesis block if t precedes every block
+	GetAddressBalanceAtTime(address string, t time.Time) (*big.Int, error)
+	GetBlockHash(number uint64) (string, error)
+	// GetBlockTime returns the timestamp of the canonical block at the given
+	// height
+	GetBlockTime(number uint64) (time.Time, error)
+	CurrentBlock() uint64
+	// BaseFee returns the network fee level in the chain's fee unit
+	// (wei per gas on account chains, sat/vB on UTXO chains)
+	BaseFee() *big.Int
+}
+
+// AccountChain is implemented by chains that order transactions by a
+// per-sender nonce
+type AccountChain interface {
+	Blockchain
+	// GetNonce returns the next nonce the chain will execute for address
+	GetNonce(address string) (uint64, error)
+}
+
+// TokenChain is implemented by chains that support fungible tokens
+// (ERC-20, BEP-20, TRC-20)
+type TokenChain interface {
+	Blockchain
+	GetTokenBalance(contract, address string) (*big.Int, error)
+	GetTokenBalanceAt(contract, address string, number uint64) (*big.Int, error)
+	GetTokenBalanceAtTime(contract, address string, t time.Time) (*big.Int, error)
+}
+
+// UTXOChain is implemented by chains that track unspent outputs
+type UTXOChain interface {
+	Blockchain
+	ListUnspent(address string) ([]*UTXO, error)
+	// EstimateTransferFee runs coin selection without broadcasting and returns
+	// the fee and virtual size the transfer would have at feeRate sat/vB
+	EstimateTransferFee(from string, amount, feeRate *big.Int) (*big.Int, uint64, error)
+}
+
+// FeeRateChain is implemented by UTXO chains whose node estimates the fee
+// rate needed to confirm within a number of blocks
+type FeeRateChain interface {
+	Blockchain
+	// EstimateFeeRate returns the fee rate in sat/vB expected to confirm
+	// within target blocks
+	EstimateFeeRate(target int) (*big.Int, error)
+}
+
+// BlockchainTransaction represents a transaction on the blockchain
+type BlockchainTransaction struct {
+	Hash           string
+	From           string
+	To             string
+	Amount         *big.Int
+	Memo           string // Memo / destination tag attached by the sender
+	BlockNumber    uint64
+	BlockHash      string
+	Confirmations  int
+	Timestamp      time.Time
+	Status         string // success, pending, queued, replaced, failed
+	Nonce          uint64
+	Fee            *big.Int
+	GasPrice       *big.Int // Price per gas unit (per vbyte / bandwidth point on BTC / TRON)
+	GasUsed        uint64   // Gas used (virtual size / bandwidth on BTC / TRON)
+	Inputs         []TxInput
+	Outputs        []TxOutput
+	TokenTransfers []TokenTransfer // Transfer events emitted by token contracts
+	Raw            []byte          // Raw transaction data
+	// Replaceable reports that the transaction is still in the pool and the
+	// node accepts a transaction with the same nonce and a higher fee
+	Replaceable bool
+}
+
+// TokenTransfer is a Transfer event emitted by a token contract. The
+// transaction itself is sent to the contract with no native value.
+type TokenTransfer struct {
+	Contract string
+	From     string
+	To       string
+	Amount   *big.Int
+	LogIndex uint
+}
+
+// TxInput spends an output of a previous transaction (UTXO chains only)
+type TxInput struct {
+	PrevTxHash string
+	PrevIndex  uint32
+	Address    string
+	Amount     *big.Int
+}
+
+// TxOutput pays an amount to an address (UTXO chains only)
+type TxOutput struct {
+	Index   uint32
+	Address string
+	Amount  *big.Int
+}
+
+// UTXO is an unspent transaction output
+type UTXO struct {
+	TxHash      string
+	Index       uint32
+	Address     string
+	Amount      *big.Int
+	BlockNumber uint64
+}
+
+// SignedTransaction is a transaction ready to be broadcast
+type SignedTransaction struct {
+	Hash string
+	Raw  string // Hex encoded payload accepted by SendRawTransaction
+}
+
+// TxOptions carries the fee parameters chosen by the sender
+type TxOptions struct {
+	Fee            *big.Int // Total fee charged to the sender (account chains)
+	GasPrice       *big.Int // Price per gas unit, or fee rate in sat/vB on UTXO chains
+	MaxPriorityFee *big.Int // EIP-1559 tip cap, GasPrice is then the fee cap
+	GasLimit       uint64
+	Nonce          *uint64 // Sender nonce on account chains, defaults to the next one
+	ChangeAddress  string  // Receives the change output on UTXO chains, defaults to the sender
+	TokenContract  string  // Transfers amount of this token instead of the native coin
+	Memo           string  // Memo / destination tag identifying the beneficiary
+}
+
+func (o *TxOptions) memo() string {
+	if o == nil {
+		return ""
+	}
+	return o.Memo
+}
+
+// Chains 按网络索引的链实现
+type Chains map[models.Network]Blockchain
+
+// NewMockChains 为每个网络创建一条模拟链，BTC 使用 UTXO 模型，
+// TRON 的费用单价为每点带宽的价格，区块和交易时间取自 clock
+func NewMockChains(clock config.Clock) Chains {
+	return Chains{
+		models.NetworkBTC:  NewMockUTXOBlockchain(clock),
+		models.NetworkETH:  newMockEVMBlockchain(mockChainIDs[models.NetworkETH], clock),
+		models.NetworkBSC:  newMockEVMBlockchain(mockChainIDs[models.NetworkBSC], clock),
+		models.NetworkTRON: newMockBlockchain(newAccountLedger(), big.NewInt(tronBandwidthPrice), tronDecoder, clock),
+	}
+}
+
+// NewChains 为配置了节点的网络创建节点客户端，其余网络使用模拟链
+func NewChains(cfg *config.CryptoConfig) (Chains, error) {
+	chains := NewMockChains(cfg.Clock)
+	for network, policy := range cfg.Nodes {
+		var (
+			chain Blockchain
+			err   error
+		)
+		switch network {
+		case models.NetworkETH, models.NetworkBSC:
+			chain, err = NewEthRPCClient(policy)
+		case models.NetworkBTC:
+			chain, err = NewBitcoinRPCClient(policy)
+		default:
+			return nil, fmt.Errorf("node clients are not supported for %s", network)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s node client: %v", network, err)
+		}
+		chains[network] = chain
+	}
+	return chains, nil
+}
+
+// Get 返回指定网络的链
+func (c Chains) Get(network models.Network) (Blockchain, error) {
+	chain, exists := c[network]
+	if !exists {
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+	return chain, nil
+}
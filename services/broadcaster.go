@@ -0,0 +1,398 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"log"
+	"math/big"
+)
+
+// errIntentClaimed 广播意图已由其他进程签名
+var errIntentClaimed = errors.New("broadcast intent already signed by another worker")
+
+// Broadcaster 处理发件箱中的广播意图：在数据库事务外签名并发送交易，
+// 发送前先持久化交易哈希，重发时识别已被链接受的交易，失败按退避策略重试，
+// 重试耗尽后释放冻结的资金
+type Broadcaster struct {
+	db           *gorm.DB
+	chains       Chains
+	signers      Signers
+	keys         *KeyStore
+	nonceManager *NonceManager
+	cfg          *config.CryptoConfig
+	*poller
+}
+
+func NewBroadcaster(db *gorm.DB, chains Chains, signers Signers, keys *KeyStore, nonceManager *NonceManager, cfg *config.CryptoConfig) *Broadcaster {
+	return &Broadcaster{
+		db:           db,
+		chains:       chains,
+		signers:      signers,
+		keys:         keys,
+		nonceManager: nonceManager,
+		cfg:          cfg,
+		poller:       newPoller(cfg.Broadcast.Interval),
+	}
+}
+
+// Start 启动后台轮询
+func (b *Broadcaster) Start() {
+	b.run("broadcaster", b.Poll)
+}
+
+// Enqueue 在调用方事务内写入广播意图，事务提交后才会被广播
+func (b *Broadcaster) Enqueue(tx *gorm.DB, intent *models.BroadcastIntent) error {
+	intent.Status = models.BroadcastIntentPending
+	intent.NextAttemptAt = b.cfg.Clock.Now()
+	if err := tx.Create(intent).Error; err != nil {
+		return fmt.Errorf("failed to record broadcast intent: %v", err)
+	}
+	return nil
+}
+
+// Poll 处理所有到期的广播意图
+func (b *Broadcaster) Poll() error {
+	var intents []models.BroadcastIntent
+	err := b.db.Where("status = ? AND next_attempt_at <= ?", models.BroadcastIntentPending, b.cfg.Clock.Now()).
+		Order("id ASC").
+		Find(&intents).Error
+	if err != nil {
+		return fmt.Errorf("failed to load broadcast intents: %v", err)
+	}
+
+	for _, intent := range intents {
+		if err := b.Process(intent.ID); err != nil {
+			log.Printf("broadcaster: intent %d: %v", intent.ID, err)
+		}
+	}
+	return nil
+}
+
+// Process 签名并发送一个广播意图，可重复调用
+func (b *Broadcaster) Process(intentID uint) error {
+	var intent models.BroadcastIntent
+	if err := b.db.First(&intent, intentID).Error; err != nil {
+		return fmt.Errorf("broadcast intent not found: %v", err)
+	}
+	if intent.Status != models.BroadcastIntentPending {
+		return nil
+	}
+
+	chain, err := b.chains.Get(intent.Network)
+	if err != nil {
+		return err
+	}
+
+	if intent.TxHash == "" {
+		err := b.sign(&intent)
+		if errors.Is(err, errIntentClaimed) {
+			return nil
+		}
+		if err != nil {
+			return b.retry(chain, &intent, err)
+		}
+	}
+
+	// 上次发送可能已被链接受，但结果没来得及回写
+	if _, err := chain.GetTransaction(intent.TxHash); err == nil {
+		return b.complete(chain, &intent)
+	}
+
+	if _, err := chain.SendRawTransaction(intent.RawTx); err != nil && !errors.Is(err, ErrTransactionKnown) {
+		return b.retry(chain, &intent, err)
+	}
+	return b.complete(chain, &intent)
+}
+
+// ListIntents 分页返回广播意图，status 为空时返回全部
+func (b *Broadcaster) ListIntents(status string, page, pageSize int) ([]models.BroadcastIntent, int64, error) {
+	var (
+		intents []models.BroadcastIntent
+		total   int64
+	)
+
+	query := b.db.Model(&models.BroadcastIntent{})
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&intents).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return intents, total, nil
+}
+
+// sign 分配 nonce 并用发送地址的私钥签名，哈希在发送前写入意图和业务记录
+func (b *Broadcaster) sign(intent *models.BroadcastIntent) error {
+	signer, err := b.signers.Get(intent.Network)
+	if err != nil {
+		return err
+	}
+	key, err := b.keys.Key(intent.Network, intent.FromAddress)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		nonce := intent.Nonce
+		if nonce == nil && usesNonces(intent.Network) {
+			allocated, err := b.nonceManager.Allocate(tx, intent.Network, intent.FromAddress)
+			if err != nil {
+				return fmt.Errorf("failed to allocate nonce: %v", err)
+			}
+			nonce = &allocated
+		}
+
+		amount, ok := new(big.Int).SetString(intent.Amount, 10)
+		if !ok {
+			return fmt.Errorf("invalid amount %q", intent.Amount)
+		}
+		signed, err := signer.SignTransaction(key, intent.ToAddress, amount, &TxOptions{
+			Fee:            parseBaseUnits(intent.Fee),
+			GasPrice:       parseBaseUnits(intent.GasPrice),
+			MaxPriorityFee: parseBaseUnits(intent.MaxPriorityFee),
+			GasLimit:       intent.GasLimit,
+			Nonce:          nonce,
+			ChangeAddress:  intent.FromAddress,
+			TokenContract:  intent.TokenContract,
+			Memo:           intent.Memo,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to sign transaction: %v", err)
+		}
+
+		result := tx.Model(&models.BroadcastIntent{}).
+			Where("id = ? AND tx_hash = ?", intent.ID, "").
+			Updates(map[string]interface{}{
+				"tx_hash": signed.Hash,
+				"raw_tx":  signed.Raw,
+				"nonce":   nonce,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errIntentClaimed
+		}
+
+		// 替换交易在被链接受前不改动原记录
+		switch intent.SourceType {
+		case models.BroadcastSourceWithdrawal:
+			err = tx.Model(&models.CryptoTransaction{}).Where("id = ?", intent.SourceID).
+				Updates(map[string]interface{}{"tx_hash": signed.Hash, "nonce": nonce}).Error
+		case models.BroadcastSourceInternalTransfer:
+			err = tx.Model(&models.InternalTransfer{}).Where("id = ?", intent.SourceID).
+				Updates(map[string]interface{}{"tx_hash": signed.Hash, "nonce": nonce}).Error
+		}
+		if err != nil {
+			return fmt.Errorf("failed to record transaction hash: %v", err)
+		}
+
+		intent.TxHash = signed.Hash
+		intent.RawTx = signed.Raw
+		intent.Nonce = nonce
+		return nil
+	})
+}
+
+// complete 交易已被链接受，回写链上结果
+func (b *Broadcaster) complete(chain Blockchain, intent *models.BroadcastIntent) error {
+	chainTx, err := chain.GetTransaction(intent.TxHash)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction: %v", err)
+	}
+
+	return b.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.BroadcastIntent{}).
+			Where("id = ? AND status = ?", intent.ID, models.BroadcastIntentPending).
+			Updates(map[string]interface{}{
+				"status":     models.BroadcastIntentSent,
+				"attempts":   intent.Attempts + 1,
+				"last_error": "",
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		switch intent.SourceType {
+		case models.BroadcastSourceWithdrawal:
+			return withdrawalSent(tx, intent, chainTx)
+		case models.BroadcastSourceReplacement:
+			return replacementSent(tx, intent, chainTx)
+		case models.BroadcastSourceInternalTransfer:
+			// 未打包的交易节点不返回手续费，先记录预估值，打包后由 TreasuryService.Refresh 更新为实际值
+			fee := parseBaseUnits(intent.Fee)
+			if chainTx.Fee != nil {
+				fee = chainTx.Fee
+			}
+			return tx.Model(&models.InternalTransfer{}).
+				Where("id = ? AND status = ?", intent.SourceID, models.CryptoTxStatusCreated).
+				Updates(map[string]interface{}{
+					"status": models.CryptoTxStatusBroadcast,
+					"fee":    fromBaseUnits(intent.Network, fee),
+				}).Error
+		}
+		return fmt.Errorf("unknown broadcast source %s", intent.SourceType)
+	})
+}
+
+// retry 记录发送失败，未达到最大次数时按退避安排下次重试，否则放弃并释放资金
+func (b *Broadcaster) retry(chain Blockchain, intent *models.BroadcastIntent, cause error) error {
+	attempts := intent.Attempts + 1
+	policy := b.cfg.Broadcast
+	if attempts < policy.MaxAttempts {
+		if err := b.db.Model(intent).Updates(map[string]interface{}{
+			"attempts":        attempts,
+			"last_error":      truncate(cause.Error(), 255),
+			"next_attempt_at": b.cfg.Clock.Now().Add(policy.NextAttempt(attempts)),
+		}).Error; err != nil {
+			return err
+		}
+		return cause
+	}
+
+	// 放弃前再确认链上确实没有这笔交易，避免已发出的资金被重复退回
+	if intent.TxHash != "" {
+		if _, err := chain.GetTransaction(intent.TxHash); err == nil {
+			return b.complete(chain, intent)
+		}
+	}
+
+	reason := truncate(fmt.Sprintf("broadcast failed after %d attempts: %v", attempts, cause), 255)
+	err := b.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.BroadcastIntent{}).
+			Where("id = ? AND status = ?", intent.ID, models.BroadcastIntentPending).
+			Updates(map[string]interface{}{
+				"status":     models.BroadcastIntentFailed,
+				"attempts":   attempts,
+				"last_error": reason,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		// 替换交易沿用原交易的 nonce，不归还
+		if intent.Nonce != nil && intent.SourceType != models.BroadcastSourceReplacement {
+			if err := b.nonceManager.Release(tx, intent.Network, intent.FromAddress, *intent.Nonce); err != nil {
+				return err
+			}
+		}
+
+		switch intent.SourceType {
+		case models.BroadcastSourceWithdrawal:
+			var record models.CryptoTransaction
+			if err := tx.First(&record, intent.SourceID).Error; err != nil {
+				return fmt.Errorf("transaction not found: %v", err)
+			}
+			_, err := refundWithdrawal(tx, &record, true, reason, []string{models.CryptoTxStatusCreated})
+			return err
+		case models.BroadcastSourceReplacement:
+			return replacementFailed(tx, intent)
+		case models.BroadcastSourceInternalTransfer:
+			return tx.Model(&models.InternalTransfer{}).
+				Where("id = ? AND status = ?", intent.SourceID, models.CryptoTxStatusCreated).
+				Updates(map[string]interface{}{
+					"status":         models.CryptoTxStatusFailed,
+					"failure_reason": reason,
+				}).Error
+		}
+		return fmt.Errorf("unknown broadcast source %s", intent.SourceType)
+	})
+	if err != nil {
+		return err
+	}
+	return cause
+}
+
+// withdrawalSent 提现进入 broadcast 状态。未上链的交易没有回执，实际手续费未知，
+// 冻结的预估手续费保持不变，上链后由 WithdrawalTracker 多退少补
+func withdrawalSent(tx *gorm.DB, intent *models.BroadcastIntent, chainTx *BlockchainTransaction) error {
+	var record models.CryptoTransaction
+	if err := tx.First(&record, intent.SourceID).Error; err != nil {
+		return fmt.Errorf("transaction not found: %v", err)
+	}
+	if record.Status != models.CryptoTxStatusCreated {
+		return nil
+	}
+
+	// 后续由 WithdrawalTracker 跟踪确认
+	return tx.Model(&record).Updates(map[string]interface{}{
+		"status":    models.CryptoTxStatusBroadcast,
+		"tx_hash":   chainTx.Hash,
+		"gas_price": formatBaseUnits(chainTx.GasPrice),
+		"nonce":     intent.Nonce,
+	}).Error
+}
+
+// replacementSent 替换交易被接受后，提现记录改为跟踪新交易，额外冻结的手续费并入冻结的手续费，
+// 上链后由 WithdrawalTracker 按实际手续费多退少补
+func replacementSent(tx *gorm.DB, intent *models.BroadcastIntent, chainTx *BlockchainTransaction) error {
+	var record models.CryptoTransaction
+	if err := tx.First(&record, intent.SourceID).Error; err != nil {
+		return fmt.Errorf("transaction not found: %v", err)
+	}
+
+	return tx.Model(&record).Updates(map[string]interface{}{
+		"tx_hash":          chainTx.Hash,
+		"replaced_tx_hash": record.TxHash,
+		"fee":              gorm.Expr("fee + ?", fromBaseUnits(record.Network, parseBaseUnits(intent.ExtraFee))),
+		"gas_price":        intent.GasPrice,
+	}).Error
+}
+
+// replacementFailed 退回替换交易额外冻结的手续费，原交易保持不变
+func replacementFailed(tx *gorm.DB, intent *models.BroadcastIntent) error {
+	var record models.CryptoTransaction
+	if err := tx.First(&record, intent.SourceID).Error; err != nil {
+		return fmt.Errorf("transaction not found: %v", err)
+	}
+
+	extraFee := fromBaseUnits(record.Network, parseBaseUnits(intent.ExtraFee))
+	if extraFee <= 0 {
+		return nil
+	}
+	if err := adjustBalance(tx, record.WalletID, record.Network, "", extraFee); err != nil {
+		return fmt.Errorf("failed to update balance: %v", err)
+	}
+	return nil
+}
+
+// parseBaseUnits 解析以十进制字符串保存的链上金额，空字符串返回 nil
+func parseBaseUnits(value string) *big.Int {
+	parsed, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		return nil
+	}
+	return parsed
+}
+
+// formatBaseUnits 将链上金额保存为十进制字符串，nil 保存为空字符串
+func formatBaseUnits(value *big.Int) string {
+	if value == nil {
+		return ""
+	}
+	return value.String()
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max]
+}
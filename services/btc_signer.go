@@ -0,0 +1,653 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"strings"
+)
+
+const (
+	btcTxVersion = 2
+
+	// btcSequence signals opt-in replace-by-fee (BIP 125)
+	btcSequence = 0xfffffffd
+
+	sighashAll = 0x01
+
+	// maxNullDataSize is the largest OP_RETURN payload relayed by default
+	maxNullDataSize = 80
+)
+
+// Script opcodes used by the standard output scripts
+const (
+	opReturn      = 0x6a
+	opDup         = 0x76
+	opEqual       = 0x87
+	opEqualVerify = 0x88
+	opHash160     = 0xa9
+	opCheckSig    = 0xac
+	opPushData1   = 0x4c
+	opVersion1    = 0x51
+)
+
+// btcTx is a Bitcoin transaction in its segwit serialization (BIP 144).
+// Previous transaction hashes are kept in internal byte order, the reverse
+// of their hex form.
+type btcTx struct {
+	version  uint32
+	inputs   []btcTxIn
+	outputs  []btcTxOut
+	lockTime uint32
+}
+
+type btcTxIn struct {
+	prevHash  []byte
+	prevIndex uint32
+	sequence  uint32
+	witness   [][]byte
+}
+
+type btcTxOut struct {
+	value  uint64
+	script []byte
+}
+
+// serialize encodes the transaction, with its witnesses when witness is set
+// and any input carries one
+func (tx *btcTx) serialize(witness bool) []byte {
+	hasWitness := false
+	for _, input := range tx.inputs {
+		hasWitness = hasWitness || len(input.witness) > 0
+	}
+	witness = witness && hasWitness
+
+	var buf bytes.Buffer
+	writeUint32(&buf, tx.version)
+	if witness {
+		buf.Write([]byte{0x00, 0x01}) // Marker and flag
+	}
+	writeVarInt(&buf, uint64(len(tx.inputs)))
+	for _, input := range tx.inputs {
+		buf.Write(input.prevHash)
+		writeUint32(&buf, input.prevIndex)
+		writeVarInt(&buf, 0) // Empty scriptSig, every input is a witness input
+		writeUint32(&buf, input.sequence)
+	}
+	writeVarInt(&buf, uint64(len(tx.outputs)))
+	for _, output := range tx.outputs {
+		writeUint64(&buf, output.value)
+		writeVarBytes(&buf, output.script)
+	}
+	if witness {
+		for _, input := range tx.inputs {
+			writeVarInt(&buf, uint64(len(input.witness)))
+			for _, item := range input.witness {
+				writeVarBytes(&buf, item)
+			}
+		}
+	}
+	writeUint32(&buf, tx.lockTime)
+	return buf.Bytes()
+}
+
+// txid is the hash of the serialization without witnesses, in hex byte order
+func (tx *btcTx) txid() string {
+	return hex.EncodeToString(reverseBytes(doubleSHA256(tx.serialize(false))))
+}
+
+// vsize is the weight of the transaction divided by four, rounded up
+func (tx *btcTx) vsize() uint64 {
+	base, total := len(tx.serialize(false)), len(tx.serialize(true))
+	return uint64(base*3+total+3) / 4
+}
+
+// sigHash computes the BIP 143 signature hash of an input spending value
+// with scriptCode, for SIGHASH_ALL
+func (tx *btcTx) sigHash(index int, scriptCode []byte, value uint64) []byte {
+	var prevouts, sequences, outputs bytes.Buffer
+	for _, input := range tx.inputs {
+		prevouts.Write(input.prevHash)
+		writeUint32(&prevouts, input.prevIndex)
+		writeUint32(&sequences, input.sequence)
+	}
+	for _, output := range tx.outputs {
+		writeUint64(&outputs, output.value)
+		writeVarBytes(&outputs, output.script)
+	}
+
+	input := tx.inputs[index]
+	var preimage bytes.Buffer
+	writeUint32(&preimage, tx.version)
+	preimage.Write(doubleSHA256(prevouts.Bytes()))
+	preimage.Write(doubleSHA256(sequences.Bytes()))
+	preimage.Write(input.prevHash)
+	writeUint32(&preimage, input.prevIndex)
+	writeVarBytes(&preimage, scriptCode)
+	writeUint64(&preimage, value)
+	writeUint32(&preimage, input.sequence)
+	preimage.Write(doubleSHA256(outputs.Bytes()))
+	writeUint32(&preimage, tx.lockTime)
+	writeUint32(&preimage, sighashAll)
+	return doubleSHA256(preimage.Bytes())
+}
+
+// parseBitcoinTx decodes a transaction in segwit or legacy serialization.
+// Inputs with a scriptSig are rejected, only witness inputs can be verified.
+func parseBitcoinTx(raw []byte) (*btcTx, error) {
+	r := &byteReader{data: raw}
+	tx := &btcTx{version: r.uint32()}
+
+	inputCount := r.varInt()
+	witness := false
+	if inputCount == 0 && r.remaining() > 0 && r.data[r.offset] == 0x01 {
+		r.offset++
+		witness = true
+		inputCount = r.varInt()
+	}
+	if inputCount == 0 || inputCount > uint64(r.remaining()) {
+		return nil, fmt.Errorf("invalid input count")
+	}
+	for i := uint64(0); i < inputCount; i++ {
+		input := btcTxIn{prevHash: r.bytes(32), prevIndex: r.uint32()}
+		if len(r.varBytes()) > 0 {
+			return nil, fmt.Errorf("input %d: only witness inputs are supported", i)
+		}
+		input.sequence = r.uint32()
+		tx.inputs = append(tx.inputs, input)
+	}
+
+	outputCount := r.varInt()
+	if outputCount == 0 || outputCount > uint64(r.remaining()) {
+		return nil, fmt.Errorf("invalid output count")
+	}
+	for i := uint64(0); i < outputCount; i++ {
+		tx.outputs = append(tx.outputs, btcTxOut{value: r.uint64(), script: r.varBytes()})
+	}
+
+	if witness {
+		for i := range tx.inputs {
+			count := r.varInt()
+			if count > uint64(r.remaining()) {
+				return nil, fmt.Errorf("invalid witness")
+			}
+			for j := uint64(0); j < count; j++ {
+				tx.inputs[i].witness = append(tx.inputs[i].witness, r.varBytes())
+			}
+		}
+	}
+	tx.lockTime = r.uint32()
+
+	if r.err != nil {
+		return nil, r.err
+	}
+	if r.remaining() != 0 {
+		return nil, fmt.Errorf("trailing data after transaction")
+	}
+	return tx, nil
+}
+
+// psbt is an in-memory partially signed transaction following the BIP 174
+// roles: the creator builds the unsigned transaction, the updater attaches
+// the outputs it spends, signers add partial signatures and the finalizer
+// turns them into witnesses before the transaction is extracted
+type psbt struct {
+	tx     *btcTx
+	inputs []psbtInput
+}
+
+type psbtInput struct {
+	witnessUTXO btcTxOut
+	partialSigs map[string][]byte // Signatures keyed by hex compressed public key
+}
+
+func newPSBT(tx *btcTx) *psbt {
+	return &psbt{tx: tx, inputs: make([]psbtInput, len(tx.inputs))}
+}
+
+// update records the output spent by input index
+func (p *psbt) update(index int, utxo btcTxOut) {
+	p.inputs[index].witnessUTXO = utxo
+}
+
+// sign adds the key's signature to every P2WPKH input it can spend and
+// returns the number of inputs signed
+func (p *psbt) sign(key *PrivateKey) int {
+	public := key.public.compressed()
+	script := p2wpkhScript(hash160(public))
+
+	signed := 0
+	for i := range p.inputs {
+		input := &p.inputs[i]
+		if !bytes.Equal(input.witnessUTXO.script, script) {
+			continue
+		}
+		hash := p.tx.sigHash(i, p2wpkhScriptCode(script[2:]), input.witnessUTXO.value)
+		if input.partialSigs == nil {
+			input.partialSigs = make(map[string][]byte)
+		}
+		input.partialSigs[hex.EncodeToString(public)] = append(key.sign(hash).encodeDER(), sighashAll)
+		signed++
+	}
+	return signed
+}
+
+// finalize builds the witness of every input from its partial signature
+func (p *psbt) finalize() error {
+	for i, input := range p.inputs {
+		program := input.witnessUTXO.script
+		if !isP2WPKH(program) {
+			return fmt.Errorf("input %d: only P2WPKH inputs can be finalized", i)
+		}
+		var witness [][]byte
+		for publicHex, sig := range input.partialSigs {
+			public, _ := hex.DecodeString(publicHex)
+			if bytes.Equal(hash160(public), program[2:]) {
+				witness = [][]byte{sig, public}
+			}
+		}
+		if witness == nil {
+			return fmt.Errorf("input %d is not signed", i)
+		}
+		p.tx.inputs[i].witness = witness
+	}
+	return nil
+}
+
+// extract returns the signed transaction of a finalized PSBT
+func (p *psbt) extract() *btcTx {
+	return p.tx
+}
+
+// bitcoinSigner spends the sender's P2WPKH outputs, largest first, paying
+// the recipient, an optional OP_RETURN memo and the change. Addresses
+// belong to the bitcoin network of params.
+type bitcoinSigner struct {
+	chain  Blockchain
+	params *BitcoinParams
+}
+
+func newBitcoinSigner(chain Blockchain, params *BitcoinParams) *bitcoinSigner {
+	return &bitcoinSigner{chain: chain, params: params}
+}
+
+// SignTransaction selects coins at opts.GasPrice sat/vB (the current fee
+// rate by default) and signs every input with the key. Change goes to
+// opts.ChangeAddress, or back to the sender.
+func (s *bitcoinSigner) SignTransaction(key *PrivateKey, to string, amount *big.Int, opts *TxOptions) (*SignedTransaction, error) {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	if opts.TokenContract != "" {
+		return nil, fmt.Errorf("tokens are not supported on UTXO chains")
+	}
+	if len(opts.Memo) > maxNullDataSize {
+		return nil, fmt.Errorf("memo exceeds %d bytes", maxNullDataSize)
+	}
+	utxos, ok := s.chain.(UTXOChain)
+	if !ok {
+		return nil, fmt.Errorf("chain does not list unspent outputs")
+	}
+	from := key.Address(models.NetworkBTC, s.params)
+
+	feeRate := opts.GasPrice
+	if feeRate == nil {
+		feeRate = s.chain.BaseFee()
+	}
+	candidates, err := utxos.ListUnspent(from)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unspent outputs: %v", err)
+	}
+	// Coin selection sizes a plain transfer, the memo output is paid for as
+	// part of the amount
+	var memoScript []byte
+	target := amount
+	if opts.Memo != "" {
+		memoScript = nullDataScript([]byte(opts.Memo))
+		memoSize := big.NewInt(int64(8 + 1 + len(memoScript)))
+		target = new(big.Int).Add(amount, memoSize.Mul(memoSize, feeRate))
+	}
+	selection, err := selectCoins(candidates, target, feeRate)
+	if err != nil {
+		return nil, err
+	}
+
+	recipientScript, err := s.params.addressScript(to)
+	if err != nil {
+		return nil, err
+	}
+	changeAddress := from
+	if opts.ChangeAddress != "" {
+		changeAddress = opts.ChangeAddress
+	}
+	changeScript, err := s.params.addressScript(changeAddress)
+	if err != nil {
+		return nil, err
+	}
+	if !amount.IsUint64() {
+		return nil, fmt.Errorf("invalid amount")
+	}
+
+	tx := &btcTx{version: btcTxVersion}
+	tx.outputs = append(tx.outputs, btcTxOut{value: amount.Uint64(), script: recipientScript})
+	if memoScript != nil {
+		tx.outputs = append(tx.outputs, btcTxOut{script: memoScript})
+	}
+	if selection.change.Sign() > 0 {
+		tx.outputs = append(tx.outputs, btcTxOut{value: selection.change.Uint64(), script: changeScript})
+	}
+
+	spent := make([]btcTxOut, 0, len(selection.inputs))
+	for _, utxo := range selection.inputs {
+		prevHash, err := hex.DecodeString(strings.TrimPrefix(utxo.TxHash, "0x"))
+		if err != nil || len(prevHash) != 32 {
+			return nil, fmt.Errorf("invalid outpoint hash %q", utxo.TxHash)
+		}
+		script, err := s.params.addressScript(utxo.Address)
+		if err != nil {
+			return nil, err
+		}
+		tx.inputs = append(tx.inputs, btcTxIn{prevHash: reverseBytes(prevHash), prevIndex: utxo.Index, sequence: btcSequence})
+		spent = append(spent, btcTxOut{value: utxo.Amount.Uint64(), script: script})
+	}
+
+	packet := newPSBT(tx)
+	for i, utxo := range spent {
+		packet.update(i, utxo)
+	}
+	packet.sign(key)
+	if err := packet.finalize(); err != nil {
+		return nil, err
+	}
+	signed := packet.extract()
+
+	return &SignedTransaction{Hash: signed.txid(), Raw: hex.EncodeToString(signed.serialize(true))}, nil
+}
+
+// bitcoinDecoder returns a decoder for transactions between addresses of
+// the bitcoin network of params
+func bitcoinDecoder(params *BitcoinParams) txDecoder {
+	return func(raw []byte, prevout func(txHash string, index uint32) *UTXO) (*decodedTransaction, error) {
+		return decodeBitcoinTransaction(params, raw, prevout)
+	}
+}
+
+// decodeBitcoinTransaction parses a signed transaction and verifies the
+// witness of every input against the output it spends. From is the owner of
+// the first input, To the first output paying another address.
+func decodeBitcoinTransaction(params *BitcoinParams, raw []byte, prevout func(txHash string, index uint32) *UTXO) (*decodedTransaction, error) {
+	tx, err := parseBitcoinTx(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	decoded := &decodedTransaction{Hash: tx.txid(), Amount: big.NewInt(0), Options: &TxOptions{}, VSize: tx.vsize()}
+
+	for i, input := range tx.inputs {
+		utxo := prevout(hex.EncodeToString(reverseBytes(input.prevHash)), input.prevIndex)
+		if utxo == nil {
+			return nil, fmt.Errorf("input %d spends a missing or spent output", i)
+		}
+		script, err := params.addressScript(utxo.Address)
+		if err != nil {
+			return nil, err
+		}
+		if err := verifyWitness(tx, i, script, utxo.Amount.Uint64()); err != nil {
+			return nil, fmt.Errorf("input %d: %v", i, err)
+		}
+		decoded.Inputs = append(decoded.Inputs, TxInput{
+			PrevTxHash: utxo.TxHash,
+			PrevIndex:  utxo.Index,
+			Address:    utxo.Address,
+			Amount:     utxo.Amount,
+		})
+	}
+	decoded.From = decoded.Inputs[0].Address
+
+	for i, output := range tx.outputs {
+		if memo, ok := nullDataPayload(output.script); ok {
+			decoded.Options.Memo = string(memo)
+			continue
+		}
+		address := params.scriptAddress(output.script)
+		if address == "" {
+			return nil, fmt.Errorf("output %d has a non-standard script", i)
+		}
+		decoded.Outputs = append(decoded.Outputs, TxOutput{
+			Index:   uint32(i),
+			Address: address,
+			Amount:  new(big.Int).SetUint64(output.value),
+		})
+		if decoded.To == "" && address != decoded.From {
+			decoded.To = address
+		}
+	}
+	if decoded.To == "" {
+		decoded.To = decoded.From
+	}
+	for _, output := range decoded.Outputs {
+		if output.Address == decoded.To {
+			decoded.Amount.Add(decoded.Amount, output.Amount)
+		}
+	}
+	return decoded, nil
+}
+
+// verifyWitness checks the P2WPKH witness of input index: the public key
+// must hash to the spent program and sign the BIP 143 hash
+func verifyWitness(tx *btcTx, index int, script []byte, value uint64) error {
+	if !isP2WPKH(script) {
+		return fmt.Errorf("only P2WPKH outputs can be spent")
+	}
+	witness := tx.inputs[index].witness
+	if len(witness) != 2 || len(witness[0]) < 2 {
+		return errInvalidSignature
+	}
+	sig, publicBytes := witness[0], witness[1]
+	if !bytes.Equal(hash160(publicBytes), script[2:]) {
+		return fmt.Errorf("public key does not match the spent output")
+	}
+	if sig[len(sig)-1] != sighashAll {
+		return fmt.Errorf("unsupported sighash type 0x%02x", sig[len(sig)-1])
+	}
+	public, err := parseCompressedPoint(publicBytes)
+	if err != nil {
+		return err
+	}
+	r, s, err := parseDER(sig[:len(sig)-1])
+	if err != nil {
+		return err
+	}
+	if s.Cmp(secp256k1HalfN) > 0 {
+		return fmt.Errorf("invalid signature: high s value")
+	}
+	if !verifySignature(public, tx.sigHash(index, p2wpkhScriptCode(script[2:]), value), r, s) {
+		return errInvalidSignature
+	}
+	return nil
+}
+
+// addressScript returns the output script paying an address of the network
+func (p *BitcoinParams) addressScript(address string) ([]byte, error) {
+	if strings.HasPrefix(strings.ToLower(address), p.HRP+"1") {
+		if err := validateSegwitAddress(address); err != nil {
+			return nil, err
+		}
+		_, data, _, _, _ := bech32Decode(address)
+		program, _ := convertBits(data[1:], 5, 8, false)
+		version := data[0]
+		if version > 0 {
+			version += opVersion1 - 1
+		}
+		return append([]byte{version, byte(len(program))}, program...), nil
+	}
+
+	if err := validateBTCAddress(p, address); err != nil {
+		return nil, err
+	}
+	payload, _, _ := base58CheckDecode(address)
+	if payload[0] == p.P2SHVersion {
+		return append(append([]byte{opHash160, 20}, payload[1:]...), opEqual), nil
+	}
+	script := append([]byte{opDup, opHash160, 20}, payload[1:]...)
+	return append(script, opEqualVerify, opCheckSig), nil
+}
+
+// scriptAddress returns the address of a standard output script on the
+// network, or "" when the script has no address
+func (p *BitcoinParams) scriptAddress(script []byte) string {
+	switch {
+	case len(script) == 25 && script[0] == opDup && script[1] == opHash160 && script[2] == 20 &&
+		script[23] == opEqualVerify && script[24] == opCheckSig:
+		return base58CheckEncode(append([]byte{p.P2PKHVersion}, script[3:23]...))
+	case len(script) == 23 && script[0] == opHash160 && script[1] == 20 && script[22] == opEqual:
+		return base58CheckEncode(append([]byte{p.P2SHVersion}, script[2:22]...))
+	case len(script) >= 4 && len(script) <= 42 && int(script[1]) == len(script)-2 &&
+		(script[0] == 0 || (script[0] >= opVersion1 && script[0] <= opVersion1+15)):
+		version := script[0]
+		if version > 0 {
+			version -= opVersion1 - 1
+		}
+		if version == 0 && len(script) != 22 && len(script) != 34 {
+			return ""
+		}
+		return encodeSegwitAddress(p.HRP, version, script[2:])
+	}
+	return ""
+}
+
+func p2wpkhScript(pubKeyHash []byte) []byte {
+	return append([]byte{0x00, 20}, pubKeyHash...)
+}
+
+func isP2WPKH(script []byte) bool {
+	return len(script) == 22 && script[0] == 0x00 && script[1] == 20
+}
+
+// p2wpkhScriptCode is the P2PKH script signed for a P2WPKH input (BIP 143)
+func p2wpkhScriptCode(pubKeyHash []byte) []byte {
+	script := append([]byte{opDup, opHash160, 20}, pubKeyHash...)
+	return append(script, opEqualVerify, opCheckSig)
+}
+
+func nullDataScript(data []byte) []byte {
+	if len(data) < opPushData1 {
+		return append([]byte{opReturn, byte(len(data))}, data...)
+	}
+	return append([]byte{opReturn, opPushData1, byte(len(data))}, data...)
+}
+
+// nullDataPayload returns the data pushed by an OP_RETURN script
+func nullDataPayload(script []byte) ([]byte, bool) {
+	if len(script) == 0 || script[0] != opReturn {
+		return nil, false
+	}
+	switch {
+	case len(script) == 1:
+		return nil, true
+	case script[1] < opPushData1 && int(script[1]) == len(script)-2:
+		return script[2:], true
+	case script[1] == opPushData1 && len(script) > 2 && int(script[2]) == len(script)-3:
+		return script[3:], true
+	}
+	return nil, true
+}
+
+func reverseBytes(data []byte) []byte {
+	reversed := make([]byte, len(data))
+	for i, b := range data {
+		reversed[len(data)-1-i] = b
+	}
+	return reversed
+}
+
+func writeUint32(buf *bytes.Buffer, value uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], value)
+	buf.Write(b[:])
+}
+
+func writeUint64(buf *bytes.Buffer, value uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], value)
+	buf.Write(b[:])
+}
+
+func writeVarInt(buf *bytes.Buffer, value uint64) {
+	switch {
+	case value < 0xfd:
+		buf.WriteByte(byte(value))
+	case value <= 0xffff:
+		buf.WriteByte(0xfd)
+		buf.Write([]byte{byte(value), byte(value >> 8)})
+	case value <= 0xffffffff:
+		buf.WriteByte(0xfe)
+		writeUint32(buf, uint32(value))
+	default:
+		buf.WriteByte(0xff)
+		writeUint64(buf, value)
+	}
+}
+
+func writeVarBytes(buf *bytes.Buffer, data []byte) {
+	writeVarInt(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+// byteReader reads little-endian transaction fields, remembering the first
+// error so a parse can check it once at the end
+type byteReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *byteReader) remaining() int {
+	return len(r.data) - r.offset
+}
+
+func (r *byteReader) bytes(n int) []byte {
+	if r.err != nil || n > r.remaining() {
+		if r.err == nil {
+			r.err = fmt.Errorf("unexpected end of transaction")
+		}
+		return make([]byte, n)
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *byteReader) uint32() uint32 {
+	return binary.LittleEndian.Uint32(r.bytes(4))
+}
+
+func (r *byteReader) uint64() uint64 {
+	return binary.LittleEndian.Uint64(r.bytes(8))
+}
+
+func (r *byteReader) varInt() uint64 {
+	prefix := r.bytes(1)[0]
+	switch prefix {
+	case 0xfd:
+		return uint64(binary.LittleEndian.Uint16(r.bytes(2)))
+	case 0xfe:
+		return uint64(r.uint32())
+	case 0xff:
+		return r.uint64()
+	}
+	return uint64(prefix)
+}
+
+func (r *byteReader) varBytes() []byte {
+	n := r.varInt()
+	if n > uint64(r.remaining()) {
+		if r.err == nil {
+			r.err = fmt.Errorf("unexpected end of transaction")
+		}
+		return nil
+	}
+	return r.bytes(int(n))
+}
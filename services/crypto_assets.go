@@ -0,0 +1,46 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+)
+
+// isNativeAsset 资产为空（早期记录）或为网络原生币时返回 true
+func isNativeAsset(network models.Network, asset string) bool {
+	return asset == "" || asset == network.NativeSymbol()
+}
+
+// assetBalance 返回钱包某项资产的余额，原生币取 crypto_wallets.balance
+func assetBalance(tx *gorm.DB, wallet *models.CryptoWallet, asset string) (float64, error) {
+	if isNativeAsset(wallet.Network, asset) {
+		return wallet.Balance, nil
+	}
+
+	var balance models.CryptoWalletBalance
+	err := tx.Where("wallet_id = ? AND asset = ?", wallet.ID, asset).First(&balance).Error
+	if err == gorm.ErrRecordNotFound {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return balance.Balance, nil
+}
+
+// adjustBalance 按 delta 调整钱包某项资产的余额，需在事务内调用
+func adjustBalance(tx *gorm.DB, walletID uint, network models.Network, asset string, delta float64) error {
+	if isNativeAsset(network, asset) {
+		return tx.Model(&models.CryptoWallet{}).
+			Where("id = ?", walletID).
+			UpdateColumn("balance", gorm.Expr("balance + ?", delta)).Error
+	}
+
+	balance := models.CryptoWalletBalance{WalletID: walletID, Asset: asset}
+	if err := tx.Where("wallet_id = ? AND asset = ?", walletID, asset).
+		FirstOrCreate(&balance).Error; err != nil {
+		return fmt.Errorf("failed to load %s balance: %v", asset, err)
+	}
+	return tx.Model(&balance).
+		UpdateColumn("balance", gorm.Expr("balance + ?", delta)).Error
+}
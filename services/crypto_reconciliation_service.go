@@ -2,7 +2,9 @@ package services
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
 	"github.com/panaceacode/wallet-demo/models"
 	"gorm.io/gorm"
 	"math"
@@ -12,62 +14,154 @@ import (
 )
 
 type CryptoReconciliationService struct {
-	db         *gorm.DB
-	blockchain *MockBlockchain
+	db     *gorm.DB
+	chains Chains
+	assets *AssetRegistry
+	clock  config.Clock
 }
 
-func NewCryptoReconciliationService(db *gorm.DB, blockchain *MockBlockchain) *CryptoReconciliationService {
+func NewCryptoReconciliationService(db *gorm.DB, chains Chains, clock config.Clock) *CryptoReconciliationService {
 	return &CryptoReconciliationService{
-		db:         db,
-		blockchain: blockchain,
+		db:     db,
+		chains: chains,
+		assets: NewAssetRegistry(db),
+		clock:  clock,
 	}
 }
 
-// PerformReconciliation 执行链上数据对账
-func (s *CryptoReconciliationService) PerformReconciliation(walletID uint, startTime, endTime time.Time) (*models.CryptoReconciliation, error) {
+// PerformReconciliation 按时间范围 [startTime, endTime] 对账，asset 为空时对账网络原生币
+func (s *CryptoReconciliationService) PerformReconciliation(walletID uint, asset string, startTime, endTime time.Time) (*models.CryptoReconciliation, error) {
+	if startTime.After(endTime) {
+		return nil, fmt.Errorf("start time is after end time")
+	}
+	return s.reconcile(walletID, asset, &reconciliationWindow{startTime: startTime, endTime: endTime})
+}
+
+// PerformBlockReconciliation 按区块范围 [startBlock, endBlock] 对账，asset 为空时对账网络原生币。
+// 系统交易按所在区块筛选，与同一范围内上链的链上交易匹配
+func (s *CryptoReconciliationService) PerformBlockReconciliation(walletID uint, asset string, startBlock, endBlock uint64) (*models.CryptoReconciliation, error) {
+	if startBlock > endBlock {
+		return nil, fmt.Errorf("start block %d is after end block %d", startBlock, endBlock)
+	}
+	return s.reconcile(walletID, asset, &reconciliationWindow{byBlock: true, startBlock: startBlock, endBlock: endBlock})
+}
+
+// reconcile 执行链上数据对账。期初、期末链上余额取自链上的历史余额，
+// 系统余额和内部差额扣除期末之后才上链（或尚未上链）的交易的影响，与期末链上余额比较。
+// 链上历史只查询对账期间，节点限制单次查询的区块跨度，期间过长时返回错误
+func (s *CryptoReconciliationService) reconcile(walletID uint, asset string, window *reconciliationWindow) (*models.CryptoReconciliation, error) {
 	var wallet models.CryptoWallet
 	if err := s.db.First(&wallet, walletID).Error; err != nil {
 		return nil, fmt.Errorf("wallet not found: %v", err)
 	}
 
-	// 获取系统中记录的交易
-	var systemTransactions []models.CryptoTransaction
-	err := s.db.Where("wallet_id = ? AND created_at BETWEEN ? AND ?",
-		walletID, startTime, endTime).
-		Find(&systemTransactions).Error
+	chain, err := s.chains.Get(wallet.Network)
+	if err != nil {
+		return nil, err
+	}
+	if err := window.resolve(chain); err != nil {
+		return nil, err
+	}
+
+	registered, err := s.assets.Get(wallet.Network, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取系统中记录的该资产的交易，早期原生币记录的资产为空
+	assets := []string{registered.Symbol}
+	if registered.IsNative() {
+		assets = append(assets, "")
+	}
+	systemTransactions, err := window.systemTransactions(s.db, walletID, assets)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get system transactions: %v", err)
 	}
 
-	// 获取链上交易记录
-	chainTransactions, err := s.blockchain.GetTransactionHistory(wallet.Address, startTime, endTime)
+	// 钱包的所有地址，包括已轮换的旧地址
+	addresses, err := walletAddresses(s.db, &wallet)
+	if err != nil {
+		return nil, err
+	}
+
+	// 获取期间内的链上交易记录
+	startTime, endTime := window.historyRange()
+	history, err := addressHistory(chain, addresses, startTime, endTime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get blockchain transactions: %v", err)
 	}
+	var chainTransactions []*BlockchainTransaction
+	for _, tx := range history {
+		if window.includes(tx) {
+			chainTransactions = append(chainTransactions, tx)
+		}
+	}
 
-	// 获取链上余额
-	chainBalance, err := s.blockchain.GetAddressBalance(wallet.Address)
+	// 期初、期末链上余额，共享充值地址只统计归属于该钱包的转入
+	var shared []*BlockchainTransaction
+	if wallet.Memo != "" {
+		if shared, err = s.sharedTransactions(chain, chainTransactions, &wallet); err != nil {
+			return nil, fmt.Errorf("failed to get blockchain transactions: %v", err)
+		}
+	}
+	opening, err := s.chainBalance(chain, registered, &wallet, addresses, shared, window, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get blockchain balance: %v", err)
+	}
+	closing, err := s.chainBalance(chain, registered, &wallet, addresses, shared, window, true)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get blockchain balance: %v", err)
 	}
-	chainBalanceFloat := new(big.Float).SetInt(chainBalance)
-	finalChainBalance, _ := chainBalanceFloat.Float64()
+	finalChainBalance := fromUnits(closing, registered.Decimals)
+
+	systemBalance, err := assetBalance(s.db, &wallet, registered.Symbol)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system balance: %v", err)
+	}
+
+	// 归集、补 gas 和热钱包代发提现使链上余额与系统余额之间存在已知的内部差额
+	adjustment, err := internalAdjustment(s.db, &wallet, assets, registered.IsNative())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get internal transfers: %v", err)
+	}
+
+	// 扣除期末之后的资金流动，得到期末的系统余额和内部差额
+	late := window.lateChecker(chain, chainTransactions)
+	systemLate, err := s.lateSystemChange(&wallet, registered, addresses, late)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get system transactions: %v", err)
+	}
+	adjustmentLate := 0.0
+	if wallet.Memo == "" {
+		if adjustmentLate, err = s.lateInternalAdjustment(registered, assets, addresses, late); err != nil {
+			return nil, fmt.Errorf("failed to get internal transfers: %v", err)
+		}
+	}
+	systemBalance -= systemLate
+	adjustment -= adjustmentLate
 
 	// 创建对账记录
 	reconciliation := &models.CryptoReconciliation{
-		WalletID:      walletID,
-		StartTime:     startTime,
-		EndTime:       endTime,
-		SystemBalance: wallet.Balance,
-		ChainBalance:  finalChainBalance,
-		Status:        models.ReconciliationStatusMatched,
-		Difference:    wallet.Balance - finalChainBalance,
+		WalletID:            walletID,
+		Asset:               registered.Symbol,
+		StartTime:           window.startTime,
+		EndTime:             window.endTime,
+		SystemBalance:       systemBalance,
+		OpeningChainBalance: fromUnits(opening, registered.Decimals),
+		ChainBalance:        finalChainBalance,
+		InternalAdjustment:  adjustment,
+		Status:              models.ReconciliationStatusMatched,
+		Difference:          systemBalance + adjustment - finalChainBalance,
+	}
+	if window.byBlock {
+		reconciliation.StartBlock = &window.startBlock
+		reconciliation.EndBlock = &window.endBlock
 	}
 
 	// 分析差异
 	if math.Abs(reconciliation.Difference) > 0.0001 {
 		reconciliation.Status = models.ReconciliationStatusMismatch
-		s.analyzeMismatch(reconciliation, systemTransactions, chainTransactions)
+		s.analyzeMismatch(reconciliation, registered, addresses, systemTransactions, chainTransactions)
 	}
 
 	// 保存对账记录
@@ -78,8 +172,148 @@ func (s *CryptoReconciliationService) PerformReconciliation(walletID uint, start
 	return reconciliation, nil
 }
 
+// addressHistory 合并多个地址在时间范围内的链上交易，同一交易只返回一次
+func addressHistory(chain Blockchain, addresses []string, startTime, endTime time.Time) ([]*BlockchainTransaction, error) {
+	var (
+		history []*BlockchainTransaction
+		seen    = make(map[string]bool)
+	)
+	for _, address := range addresses {
+		txs, err := chain.GetTransactionHistory(address, startTime, endTime)
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range txs {
+			if seen[tx.Hash] {
+				continue
+			}
+			seen[tx.Hash] = true
+			history = append(history, tx)
+		}
+	}
+	return history, nil
+}
+
+// chainBalance 返回钱包地址在期初（closing 为 false）或期末持有的链上资产余额。
+// 共享充值地址只统计 shared 中当时已上链、归属于该钱包的转入
+func (s *CryptoReconciliationService) chainBalance(chain Blockchain, asset *models.Asset, wallet *models.CryptoWallet, addresses []string, shared []*BlockchainTransaction, window *reconciliationWindow, closing bool) (*big.Int, error) {
+	if wallet.Memo != "" {
+		return sharedAmount(shared, asset, wallet, func(tx *BlockchainTransaction) bool {
+			return tx.Status == "success" && window.minedBy(tx, closing)
+		}), nil
+	}
+
+	total := big.NewInt(0)
+	for _, address := range addresses {
+		balance, err := window.balance(chain, asset, address, closing)
+		if err != nil {
+			return nil, err
+		}
+		total.Add(total, balance)
+	}
+	return total, nil
+}
+
+// sharedTransactions 返回共享充值地址上归属于钱包的链上转入：期间内带有钱包 memo 的交易，
+// 以及钱包所有充值记录对应的交易（期间外的逐笔向节点查询）。期间之前未入账的同 memo 转入不计入
+func (s *CryptoReconciliationService) sharedTransactions(chain Blockchain, chainTransactions []*BlockchainTransaction, wallet *models.CryptoWallet) ([]*BlockchainTransaction, error) {
+	var hashes []string
+	if err := s.db.Model(&models.CryptoTransaction{}).
+		Where("wallet_id = ? AND type = ? AND tx_hash <> ? AND status <> ?",
+			wallet.ID, models.TransactionDeposit, "", models.CryptoTxStatusReversed).
+		Distinct().Pluck("tx_hash", &hashes).Error; err != nil {
+		return nil, err
+	}
+
+	var txs []*BlockchainTransaction
+	seen := make(map[string]bool)
+	for _, tx := range chainTransactions {
+		if tx.Memo == wallet.Memo {
+			txs = append(txs, tx)
+			seen[tx.Hash] = true
+		}
+	}
+	inWindow := make(map[string]*BlockchainTransaction, len(chainTransactions))
+	for _, tx := range chainTransactions {
+		inWindow[tx.Hash] = tx
+	}
+	for _, hash := range hashes {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		tx, exists := inWindow[hash]
+		if !exists {
+			var err error
+			tx, err = chain.GetTransaction(hash)
+			if errors.Is(err, ErrTransactionNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to get transaction %s: %v", hash, err)
+			}
+		}
+		txs = append(txs, tx)
+	}
+	return txs, nil
+}
+
+// sharedAmount 累加 txs 中 include 接受的、转入钱包共享地址的金额
+func sharedAmount(txs []*BlockchainTransaction, asset *models.Asset, wallet *models.CryptoWallet, include func(tx *BlockchainTransaction) bool) *big.Int {
+	total := big.NewInt(0)
+	for _, tx := range txs {
+		if !include(tx) {
+			continue
+		}
+		if asset.IsNative() {
+			total.Add(total, receivedAmount(tx, wallet.Address))
+			continue
+		}
+		for _, transfer := range tx.TokenTransfers {
+			if strings.EqualFold(transfer.Contract, asset.ContractAddress) && transfer.To == wallet.Address {
+				total.Add(total, transfer.Amount)
+			}
+		}
+	}
+	return total
+}
+
+// tokenAmount 累加交易中 addresses 转入或转出指定代币的金额
+func tokenAmount(tx *BlockchainTransaction, contract string, addresses []string) *big.Int {
+	total := big.NewInt(0)
+	for _, transfer := range tx.TokenTransfers {
+		if !strings.EqualFold(transfer.Contract, contract) {
+			continue
+		}
+		if containsAddress(addresses, transfer.From) || containsAddress(addresses, transfer.To) {
+			total.Add(total, transfer.Amount)
+		}
+	}
+	return total
+}
+
+// addressBalance 返回地址在链上的余额，UTXO 链按未花费输出累加
+func addressBalance(chain Blockchain, address string) (*big.Int, error) {
+	utxoChain, ok := chain.(UTXOChain)
+	if !ok {
+		return chain.GetAddressBalance(address)
+	}
+
+	utxos, err := utxoChain.ListUnspent(address)
+	if err != nil {
+		return nil, err
+	}
+	total := big.NewInt(0)
+	for _, utxo := range utxos {
+		total.Add(total, utxo.Amount)
+	}
+	return total, nil
+}
+
 func (s *CryptoReconciliationService) analyzeMismatch(
 	reconciliation *models.CryptoReconciliation,
+	asset *models.Asset,
+	addresses []string,
 	systemTxs []models.CryptoTransaction,
 	chainTxs []*BlockchainTransaction) {
 
@@ -94,6 +328,11 @@ func (s *CryptoReconciliationService) analyzeMismatch(
 	var reasons []string
 
 	for _, sysTx := range systemTxs {
+		// 退款等补偿记录没有对应的链上交易，热钱包代发的提现不经过钱包的地址
+		if sysTx.TxHash == "" || (sysTx.Type == models.TransactionWithdraw && !containsAddress(addresses, sysTx.FromAddress)) {
+			continue
+		}
+
 		chainTx, exists := chainTxMap[sysTx.TxHash]
 		if !exists {
 			unmatchedTxs = append(unmatchedTxs, sysTx.TxHash)
@@ -101,15 +340,18 @@ func (s *CryptoReconciliationService) analyzeMismatch(
 			continue
 		}
 
-		// 比较金额
-		sysAmount := new(big.Float).SetFloat64(sysTx.Amount)
-		chainAmount := new(big.Float).SetInt(chainTx.Amount)
+		// 比较金额，统一换算为链上最小单位，代币金额取自 Transfer 事件
+		chainAmount := chainTx.Amount
+		if !asset.IsNative() {
+			chainAmount = tokenAmount(chainTx, asset.ContractAddress, addresses)
+		}
+		sysAmount := toUnits(sysTx.Amount, asset.Decimals)
 		if sysAmount.Cmp(chainAmount) != 0 {
 			reasons = append(reasons, fmt.Sprintf(
 				"Amount mismatch for tx %s: system=%v, chain=%v",
 				sysTx.TxHash,
 				sysTx.Amount,
-				chainTx.Amount,
+				fromUnits(chainAmount, asset.Decimals),
 			))
 		}
 	}
@@ -3,32 +3,145 @@ package services
 
 import (
 	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
 	"github.com/panaceacode/wallet-demo/models"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
 	"math/big"
 )
 
 type CryptoWalletService struct {
-	db         *gorm.DB
-	blockchain *MockBlockchain
+	db           *gorm.DB
+	chains       Chains
+	feeEstimator *FeeEstimator
+	nonceManager *NonceManager
+	keys         *KeyStore
+	assets       *AssetRegistry
+	validator    *AddressValidator
+	addressBook  *AddressBookService
+	treasury     *TreasuryService
+	broadcaster  *Broadcaster
+	cfg          *config.CryptoConfig
 }
 
-func NewCryptoWalletService(db *gorm.DB) *CryptoWalletService {
+// WithdrawOptions 提现的资产和手续费选项，Speed 与 MaxFee 均为空时按 normal 档
+type WithdrawOptions struct {
+	Asset  string // 提现的资产符号，为空时为网络原生币
+	Speed  FeeSpeed
+	MaxFee float64 // 手续费上限，未指定 Speed 时选择上限内最快的一档
+	Memo   string  // 目标地址要求的备注/标签
+}
+
+func NewCryptoWalletService(db *gorm.DB, chains Chains, cfg *config.CryptoConfig) (*CryptoWalletService, error) {
+	btc, err := bitcoinParams(cfg)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := NewKeyStore(db, cfg, btc)
+	if err != nil {
+		return nil, err
+	}
+	validator := NewAddressValidator(btc)
+	feeEstimator := NewFeeEstimator(chains)
+	nonceManager := NewNonceManager(db, chains, cfg.Clock)
+	assets := NewAssetRegistry(db)
+	broadcaster := NewBroadcaster(db, chains, NewSigners(chains, btc, cfg.Clock), keys, nonceManager, cfg)
 	return &CryptoWalletService{
-		db:         db,
-		blockchain: NewMockBlockchain(),
+		db:           db,
+		chains:       chains,
+		feeEstimator: feeEstimator,
+		nonceManager: nonceManager,
+		keys:         keys,
+		assets:       assets,
+		validator:    validator,
+		addressBook:  NewAddressBookService(db, validator, cfg),
+		treasury:     NewTreasuryService(db, chains, assets, feeEstimator, broadcaster, keys, cfg),
+		broadcaster:  broadcaster,
+		cfg:          cfg,
+	}, nil
+}
+
+// GetChains 返回各网络的链
+func (s *CryptoWalletService) GetChains() Chains {
+	return s.chains
+}
+
+// GetConfirmationPolicy 返回各网络的确认数要求
+func (s *CryptoWalletService) GetConfirmationPolicy() config.ConfirmationPolicy {
+	return s.cfg.Confirmations
+}
+
+// GetAssets 返回资产注册表
+func (s *CryptoWalletService) GetAssets() *AssetRegistry {
+	return s.assets
+}
+
+// GetAddressValidator 返回按配置的比特币网络校验地址的校验器
+func (s *CryptoWalletService) GetAddressValidator() *AddressValidator {
+	return s.validator
+}
+
+// GetBroadcaster 返回发件箱广播器
+func (s *CryptoWalletService) GetBroadcaster() *Broadcaster {
+	return s.broadcaster
+}
+
+// GetTreasury 返回热/冷钱包管理
+func (s *CryptoWalletService) GetTreasury() *TreasuryService {
+	return s.treasury
+}
+
+// GetAddressBook 返回提现地址簿
+func (s *CryptoWalletService) GetAddressBook() *AddressBookService {
+	return s.addressBook
+}
+
+// EstimateFees 返回指定网络各档速度的手续费估算，asset 为空时按原生币转账估算
+func (s *CryptoWalletService) EstimateFees(network models.Network, asset string) ([]*FeeQuote, error) {
+	registered, err := s.assets.Get(network, asset)
+	if err != nil {
+		return nil, err
 	}
+	return s.feeEstimator.Estimate(network, !registered.IsNative())
 }
 
-// GetBlockchain 返回当前链
-func (s *CryptoWalletService) GetBlockchain() *MockBlockchain {
-	return s.blockchain
+// GetBalances 返回钱包各项资产的余额，以资产符号为键
+func (s *CryptoWalletService) GetBalances(walletID uint) (map[string]float64, error) {
+	var wallet models.CryptoWallet
+	if err := s.db.First(&wallet, walletID).Error; err != nil {
+		return nil, fmt.Errorf("wallet not found: %v", err)
+	}
+
+	var tokenBalances []models.CryptoWalletBalance
+	if err := s.db.Where("wallet_id = ?", walletID).Find(&tokenBalances).Error; err != nil {
+		return nil, err
+	}
+
+	balances := map[string]float64{wallet.Network.NativeSymbol(): wallet.Balance}
+	for _, balance := range tokenBalances {
+		balances[balance.Asset] = balance.Balance
+	}
+	return balances, nil
+}
+
+// InspectNonces 返回发送地址的 nonce 空洞和卡住的交易
+func (s *CryptoWalletService) InspectNonces(network models.Network, address string) (*NonceReport, error) {
+	return s.nonceManager.Inspect(network, address)
 }
 
 // CreateWallet 创建一个钱包
 func (s *CryptoWalletService) CreateWallet(userID uint, network string) (*models.CryptoWallet, error) {
-	// 创建钱包地址
-	address := GenerateAddress()
+	// 共享充值地址模式下所有钱包使用同一地址并各自分配备注，否则在事务内生成新地址
+	var address string
+	shared := s.cfg.SharedDepositAddress(models.Network(network))
+	if shared {
+		deposit, err := s.treasury.SystemWallet(models.Network(network), models.WalletTierDeposit)
+		if err != nil {
+			return nil, err
+		}
+		address = deposit.Address
+	}
 
 	var wallet *models.CryptoWallet
 
@@ -43,6 +156,12 @@ func (s *CryptoWalletService) CreateWallet(userID uint, network string) (*models
 			return err
 		}
 
+		if !shared {
+			if address, err = s.keys.NewAddress(tx, models.Network(network)); err != nil {
+				return err
+			}
+		}
+
 		// 创建钱包
 		wallet = &models.CryptoWallet{
 			UserID:    userID,
@@ -51,12 +170,25 @@ func (s *CryptoWalletService) CreateWallet(userID uint, network string) (*models
 			Balance:   0,
 			ExtraData: "{}", // Initialize empty JSON object
 		}
+		if shared {
+			memo, err := generateMemo(tx, wallet.Network)
+			if err != nil {
+				return err
+			}
+			wallet.Memo = memo
+		}
 
 		if err := tx.Create(wallet).Error; err != nil {
 			return err
 		}
 
-		return nil
+		// 记录钱包的第一个充值地址
+		return tx.Create(&models.CryptoWalletAddress{
+			WalletID: wallet.ID,
+			Network:  wallet.Network,
+			Address:  wallet.Address,
+			Status:   models.WalletAddressActive,
+		}).Error
 	})
 
 	if err != nil {
@@ -75,10 +207,22 @@ func (s *CryptoWalletService) GetWallet(walletID uint) (*models.CryptoWallet, er
 	return &wallet, nil
 }
 
-// GetWalletByAddress 根据地址获取钱包
+// GetWalletByAddress 根据地址获取钱包，已轮换的旧地址仍归属于原钱包
 func (s *CryptoWalletService) GetWalletByAddress(address string) (*models.CryptoWallet, error) {
 	var wallet models.CryptoWallet
-	if err := s.db.Where("address = ?", address).First(&wallet).Error; err != nil {
+	err := s.db.Where("address = ?", address).First(&wallet).Error
+	if err != gorm.ErrRecordNotFound {
+		if err != nil {
+			return nil, err
+		}
+		return &wallet, nil
+	}
+
+	var owned models.CryptoWalletAddress
+	if err := s.db.Where("address = ?", address).First(&owned).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.First(&wallet, owned.WalletID).Error; err != nil {
 		return nil, err
 	}
 	return &wallet, nil
@@ -86,126 +230,466 @@ func (s *CryptoWalletService) GetWalletByAddress(address string) (*models.Crypto
 
 // ProcessDeposit 充值
 func (s *CryptoWalletService) ProcessDeposit(walletID uint, txHash string) error {
-	// 检查这笔交易是否已经处理过了
+	// 检查这笔交易是否已经处理过了，被重组回滚的充值重新上链后可以再次入账
 	var existingTx models.CryptoTransaction
-	err := s.db.Where("tx_hash = ?", txHash).First(&existingTx).Error
+	err := s.db.Where("tx_hash = ? AND status <> ?", txHash, models.CryptoTxStatusReversed).First(&existingTx).Error
 	if err == nil {
 		return fmt.Errorf("transaction already processed")
 	} else if err != gorm.ErrRecordNotFound {
 		return err
 	}
 
+	// 找到钱包
+	var wallet models.CryptoWallet
+	if err := s.db.First(&wallet, walletID).Error; err != nil {
+		return fmt.Errorf("wallet not found: %v", err)
+	}
+
+	chain, err := s.chains.Get(wallet.Network)
+	if err != nil {
+		return err
+	}
+
 	// 从链上获取交易信息
-	blockchainTx, err := s.blockchain.GetTransaction(txHash)
+	blockchainTx, err := chain.GetTransaction(txHash)
 	if err != nil {
 		return fmt.Errorf("failed to get transaction: %v", err)
 	}
 
-	// 校验确认信息
-	if blockchainTx.Confirmations < 6 { // 可配置
-		return fmt.Errorf("insufficient confirmations: %d/6", blockchainTx.Confirmations)
+	// 共享地址上的充值只属于备注匹配的钱包
+	if wallet.Memo != "" && blockchainTx.Memo != wallet.Memo {
+		return fmt.Errorf("deposit memo %q does not match wallet", blockchainTx.Memo)
 	}
 
-	// 找到钱包
-	var wallet models.CryptoWallet
-	if err := s.db.First(&wallet, walletID).Error; err != nil {
-		return fmt.Errorf("wallet not found: %v", err)
+	// 转入钱包任一地址（包括已轮换的旧地址）的充值都归属于该钱包
+	addresses, err := walletAddresses(s.db, &wallet)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.creditDeposit(&wallet, addresses, blockchainTx)
+	return err
+}
+
+// creditDeposit 校验确认数后将链上交易转入钱包地址的金额入账，返回充值记录
+func (s *CryptoWalletService) creditDeposit(wallet *models.CryptoWallet, addresses []string, blockchainTx *BlockchainTransaction) (*models.CryptoTransaction, error) {
+	asset, toAddress, finalAmount, err := s.depositAmount(wallet.Network, addresses, blockchainTx)
+	if err != nil {
+		return nil, err
 	}
 
-	// 确认收方地址
-	if blockchainTx.To != wallet.Address {
-		return fmt.Errorf("invalid recipient address")
+	// 校验确认信息，确认数按网络和金额分档配置
+	required := s.cfg.Confirmations.Required(wallet.Network, asset, finalAmount)
+	if blockchainTx.Confirmations < required {
+		return nil, fmt.Errorf("insufficient confirmations: %d/%d", blockchainTx.Confirmations, required)
 	}
 
-	// Convert amount from big.Int to float64
-	amount := new(big.Float).SetInt(blockchainTx.Amount)
-	finalAmount, _ := amount.Float64()
+	// 创建一条交易信息
+	txRecord := &models.CryptoTransaction{
+		WalletID:              wallet.ID,
+		Type:                  models.TransactionDeposit,
+		Network:               wallet.Network,
+		Asset:                 asset,
+		FromAddress:           blockchainTx.From,
+		ToAddress:             toAddress,
+		Memo:                  blockchainTx.Memo,
+		Amount:                finalAmount,
+		Status:                models.CryptoTxStatusCompleted,
+		TxHash:                blockchainTx.Hash,
+		Confirmations:         blockchainTx.Confirmations,
+		RequiredConfirmations: required,
+		BlockNumber:           blockchainTx.BlockNumber,
+		BlockHash:             blockchainTx.BlockHash,
+		Raw:                   string(blockchainTx.Raw),
+	}
 
 	// 开启事务落库
-	return s.db.Transaction(func(tx *gorm.DB) error {
+	err = s.db.Transaction(func(tx *gorm.DB) error {
 		// 更新余额
-		if err := tx.Model(&wallet).UpdateColumn(
-			"balance",
-			gorm.Expr("balance + ?", finalAmount),
-		).Error; err != nil {
+		if err := adjustBalance(tx, wallet.ID, wallet.Network, asset, finalAmount); err != nil {
 			return err
 		}
+		valueTransaction(tx, txRecord)
+		return tx.Create(txRecord).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return txRecord, nil
+}
 
-		// 创建一条交易信息
-		txRecord := &models.CryptoTransaction{
-			WalletID:      walletID,
-			Type:          models.TransactionDeposit,
-			Network:       wallet.Network,
-			FromAddress:   blockchainTx.From,
-			ToAddress:     wallet.Address,
-			Amount:        finalAmount,
-			Status:        "completed",
-			TxHash:        txHash,
-			Confirmations: blockchainTx.Confirmations,
-			BlockNumber:   blockchainTx.BlockNumber,
-			Raw:           string(blockchainTx.Raw),
+// depositAmount 返回交易转入地址的资产、收款地址和金额，链上金额为最小单位，转换为以币计。
+// 一笔交易转入同一钱包的多个地址时合并入账，收款地址记为其中第一个
+func (s *CryptoWalletService) depositAmount(network models.Network, addresses []string, blockchainTx *BlockchainTransaction) (string, string, float64, error) {
+	// 原生币未转入时按代币的 Transfer 事件入账
+	var (
+		toAddress string
+		received  = big.NewInt(0)
+	)
+	for _, address := range addresses {
+		amount := receivedAmount(blockchainTx, address)
+		if amount.Sign() <= 0 {
+			continue
+		}
+		if toAddress == "" {
+			toAddress = address
 		}
+		received.Add(received, amount)
+	}
+	if received.Sign() > 0 {
+		return network.NativeSymbol(), toAddress, fromBaseUnits(network, received), nil
+	}
 
-		return tx.Create(txRecord).Error
-	})
+	token, toAddress, amount, err := s.receivedToken(network, addresses, blockchainTx)
+	if err != nil {
+		return "", "", 0, err
+	}
+	return token.Symbol, toAddress, fromUnits(amount, token.Decimals), nil
 }
 
-// Withdraw 提现功能
-func (s *CryptoWalletService) Withdraw(walletID uint, toAddress string, amount float64) (string, error) {
+// Withdraw 提现功能，由网络的热钱包发出，手续费从用户钱包余额中额外扣除。
+// 事务内只冻结本金和预估手续费并记录提现，超过审批阈值的提现进入 pending_approval 等待审批，
+// 其余写入发件箱，提交后由广播器发送
+func (s *CryptoWalletService) Withdraw(walletID uint, toAddress string, amount float64, opts WithdrawOptions) (*models.CryptoTransaction, error) {
 	var (
-		txHash string
-		err    error
+		txRecord *models.CryptoTransaction
+		intent   *models.BroadcastIntent
 	)
 
-	err = s.db.Transaction(func(tx *gorm.DB) error {
+	err := s.db.Transaction(func(tx *gorm.DB) error {
 		var wallet models.CryptoWallet
 		if err := tx.First(&wallet, walletID).Error; err != nil {
 			return fmt.Errorf("wallet not found: %v", err)
 		}
 
-		if wallet.Balance < amount {
-			return fmt.Errorf("insufficient balance")
+		// 拒绝格式错误、校验和错误或属于其他网络的目标地址，避免资金丢失
+		if err := s.validator.ValidateAddress(wallet.Network, toAddress); err != nil {
+			return err
+		}
+		// 开启白名单模式的用户只能提现到地址簿中已过冷静期的地址
+		if err := s.addressBook.CheckWithdrawal(tx, wallet.UserID, wallet.Network, toAddress); err != nil {
+			return err
 		}
 
-		value := new(big.Float).SetFloat64(amount)
-		intValue, _ := value.Int(nil)
+		asset, err := s.assets.Get(wallet.Network, opts.Asset)
+		if err != nil {
+			return err
+		}
+		hot, err := s.treasury.SystemWallet(wallet.Network, models.WalletTierHot)
+		if err != nil {
+			return err
+		}
 
-		hash, err := s.blockchain.SendTransaction(wallet.Address, toAddress, intValue)
+		value := toUnits(amount, asset.Decimals)
+		quote, err := s.quoteFee(hot.Address, wallet.Network, asset, value, opts)
 		if err != nil {
-			return fmt.Errorf("blockchain transaction failed: %v", err)
+			return err
 		}
-		txHash = hash
 
-		if err := tx.Model(&wallet).UpdateColumn(
-			"balance",
-			gorm.Expr("balance - ?", amount),
-		).Error; err != nil {
-			return fmt.Errorf("failed to update balance: %v", err)
+		// 代币提现的手续费以原生币支付，需分别校验两项余额
+		if asset.IsNative() {
+			if wallet.Balance < amount+quote.Fee {
+				return fmt.Errorf("insufficient balance")
+			}
+		} else {
+			tokenBalance, err := assetBalance(tx, &wallet, asset.Symbol)
+			if err != nil {
+				return err
+			}
+			if tokenBalance < amount {
+				return fmt.Errorf("insufficient %s balance", asset.Symbol)
+			}
+			if wallet.Balance < quote.Fee {
+				return fmt.Errorf("insufficient %s balance for fee", wallet.Network.NativeSymbol())
+			}
 		}
 
-		txRecord := &models.CryptoTransaction{
-			WalletID:    walletID,
-			Type:        models.TransactionWithdraw,
-			Network:     wallet.Network,
-			FromAddress: wallet.Address,
-			ToAddress:   toAddress,
-			Amount:      amount,
-			Status:      "processing",
-			TxHash:      hash,
+		txRecord = &models.CryptoTransaction{
+			WalletID:              walletID,
+			Type:                  models.TransactionWithdraw,
+			Network:               wallet.Network,
+			Asset:                 asset.Symbol,
+			FromAddress:           hot.Address,
+			ToAddress:             toAddress,
+			Memo:                  opts.Memo,
+			Amount:                amount,
+			Status:                models.CryptoTxStatusCreated,
+			RequiredConfirmations: s.cfg.Confirmations.Required(wallet.Network, asset.Symbol, amount),
+			Fee:                   quote.Fee, // 广播前为冻结的预估手续费
 		}
 
+		approvals := s.cfg.Approvals
+		if approvals.RequiresApproval(asset.Symbol, amount) {
+			expiresAt := s.cfg.Clock.Now().Add(approvals.Expiry)
+			txRecord.Status = models.CryptoTxStatusPendingApproval
+			txRecord.ApprovalsRequired = approvals.Required
+			txRecord.ApprovalExpiresAt = &expiresAt
+		}
+
+		valueTransaction(tx, txRecord)
 		if err := tx.Create(txRecord).Error; err != nil {
 			return fmt.Errorf("failed to create transaction record: %v", err)
 		}
 
-		return nil
+		if err := adjustBalance(tx, walletID, wallet.Network, asset.Symbol, -amount); err != nil {
+			return fmt.Errorf("failed to update balance: %v", err)
+		}
+		if err := adjustBalance(tx, walletID, wallet.Network, "", -quote.Fee); err != nil {
+			return fmt.Errorf("failed to update balance: %v", err)
+		}
+
+		if txRecord.Status == models.CryptoTxStatusPendingApproval {
+			return nil
+		}
+		intent = withdrawalIntent(txRecord, asset, quote)
+		return s.broadcaster.Enqueue(tx, intent)
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	s.dispatch(intent, txRecord)
+	return txRecord, nil
+}
+
+// withdrawalIntent 按冻结时的手续费报价生成提现的广播意图
+func withdrawalIntent(record *models.CryptoTransaction, asset *models.Asset, quote *FeeQuote) *models.BroadcastIntent {
+	return &models.BroadcastIntent{
+		SourceType:     models.BroadcastSourceWithdrawal,
+		SourceID:       record.ID,
+		Network:        record.Network,
+		FromAddress:    record.FromAddress,
+		ToAddress:      record.ToAddress,
+		Memo:           record.Memo,
+		Amount:         toUnits(record.Amount, asset.Decimals).String(),
+		TokenContract:  asset.ContractAddress,
+		Fee:            formatBaseUnits(quote.feeBaseUnits),
+		GasPrice:       formatBaseUnits(quote.feeCap()),
+		MaxPriorityFee: formatBaseUnits(quote.MaxPriorityFeePerGas),
+		GasLimit:       quote.GasLimit,
+	}
+}
+
+// dispatch 在事务提交后立即尝试广播并重新加载记录，失败的意图留给广播器重试
+func (s *CryptoWalletService) dispatch(intent *models.BroadcastIntent, record *models.CryptoTransaction) {
+	if intent == nil {
+		return
+	}
+	if err := s.broadcaster.Process(intent.ID); err != nil {
+		log.Printf("broadcast of transaction %d deferred: %v", record.ID, err)
+	}
+	if err := s.db.First(record, record.ID).Error; err != nil {
+		log.Printf("failed to reload transaction %d: %v", record.ID, err)
+	}
+}
+
+// ReplaceWithdrawal 以相同 nonce 和更高的手续费重新广播卡住的提现，返回新的交易哈希。
+// 额外手续费在事务内预扣，替换交易经发件箱发送，被链接受后提现记录才改为跟踪新交易
+func (s *CryptoWalletService) ReplaceWithdrawal(transactionID uint, speed FeeSpeed) (string, error) {
+	if speed == "" {
+		speed = FeeSpeedFast
+	}
+
+	var intent *models.BroadcastIntent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var record models.CryptoTransaction
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&record, transactionID).Error; err != nil {
+			return fmt.Errorf("transaction not found: %v", err)
+		}
+		if record.Type != models.TransactionWithdraw || record.Nonce == nil {
+			return fmt.Errorf("only nonce based withdrawals can be replaced")
+		}
+		if record.Status != models.CryptoTxStatusBroadcast {
+			return fmt.Errorf("withdrawal is %s and cannot be replaced", record.Status)
+		}
+
+		var replacing int64
+		if err := tx.Model(&models.BroadcastIntent{}).
+			Where("source_type = ? AND source_id = ? AND status = ?",
+				models.BroadcastSourceReplacement, record.ID, models.BroadcastIntentPending).
+			Count(&replacing).Error; err != nil {
+			return err
+		}
+		if replacing > 0 {
+			return fmt.Errorf("a replacement for this withdrawal is already pending")
+		}
+
+		chain, err := s.chains.Get(record.Network)
+		if err != nil {
+			return err
+		}
+		chainTx, err := chain.GetTransaction(record.TxHash)
+		if err != nil {
+			return fmt.Errorf("failed to get transaction: %v", err)
+		}
+		// 只有仍在交易池中、节点接受同 nonce 替换的交易可以替换
+		if !chainTx.Replaceable {
+			return fmt.Errorf("transaction is %s and cannot be replaced", chainTx.Status)
+		}
+
+		// 交易池中的交易没有回执，转账内容和 gas 参数取自签名时的广播意图
+		var sent models.BroadcastIntent
+		if err := tx.Where("tx_hash = ?", record.TxHash).First(&sent).Error; err != nil {
+			return fmt.Errorf("broadcast intent of transaction %s not found: %v", record.TxHash, err)
+		}
+		sentPrice := parseBaseUnits(sent.GasPrice)
+		if sentPrice == nil || sent.GasLimit == 0 {
+			return fmt.Errorf("gas price and limit of transaction %s are unknown", record.TxHash)
+		}
+
+		// 新交易的单价（EIP-1559 交易的单价上限和小费上限）至少比原交易高出替换所需的幅度
+		token := !isNativeAsset(record.Network, record.Asset)
+		quote, err := s.feeEstimator.Quote(record.Network, speed, token)
+		if err != nil {
+			return err
+		}
+		gasPrice := bumpFee(sentPrice, quote.feeCap())
+		var priorityFee *big.Int
+		if sentTip := parseBaseUnits(sent.MaxPriorityFee); sentTip != nil {
+			priorityFee = bumpFee(sentTip, quote.MaxPriorityFeePerGas)
+			if priorityFee.Cmp(gasPrice) > 0 {
+				gasPrice = priorityFee
+			}
+		}
+		// EIP-1559 交易按单价上限冻结，上链后按实际手续费结算
+		feeValue := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(sent.GasLimit))
+
+		var wallet models.CryptoWallet
+		if err := tx.First(&wallet, record.WalletID).Error; err != nil {
+			return fmt.Errorf("wallet not found: %v", err)
+		}
+		// 额外冻结新旧手续费的差额，替换交易被接受或发送失败时按此金额结算
+		extraFee := new(big.Int).Sub(feeValue, toBaseUnits(record.Network, record.Fee))
+		if extraFee.Sign() < 0 {
+			extraFee.SetInt64(0)
+		}
+		extra := fromBaseUnits(record.Network, extraFee)
+		if wallet.Balance < extra {
+			return fmt.Errorf("insufficient balance for replacement fee")
+		}
+
+		if err := tx.Model(&wallet).UpdateColumn(
+			"balance",
+			gorm.Expr("balance - ?", extra),
+		).Error; err != nil {
+			return fmt.Errorf("failed to update balance: %v", err)
+		}
+
+		intent = &models.BroadcastIntent{
+			SourceType:     models.BroadcastSourceReplacement,
+			SourceID:       record.ID,
+			Network:        record.Network,
+			FromAddress:    record.FromAddress,
+			ToAddress:      record.ToAddress,
+			Memo:           record.Memo,
+			Amount:         sent.Amount,
+			TokenContract:  sent.TokenContract,
+			Fee:            feeValue.String(),
+			GasPrice:       gasPrice.String(),
+			MaxPriorityFee: formatBaseUnits(priorityFee),
+			GasLimit:       sent.GasLimit,
+			ExtraFee:       extraFee.String(),
+			Nonce:          record.Nonce,
+		}
+		return s.broadcaster.Enqueue(tx, intent)
 	})
 
 	if err != nil {
 		return "", err
 	}
 
-	return txHash, nil
+	// 替换交易签名后即有哈希，发送失败时由广播器继续重试
+	if err := s.broadcaster.Process(intent.ID); err != nil {
+		log.Printf("broadcast of replacement for transaction %d deferred: %v", transactionID, err)
+	}
+	if err := s.db.First(intent, intent.ID).Error; err != nil {
+		return "", err
+	}
+	return intent.TxHash, nil
+}
+
+// bumpFee 返回比原交易的 sent 高出两倍替换幅度的值，当前报价 quoted 更高时取报价
+func bumpFee(sent, quoted *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(sent, big.NewInt(100+2*replacementFeeBump))
+	bumped.Div(bumped, big.NewInt(100))
+	if quoted != nil && quoted.Cmp(bumped) > 0 {
+		return quoted
+	}
+	return bumped
+}
+
+// quoteFee 按提现选项选择从 from 发出的手续费报价
+func (s *CryptoWalletService) quoteFee(from string, network models.Network, asset *models.Asset, value *big.Int, opts WithdrawOptions) (*FeeQuote, error) {
+	var (
+		quote *FeeQuote
+		err   error
+	)
+	if opts.Speed == "" && opts.MaxFee > 0 {
+		quote, err = s.feeEstimator.QuoteWithin(network, opts.MaxFee, !asset.IsNative())
+	} else {
+		quote, err = s.feeEstimator.Quote(network, opts.Speed, !asset.IsNative())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.feeEstimator.RefineTransfer(network, quote, from, value); err != nil {
+		return nil, err
+	}
+	if opts.MaxFee > 0 && quote.Fee > opts.MaxFee {
+		return nil, fmt.Errorf("estimated fee %v exceeds max fee %v", quote.Fee, opts.MaxFee)
+	}
+	return quote, nil
+}
+
+// receivedToken 从交易的 Transfer 事件中找出转入地址的已注册代币、首个收款地址及金额
+func (s *CryptoWalletService) receivedToken(network models.Network, addresses []string, tx *BlockchainTransaction) (*models.Asset, string, *big.Int, error) {
+	var (
+		asset     *models.Asset
+		toAddress string
+		total     = big.NewInt(0)
+	)
+	for _, transfer := range tx.TokenTransfers {
+		if !containsAddress(addresses, transfer.To) {
+			continue
+		}
+		token, err := s.assets.FindByContract(network, transfer.Contract)
+		if err != nil {
+			// 未注册的代币不入账
+			continue
+		}
+		if asset != nil && asset.ID != token.ID {
+			return nil, "", nil, fmt.Errorf("transaction transfers multiple tokens")
+		}
+		if toAddress == "" {
+			toAddress = transfer.To
+		}
+		asset = token
+		total.Add(total, transfer.Amount)
+	}
+	if asset == nil {
+		return nil, "", nil, fmt.Errorf("invalid recipient address")
+	}
+	return asset, toAddress, total, nil
+}
+
+// receivedAmount 返回交易支付给 address 的金额，UTXO 交易按输出累加
+func receivedAmount(tx *BlockchainTransaction, address string) *big.Int {
+	if len(tx.Outputs) == 0 {
+		if tx.To == address {
+			return tx.Amount
+		}
+		return big.NewInt(0)
+	}
+
+	total := big.NewInt(0)
+	for _, output := range tx.Outputs {
+		if output.Address == address {
+			total.Add(total, output.Amount)
+		}
+	}
+	return total
 }
 
 // GetTransactions 获取交易信息历史
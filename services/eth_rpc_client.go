@@ -0,0 +1,585 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"log"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHistoryBlocks bounds the number of blocks GetTransactionHistory scans,
+// longer ranges need an indexer
+const maxHistoryBlocks = 5000
+
+// balanceOfSelector is the selector of balanceOf(address)
+const balanceOfSelector = "0x70a08231"
+
+// EthRPCClient talks to Ethereum compatible nodes (ETH, BSC) over JSON-RPC
+type EthRPCClient struct {
+	rpc *jsonRPCClient
+
+	mutex       sync.Mutex
+	lastBlock   uint64   // Returned by CurrentBlock when no node answers
+	lastBaseFee *big.Int // Returned by BaseFee when no node answers
+}
+
+// NewEthRPCClient creates a client for the nodes of policy
+func NewEthRPCClient(policy config.NodePolicy) (*EthRPCClient, error) {
+	rpc, err := newJSONRPCClient(policy, "2.0")
+	if err != nil {
+		return nil, err
+	}
+	return &EthRPCClient{rpc: rpc, lastBaseFee: big.NewInt(0)}, nil
+}
+
+// hexUint64 decodes a JSON-RPC quantity
+type hexUint64 uint64
+
+func (q *hexUint64) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, err := strconv.ParseUint(strings.TrimPrefix(s, "0x"), 16, 64)
+	if err != nil {
+		return fmt.Errorf("invalid quantity %q", s)
+	}
+	*q = hexUint64(value)
+	return nil
+}
+
+// hexBigInt decodes a JSON-RPC quantity of any size
+type hexBigInt big.Int
+
+func (q *hexBigInt) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	value, ok := parseHexBig(s)
+	if !ok {
+		return fmt.Errorf("invalid quantity %q", s)
+	}
+	*q = hexBigInt(*value)
+	return nil
+}
+
+func (q *hexBigInt) int() *big.Int {
+	if q == nil {
+		return nil
+	}
+	value := big.Int(*q)
+	return new(big.Int).Set(&value)
+}
+
+func parseHexBig(s string) (*big.Int, bool) {
+	digits := strings.TrimPrefix(s, "0x")
+	if digits == "" {
+		return big.NewInt(0), true
+	}
+	return new(big.Int).SetString(digits, 16)
+}
+
+type ethRPCTransaction struct {
+	Hash        string     `json:"hash"`
+	Nonce       hexUint64  `json:"nonce"`
+	BlockHash   *string    `json:"blockHash"`
+	BlockNumber *hexUint64 `json:"blockNumber"`
+	From        string     `json:"from"`
+	To          *string    `json:"to"`
+	Value       *hexBigInt `json:"value"`
+	Gas         hexUint64  `json:"gas"`
+	GasPrice    *hexBigInt `json:"gasPrice"`
+}
+
+type ethRPCReceipt struct {
+	BlockHash         string      `json:"blockHash"`
+	BlockNumber       hexUint64   `json:"blockNumber"`
+	GasUsed           hexUint64   `json:"gasUsed"`
+	EffectiveGasPrice *hexBigInt  `json:"effectiveGasPrice"`
+	Status            hexUint64   `json:"status"`
+	Logs              []ethRPCLog `json:"logs"`
+}
+
+type ethRPCLog struct {
+	Address         string    `json:"address"`
+	Topics          []string  `json:"topics"`
+	Data            string    `json:"data"`
+	LogIndex        hexUint64 `json:"logIndex"`
+	TransactionHash string    `json:"transactionHash"`
+}
+
+// ethRPCBlock is a block header, transactions are only decoded when
+// requested in full
+type ethRPCBlock struct {
+	Number    hexUint64 `json:"number"`
+	Hash      string    `json:"hash"`
+	Timestamp hexUint64 `json:"timestamp"`
+}
+
+// GetTransaction combines the transaction, its receipt and its block
+func (c *EthRPCClient) GetTransaction(txHash string) (*BlockchainTransaction, error) {
+	var tx *ethRPCTransaction
+	if err := c.rpc.call(&tx, "eth_getTransactionByHash", txHash); err != nil {
+		return nil, err
+	}
+	if tx == nil {
+		return nil, ErrTransactionNotFound
+	}
+
+	result := &BlockchainTransaction{
+		Hash:     tx.Hash,
+		From:     toChecksumAddress(tx.From),
+		Amount:   tx.Value.int(),
+		Nonce:    uint64(tx.Nonce),
+		GasPrice: tx.GasPrice.int(),
+		Status:   "pending",
+	}
+	if tx.To != nil {
+		result.To = toChecksumAddress(*tx.To)
+	}
+	if result.Amount == nil {
+		result.Amount = big.NewInt(0)
+	}
+	if tx.BlockHash == nil || tx.BlockNumber == nil {
+		// The pool replaces a pending transaction with a higher priced one
+		result.Replaceable = true
+		return result, nil
+	}
+
+	var receipt *ethRPCReceipt
+	if err := c.rpc.call(&receipt, "eth_getTransactionReceipt", txHash); err != nil {
+		return nil, err
+	}
+	if receipt == nil {
+		return result, nil
+	}
+	block, err := c.blockHeader(uint64(receipt.BlockNumber))
+	if err != nil {
+		return nil, err
+	}
+	tip, err := c.blockNumber()
+	if err != nil {
+		return nil, err
+	}
+	c.applyReceipt(result, receipt, block, tip)
+	return result, nil
+}
+
+// applyReceipt fills in the execution result of a mined transaction
+func (c *EthRPCClient) applyReceipt(tx *BlockchainTransaction, receipt *ethRPCReceipt, block *ethRPCBlock, tip uint64) {
+	tx.BlockNumber = uint64(receipt.BlockNumber)
+	tx.BlockHash = receipt.BlockHash
+	tx.Timestamp = time.Unix(int64(block.Timestamp), 0)
+	if tip >= tx.BlockNumber {
+		tx.Confirmations = int(tip-tx.BlockNumber) + 1
+	}
+	tx.Status = "success"
+	if receipt.Status == 0 {
+		tx.Status = "failed"
+	}
+
+	tx.GasUsed = uint64(receipt.GasUsed)
+	if price := receipt.EffectiveGasPrice.int(); price != nil {
+		tx.GasPrice = price
+	}
+	if tx.GasPrice != nil {
+		tx.Fee = new(big.Int).Mul(tx.GasPrice, new(big.Int).SetUint64(tx.GasUsed))
+	}
+
+	for _, entry := range receipt.Logs {
+		if transfer, ok := decodeTransferLog(entry); ok {
+			tx.TokenTransfers = append(tx.TokenTransfers, transfer)
+		}
+	}
+}
+
+// decodeTransferLog reads an ERC-20 Transfer event
+func decodeTransferLog(entry ethRPCLog) (TokenTransfer, bool) {
+	if len(entry.Topics) != 3 || !strings.EqualFold(entry.Topics[0], transferEventTopic) {
+		return TokenTransfer{}, false
+	}
+	amount, ok := parseHexBig(entry.Data)
+	if !ok {
+		return TokenTransfer{}, false
+	}
+	return TokenTransfer{
+		Contract: toChecksumAddress(entry.Address),
+		From:     topicAddress(entry.Topics[1]),
+		To:       topicAddress(entry.Topics[2]),
+		Amount:   amount,
+		LogIndex: uint(entry.LogIndex),
+	}, true
+}
+
+// topicAddress returns the address held in the low 20 bytes of a topic
+func topicAddress(topic string) string {
+	word := strings.TrimPrefix(topic, "0x")
+	if len(word) > 40 {
+		word = word[len(word)-40:]
+	}
+	return toChecksumAddress(word)
+}
+
+// ChainID returns the EIP-155 chain ID the nodes sign for
+func (c *EthRPCClient) ChainID() (*big.Int, error) {
+	var chainID hexBigInt
+	if err := c.rpc.call(&chainID, "eth_chainId"); err != nil {
+		return nil, err
+	}
+	return chainID.int(), nil
+}
+
+// SendRawTransaction broadcasts a hex encoded signed transaction
+func (c *EthRPCClient) SendRawTransaction(raw string) (string, error) {
+	if !strings.HasPrefix(raw, "0x") {
+		raw = "0x" + raw
+	}
+
+	var hash string
+	err := c.rpc.call(&hash, "eth_sendRawTransaction", raw)
+	var rpcErr *rpcError
+	if errors.As(err, &rpcErr) && isKnownTransactionError(rpcErr.Message) {
+		return "", ErrTransactionKnown
+	}
+	if err != nil {
+		return "", err
+	}
+	return hash, nil
+}
+
+// isKnownTransactionError matches the messages geth and its forks use for
+// transactions already in the pool
+func isKnownTransactionError(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "already known") || strings.Contains(message, "known transaction")
+}
+
+// GetTransactionHistory scans the blocks between startTime and endTime for
+// transfers of the native coin and Transfer events involving address
+func (c *EthRPCClient) GetTransactionHistory(address string, startTime, endTime time.Time) ([]*BlockchainTransaction, error) {
+	from, to, err := c.blockRange(startTime, endTime)
+	if err != nil {
+		return nil, err
+	}
+	if from > to {
+		return nil, nil
+	}
+	if to-from+1 > maxHistoryBlocks {
+		return nil, fmt.Errorf("history spans %d blocks, more than the %d a node scan supports", to-from+1, maxHistoryBlocks)
+	}
+
+	hashes := make(map[string]bool)
+	for number := from; number <= to; number++ {
+		var block *struct {
+			Transactions []ethRPCTransaction `json:"transactions"`
+		}
+		if err := c.rpc.call(&block, "eth_getBlockByNumber", hexUint(number), true); err != nil {
+			return nil, err
+		}
+		if block == nil {
+			continue
+		}
+		for _, tx := range block.Transactions {
+			if strings.EqualFold(tx.From, address) || (tx.To != nil && strings.EqualFold(*tx.To, address)) {
+				hashes[tx.Hash] = true
+			}
+		}
+	}
+
+	// Token transfers in either direction
+	for _, topics := range [][]interface{}{
+		{transferEventTopic, padWord(address)},
+		{transferEventTopic, nil, padWord(address)},
+	} {
+		var logs []ethRPCLog
+		filter := map[string]interface{}{"fromBlock": hexUint(from), "toBlock": hexUint(to), "topics": topics}
+		if err := c.rpc.call(&logs, "eth_getLogs", filter); err != nil {
+			return nil, err
+		}
+		for _, entry := range logs {
+			hashes[entry.TransactionHash] = true
+		}
+	}
+
+	transactions := make([]*BlockchainTransaction, 0, len(hashes))
+	for hash := range hashes {
+		tx, err := c.GetTransaction(hash)
+		if errors.Is(err, ErrTransactionNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if tx.Timestamp.After(startTime) && tx.Timestamp.Before(endTime) {
+			transactions = append(transactions, tx)
+		}
+	}
+	sort.Slice(transactions, func(i, j int) bool { return transactions[i].BlockNumber < transactions[j].BlockNumber })
+	return transactions, nil
+}
+
+// blockRange finds the first block after startTime and the last block
+// before endTime by binary search over block timestamps
+func (c *EthRPCClient) blockRange(startTime, endTime time.Time) (uint64, uint64, error) {
+	tip, err := c.blockNumber()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	from, err := c.searchBlocks(tip, func(t time.Time) bool { return t.After(startTime) })
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := c.searchBlocks(tip, func(t time.Time) bool { return !t.Before(endTime) })
+	if err != nil {
+		return 0, 0, err
+	}
+	if end == 0 {
+		return 1, 0, nil
+	}
+	return from, end - 1, nil
+}
+
+// blockAt returns the last block mined at or before t, genesis when t
+// precedes every block
+func (c *EthRPCClient) blockAt(t time.Time) (uint64, error) {
+	tip, err := c.blockNumber()
+	if err != nil {
+		return 0, err
+	}
+	next, err := c.searchBlocks(tip, func(blockTime time.Time) bool { return blockTime.After(t) })
+	if err != nil {
+		return 0, err
+	}
+	if next == 0 {
+		return 0, nil
+	}
+	return next - 1, nil
+}
+
+// searchBlocks returns the lowest height up to tip+1 whose block time
+// satisfies match, which must hold for every later block as well
+func (c *EthRPCClient) searchBlocks(tip uint64, match func(t time.Time) bool) (uint64, error) {
+	low, high := uint64(0), tip+1
+	for low < high {
+		mid := low + (high-low)/2
+		block, err := c.blockHeader(mid)
+		if err != nil {
+			return 0, err
+		}
+		if match(time.Unix(int64(block.Timestamp), 0)) {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	return low, nil
+}
+
+func (c *EthRPCClient) blockHeader(number uint64) (*ethRPCBlock, error) {
+	var block *ethRPCBlock
+	if err := c.rpc.call(&block, "eth_getBlockByNumber", hexUint(number), false); err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	return block, nil
+}
+
+// GetAddressBalance returns the native balance at the latest block
+func (c *EthRPCClient) GetAddressBalance(address string) (*big.Int, error) {
+	return c.balance(address, "latest")
+}
+
+// GetAddressBalanceAt returns the native balance after block number, which
+// needs an archive node for blocks older than the node keeps state for
+func (c *EthRPCClient) GetAddressBalanceAt(address string, number uint64) (*big.Int, error) {
+	return c.balance(address, hexUint(number))
+}
+
+// GetAddressBalanceAtTime returns the native balance after the last block
+// mined at or before t
+func (c *EthRPCClient) GetAddressBalanceAtTime(address string, t time.Time) (*big.Int, error) {
+	number, err := c.blockAt(t)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetAddressBalanceAt(address, number)
+}
+
+func (c *EthRPCClient) balance(address, block string) (*big.Int, error) {
+	var balance hexBigInt
+	if err := c.rpc.call(&balance, "eth_getBalance", address, block); err != nil {
+		return nil, err
+	}
+	return balance.int(), nil
+}
+
+// GetTokenBalance calls balanceOf(address) on the token contract
+func (c *EthRPCClient) GetTokenBalance(contract, address string) (*big.Int, error) {
+	return c.tokenBalance(contract, address, "latest")
+}
+
+// GetTokenBalanceAt calls balanceOf(address) against the state after block
+// number
+func (c *EthRPCClient) GetTokenBalanceAt(contract, address string, number uint64) (*big.Int, error) {
+	return c.tokenBalance(contract, address, hexUint(number))
+}
+
+// GetTokenBalanceAtTime calls balanceOf(address) against the state after the
+// last block mined at or before t
+func (c *EthRPCClient) GetTokenBalanceAtTime(contract, address string, t time.Time) (*big.Int, error) {
+	number, err := c.blockAt(t)
+	if err != nil {
+		return nil, err
+	}
+	return c.GetTokenBalanceAt(contract, address, number)
+}
+
+func (c *EthRPCClient) tokenBalance(contract, address, block string) (*big.Int, error) {
+	call := map[string]string{
+		"to":   contract,
+		"data": balanceOfSelector + strings.TrimPrefix(padWord(address), "0x"),
+	}
+	var result string
+	if err := c.rpc.call(&result, "eth_call", call, block); err != nil {
+		return nil, err
+	}
+	data, err := hex.DecodeString(strings.TrimPrefix(result, "0x"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid balanceOf result: %v", err)
+	}
+	return new(big.Int).SetBytes(data), nil
+}
+
+// GetNonce returns the next nonce including transactions still in the pool
+func (c *EthRPCClient) GetNonce(address string) (uint64, error) {
+	var nonce hexUint64
+	if err := c.rpc.call(&nonce, "eth_getTransactionCount", address, "pending"); err != nil {
+		return 0, err
+	}
+	return uint64(nonce), nil
+}
+
+// GetBlockHash returns the hash of the canonical block at the given height
+func (c *EthRPCClient) GetBlockHash(number uint64) (string, error) {
+	block, err := c.blockHeader(number)
+	if err != nil {
+		return "", err
+	}
+	return block.Hash, nil
+}
+
+// GetBlockTime returns the timestamp of the canonical block at the given
+// height
+func (c *EthRPCClient) GetBlockTime(number uint64) (time.Time, error) {
+	block, err := c.blockHeader(number)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(block.Timestamp), 0), nil
+}
+
+func (c *EthRPCClient) blockNumber() (uint64, error) {
+	var number hexUint64
+	if err := c.rpc.call(&number, "eth_blockNumber"); err != nil {
+		return 0, err
+	}
+
+	c.mutex.Lock()
+	c.lastBlock = uint64(number)
+	c.mutex.Unlock()
+	return uint64(number), nil
+}
+
+// CurrentBlock returns the height of the chain tip, or the last known height
+// when no node answers
+func (c *EthRPCClient) CurrentBlock() uint64 {
+	number, err := c.blockNumber()
+	if err != nil {
+		log.Printf("eth rpc: %v", err)
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		return c.lastBlock
+	}
+	return number
+}
+
+// FeeHistory is the result of eth_feeHistory. BaseFees has one entry more
+// than the number of blocks: the base fee of the next block.
+type FeeHistory struct {
+	OldestBlock   uint64
+	BaseFees      []*big.Int
+	GasUsedRatios []float64
+	Rewards       [][]*big.Int // Priority fees at the requested percentiles, per block
+}
+
+// FeeHistory returns the base fees and priority fee percentiles of the last
+// blocks
+func (c *EthRPCClient) FeeHistory(blocks int, percentiles []float64) (*FeeHistory, error) {
+	var result struct {
+		OldestBlock   hexUint64     `json:"oldestBlock"`
+		BaseFeePerGas []hexBigInt   `json:"baseFeePerGas"`
+		GasUsedRatio  []float64     `json:"gasUsedRatio"`
+		Reward        [][]hexBigInt `json:"reward"`
+	}
+	if percentiles == nil {
+		percentiles = []float64{}
+	}
+	if err := c.rpc.call(&result, "eth_feeHistory", hexUint(uint64(blocks)), "latest", percentiles); err != nil {
+		return nil, err
+	}
+
+	history := &FeeHistory{OldestBlock: uint64(result.OldestBlock), GasUsedRatios: result.GasUsedRatio}
+	for i := range result.BaseFeePerGas {
+		history.BaseFees = append(history.BaseFees, result.BaseFeePerGas[i].int())
+	}
+	for _, rewards := range result.Reward {
+		values := make([]*big.Int, len(rewards))
+		for i := range rewards {
+			values[i] = rewards[i].int()
+		}
+		history.Rewards = append(history.Rewards, values)
+	}
+	return history, nil
+}
+
+// BaseFee returns the base fee of the next block, or the gas price on nodes
+// without EIP-1559 support. The last known value is returned when no node
+// answers.
+func (c *EthRPCClient) BaseFee() *big.Int {
+	fee, err := c.baseFee()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err != nil {
+		log.Printf("eth rpc: %v", err)
+		return new(big.Int).Set(c.lastBaseFee)
+	}
+	c.lastBaseFee = fee
+	return new(big.Int).Set(fee)
+}
+
+func (c *EthRPCClient) baseFee() (*big.Int, error) {
+	history, err := c.FeeHistory(1, nil)
+	if err == nil && len(history.BaseFees) > 0 && history.BaseFees[len(history.BaseFees)-1].Sign() > 0 {
+		return history.BaseFees[len(history.BaseFees)-1], nil
+	}
+
+	var price hexBigInt
+	if err := c.rpc.call(&price, "eth_gasPrice"); err != nil {
+		return nil, err
+	}
+	return price.int(), nil
+}
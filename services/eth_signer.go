@@ -0,0 +1,360 @@
+package services
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"strings"
+	"sync"
+)
+
+// dynamicFeeTxType is the EIP-2718 type of EIP-1559 transactions
+const dynamicFeeTxType = 0x02
+
+// erc20TransferSelector is the selector of transfer(address,uint256)
+var erc20TransferSelector = []byte{0xa9, 0x05, 0x9c, 0xbb}
+
+// ethSigner signs EIP-155 legacy transactions, or EIP-1559 transactions
+// when dynamicFee is set. Token transfers call transfer(address,uint256) on
+// the contract; the memo of a native transfer travels in the call data.
+type ethSigner struct {
+	chain      Blockchain
+	dynamicFee bool
+
+	mutex   sync.Mutex
+	chainID *big.Int
+}
+
+func newEthSigner(chain Blockchain, dynamicFee bool) *ethSigner {
+	return &ethSigner{chain: chain, dynamicFee: dynamicFee}
+}
+
+func (s *ethSigner) getChainID() (*big.Int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.chainID == nil {
+		chain, ok := s.chain.(evmChain)
+		if !ok {
+			return nil, fmt.Errorf("chain does not report a chain ID")
+		}
+		chainID, err := chain.ChainID()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get chain ID: %v", err)
+		}
+		s.chainID = chainID
+	}
+	return s.chainID, nil
+}
+
+// SignTransaction signs a transfer from the key's address. Without opts the
+// next nonce, the current base fee and the default gas limit are used. On
+// EIP-1559 transactions GasPrice is the fee cap and MaxPriorityFee the tip
+// cap, which defaults to the fee cap so the transaction pays GasPrice like
+// a legacy one.
+func (s *ethSigner) SignTransaction(key *PrivateKey, to string, amount *big.Int, opts *TxOptions) (*SignedTransaction, error) {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	chainID, err := s.getChainID()
+	if err != nil {
+		return nil, err
+	}
+	from := key.Address(models.NetworkETH, nil)
+
+	var nonce uint64
+	if opts.Nonce != nil {
+		nonce = *opts.Nonce
+	} else {
+		accounts, ok := s.chain.(AccountChain)
+		if !ok {
+			return nil, fmt.Errorf("chain does not report nonces")
+		}
+		if nonce, err = accounts.GetNonce(from); err != nil {
+			return nil, fmt.Errorf("failed to get nonce: %v", err)
+		}
+	}
+	gasPrice := opts.GasPrice
+	if gasPrice == nil {
+		gasPrice = s.chain.BaseFee()
+	}
+	gasLimit := opts.GasLimit
+
+	recipient, value, data := to, amount, []byte(opts.Memo)
+	if opts.TokenContract != "" {
+		recipient, value = opts.TokenContract, big.NewInt(0)
+		if data, err = erc20TransferData(to, amount); err != nil {
+			return nil, err
+		}
+		if gasLimit == 0 {
+			gasLimit = tokenTransferGasLimit
+		}
+	}
+	if gasLimit == 0 {
+		gasLimit = defaultGasLimit
+	}
+	recipientBytes, err := evmAddressBytes(recipient)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	if s.dynamicFee {
+		tip := opts.MaxPriorityFee
+		if tip == nil {
+			tip = gasPrice
+		}
+		fields := []interface{}{chainID, nonce, tip, gasPrice, gasLimit, recipientBytes, value, data, []interface{}{}}
+		sig := key.sign(keccak256([]byte{dynamicFeeTxType}, rlpEncode(fields)))
+		fields = append(fields, uint64(sig.recovery), sig.r, sig.s)
+		raw = append([]byte{dynamicFeeTxType}, rlpEncode(fields)...)
+	} else {
+		fields := []interface{}{nonce, gasPrice, gasLimit, recipientBytes, value, data}
+		sig := key.sign(keccak256(rlpEncode(append(fields, chainID, uint64(0), uint64(0)))))
+		v := new(big.Int).Add(new(big.Int).Mul(chainID, big.NewInt(2)), big.NewInt(35+int64(sig.recovery)))
+		raw = rlpEncode(append(fields, v, sig.r, sig.s))
+	}
+
+	return &SignedTransaction{Hash: "0x" + hex.EncodeToString(keccak256(raw)), Raw: hex.EncodeToString(raw)}, nil
+}
+
+// erc20TransferData encodes a transfer(address,uint256) call
+func erc20TransferData(to string, amount *big.Int) ([]byte, error) {
+	recipient, err := evmAddressBytes(to)
+	if err != nil {
+		return nil, err
+	}
+	if amount.Sign() < 0 || amount.BitLen() > 256 {
+		return nil, fmt.Errorf("invalid token amount")
+	}
+	data := append([]byte{}, erc20TransferSelector...)
+	data = append(data, make([]byte, 12)...)
+	data = append(data, recipient...)
+	return append(data, amount.FillBytes(make([]byte, 32))...), nil
+}
+
+func evmAddressBytes(address string) ([]byte, error) {
+	decoded, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(address, "0x"), "0X"))
+	if err != nil || len(decoded) != 20 {
+		return nil, fmt.Errorf("invalid address %q", address)
+	}
+	return decoded, nil
+}
+
+// ethDecoder parses the transactions produced by ethSigner for the given
+// chain and recovers their sender
+func ethDecoder(chainID *big.Int) txDecoder {
+	return func(raw []byte, _ func(string, uint32) *UTXO) (*decodedTransaction, error) {
+		if len(raw) == 0 {
+			return nil, fmt.Errorf("empty transaction")
+		}
+
+		var (
+			fields   []rlpItem
+			sigHash  []byte
+			recovery byte
+			opts     = &TxOptions{}
+		)
+		switch {
+		case raw[0] == dynamicFeeTxType:
+			item, err := rlpDecode(raw[1:])
+			if err != nil || !item.isList || len(item.list) != 12 {
+				return nil, fmt.Errorf("invalid EIP-1559 transaction")
+			}
+			if item.list[0].integer().Cmp(chainID) != 0 {
+				return nil, fmt.Errorf("invalid chain id")
+			}
+			parity := item.list[9].integer()
+			if parity.Cmp(big.NewInt(1)) > 0 {
+				return nil, errInvalidSignature
+			}
+			recovery = byte(parity.Uint64())
+			opts.MaxPriorityFee = item.list[2].integer()
+			opts.GasPrice = item.list[3].integer()
+			fields = append([]rlpItem{item.list[1]}, item.list[4:]...)
+			sigHash = keccak256([]byte{dynamicFeeTxType}, rlpEncode(rlpValues(item.list[:9])))
+
+		case raw[0] >= 0xc0:
+			item, err := rlpDecode(raw)
+			if err != nil || !item.isList || len(item.list) != 9 {
+				return nil, fmt.Errorf("invalid legacy transaction")
+			}
+			// v = chainID * 2 + 35 + recovery id (EIP-155)
+			v := new(big.Int).Sub(item.list[6].integer(), big.NewInt(35))
+			v.Sub(v, new(big.Int).Mul(chainID, big.NewInt(2)))
+			if v.Sign() < 0 || v.Cmp(big.NewInt(1)) > 0 {
+				return nil, fmt.Errorf("invalid chain id or signature, transactions must be replay protected")
+			}
+			recovery = byte(v.Uint64())
+			opts.GasPrice = item.list[1].integer()
+			fields = append(append([]rlpItem{item.list[0]}, item.list[2:6]...), item.list[6:]...)
+			sigHash = keccak256(rlpEncode(append(rlpValues(item.list[:6]), chainID, uint64(0), uint64(0))))
+
+		default:
+			return nil, fmt.Errorf("unsupported transaction type 0x%02x", raw[0])
+		}
+
+		// fields: nonce, gas, to, value, data, [access list], v, r, s
+		nonce, err := fields[0].uint64()
+		if err != nil {
+			return nil, err
+		}
+		gasLimit, err := fields[1].uint64()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields[2].data) != 20 {
+			return nil, fmt.Errorf("contract creation is not supported")
+		}
+		to := toChecksumAddress(hex.EncodeToString(fields[2].data))
+		amount := fields[3].integer()
+		data := fields[4].data
+
+		r, s := fields[len(fields)-2].integer(), fields[len(fields)-1].integer()
+		if s.Cmp(secp256k1HalfN) > 0 {
+			return nil, fmt.Errorf("invalid signature: high s value")
+		}
+		public, err := recoverPublicKey(sigHash, ecdsaSignature{r: r, s: s, recovery: recovery})
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Nonce = &nonce
+		opts.GasLimit = gasLimit
+		if len(data) == 68 && bytes.Equal(data[:4], erc20TransferSelector) {
+			opts.TokenContract = to
+			to = toChecksumAddress(hex.EncodeToString(data[16:36]))
+			amount = new(big.Int).SetBytes(data[36:])
+		} else {
+			opts.Memo = string(data)
+		}
+
+		return &decodedTransaction{
+			Hash:    "0x" + hex.EncodeToString(keccak256(raw)),
+			From:    publicKeyAddress(models.NetworkETH, public),
+			To:      to,
+			Amount:  amount,
+			Options: opts,
+		}, nil
+	}
+}
+
+// rlpItem is a decoded RLP string or list
+type rlpItem struct {
+	data   []byte
+	list   []rlpItem
+	isList bool
+}
+
+func (item rlpItem) integer() *big.Int {
+	return new(big.Int).SetBytes(item.data)
+}
+
+func (item rlpItem) uint64() (uint64, error) {
+	if item.isList || len(item.data) > 8 {
+		return 0, fmt.Errorf("invalid integer")
+	}
+	return item.integer().Uint64(), nil
+}
+
+// rlpValues turns decoded items back into values rlpEncode accepts
+func rlpValues(items []rlpItem) []interface{} {
+	values := make([]interface{}, len(items))
+	for i, item := range items {
+		if item.isList {
+			values[i] = rlpValues(item.list)
+		} else {
+			values[i] = item.data
+		}
+	}
+	return values
+}
+
+// rlpEncode encodes byte strings, unsigned integers (uint64, *big.Int) and
+// lists of them
+func rlpEncode(value interface{}) []byte {
+	switch v := value.(type) {
+	case []byte:
+		if len(v) == 1 && v[0] < 0x80 {
+			return []byte{v[0]}
+		}
+		return append(rlpHeader(0x80, len(v)), v...)
+	case uint64:
+		return rlpEncode(new(big.Int).SetUint64(v).Bytes())
+	case *big.Int:
+		return rlpEncode(v.Bytes())
+	case []interface{}:
+		var payload []byte
+		for _, element := range v {
+			payload = append(payload, rlpEncode(element)...)
+		}
+		return append(rlpHeader(0xc0, len(payload)), payload...)
+	default:
+		panic(fmt.Sprintf("rlp: unsupported type %T", value))
+	}
+}
+
+func rlpHeader(offset byte, length int) []byte {
+	if length < 56 {
+		return []byte{offset + byte(length)}
+	}
+	size := new(big.Int).SetInt64(int64(length)).Bytes()
+	return append([]byte{offset + 55 + byte(len(size))}, size...)
+}
+
+// rlpDecode decodes data holding exactly one item
+func rlpDecode(data []byte) (rlpItem, error) {
+	item, rest, err := rlpDecodeItem(data)
+	if err != nil {
+		return rlpItem{}, err
+	}
+	if len(rest) != 0 {
+		return rlpItem{}, fmt.Errorf("rlp: trailing data")
+	}
+	return item, nil
+}
+
+func rlpDecodeItem(data []byte) (rlpItem, []byte, error) {
+	if len(data) == 0 {
+		return rlpItem{}, nil, fmt.Errorf("rlp: unexpected end of data")
+	}
+	prefix := data[0]
+	if prefix < 0x80 {
+		return rlpItem{data: data[:1]}, data[1:], nil
+	}
+
+	isList := prefix >= 0xc0
+	offset := byte(0x80)
+	if isList {
+		offset = 0xc0
+	}
+	header, length := 1, int(prefix-offset)
+	if length > 55 {
+		sizeLength := length - 55
+		if sizeLength > 4 || len(data) < 1+sizeLength {
+			return rlpItem{}, nil, fmt.Errorf("rlp: invalid length")
+		}
+		length = int(new(big.Int).SetBytes(data[1 : 1+sizeLength]).Int64())
+		header += sizeLength
+	}
+	if len(data) < header+length {
+		return rlpItem{}, nil, fmt.Errorf("rlp: unexpected end of data")
+	}
+	payload, rest := data[header:header+length], data[header+length:]
+	if !isList {
+		return rlpItem{data: payload}, rest, nil
+	}
+
+	item := rlpItem{isList: true}
+	for len(payload) > 0 {
+		element, remaining, err := rlpDecodeItem(payload)
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		item.list = append(item.list, element)
+		payload = remaining
+	}
+	return item, rest, nil
+}
@@ -0,0 +1,216 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+)
+
+type FeeSpeed string
+
+const (
+	FeeSpeedSlow   FeeSpeed = "slow"
+	FeeSpeedNormal FeeSpeed = "normal"
+	FeeSpeedFast   FeeSpeed = "fast"
+)
+
+var feeSpeeds = []FeeSpeed{FeeSpeedSlow, FeeSpeedNormal, FeeSpeedFast}
+
+const (
+	gwei = 1_000_000_000
+
+	// TRON 原生转账消耗的带宽及每点带宽的价格（sun）
+	tronTransferBandwidth = 268
+	tronBandwidthPrice    = 1000
+
+	// TRC-20 转账调用合约，额外消耗能量，按燃烧 TRX 计价（sun）
+	tronTokenBandwidth = 345
+	tronTokenEnergy    = 65000
+	tronEnergyPrice    = 420
+)
+
+// evmPriorityFees 各档速度的小费（gwei）
+var evmPriorityFees = map[models.Network]map[FeeSpeed]int64{
+	models.NetworkETH: {FeeSpeedSlow: 1, FeeSpeedNormal: 2, FeeSpeedFast: 3},
+	models.NetworkBSC: {FeeSpeedSlow: 0, FeeSpeedNormal: 1, FeeSpeedFast: 2},
+}
+
+// btcConfirmationTargets 节点估算费率（estimatesmartfee）时各档速度的确认目标区块数
+var btcConfirmationTargets = map[FeeSpeed]int{FeeSpeedSlow: 24, FeeSpeedNormal: 6, FeeSpeedFast: 2}
+
+// btcFeeRates 各档速度相对模拟链 base fee（sat/vB）的倍数，链不提供费率估算时使用
+var btcFeeRates = map[FeeSpeed]int64{FeeSpeedSlow: 2, FeeSpeedNormal: 8, FeeSpeedFast: 20}
+
+// FeeQuote 某一档速度下的手续费报价
+type FeeQuote struct {
+	Speed    FeeSpeed `json:"speed"`
+	Fee      float64  `json:"fee"`       // 预计手续费，以原生币计
+	GasPrice *big.Int `json:"gas_price"` // 单价：wei/gas，sat/vB 或 sun/带宽
+	GasLimit uint64   `json:"gas_limit"` // 用量：gas 上限，虚拟字节数或带宽
+
+	// EIP-1559 (ETH/BSC)
+	BaseFee              *big.Int `json:"base_fee,omitempty"`
+	MaxPriorityFeePerGas *big.Int `json:"max_priority_fee_per_gas,omitempty"`
+	MaxFeePerGas         *big.Int `json:"max_fee_per_gas,omitempty"`
+
+	// TRON
+	Bandwidth uint64 `json:"bandwidth,omitempty"`
+	Energy    uint64 `json:"energy,omitempty"`
+
+	feeBaseUnits *big.Int
+}
+
+// FeeEstimator 根据链上拥堵情况给出各网络的手续费估算
+type FeeEstimator struct {
+	chains Chains
+}
+
+func NewFeeEstimator(chains Chains) *FeeEstimator {
+	return &FeeEstimator{chains: chains}
+}
+
+// Estimate 返回指定网络 slow/normal/fast 三档报价，token 为 true 时按代币转账估算
+func (e *FeeEstimator) Estimate(network models.Network, token bool) ([]*FeeQuote, error) {
+	quotes := make([]*FeeQuote, 0, len(feeSpeeds))
+	for _, speed := range feeSpeeds {
+		quote, err := e.Quote(network, speed, token)
+		if err != nil {
+			return nil, err
+		}
+		quotes = append(quotes, quote)
+	}
+	return quotes, nil
+}
+
+// Quote 返回指定网络和速度的报价，speed 为空时按 normal 计算。
+// 代币转账的手续费仍以原生币支付
+func (e *FeeEstimator) Quote(network models.Network, speed FeeSpeed, token bool) (*FeeQuote, error) {
+	if speed == "" {
+		speed = FeeSpeedNormal
+	}
+	if token && network == models.NetworkBTC {
+		return nil, fmt.Errorf("network %s does not support tokens", network)
+	}
+
+	chain, err := e.chains.Get(network)
+	if err != nil {
+		return nil, err
+	}
+
+	var quote *FeeQuote
+	switch network {
+	case models.NetworkETH, models.NetworkBSC:
+		tip, exists := evmPriorityFees[network][speed]
+		if !exists {
+			return nil, fmt.Errorf("unsupported fee speed: %s", speed)
+		}
+		baseFee := chain.BaseFee()
+		priorityFee := new(big.Int).Mul(big.NewInt(tip), big.NewInt(gwei))
+		// 预留两倍 base fee 以应对后续区块的上涨
+		maxFee := new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), priorityFee)
+		gasLimit := uint64(defaultGasLimit)
+		if token {
+			gasLimit = tokenTransferGasLimit
+		}
+		quote = &FeeQuote{
+			GasPrice:             new(big.Int).Add(baseFee, priorityFee),
+			GasLimit:             gasLimit,
+			BaseFee:              baseFee,
+			MaxPriorityFeePerGas: priorityFee,
+			MaxFeePerGas:         maxFee,
+		}
+
+	case models.NetworkBTC:
+		if _, exists := btcConfirmationTargets[speed]; !exists {
+			return nil, fmt.Errorf("unsupported fee speed: %s", speed)
+		}
+		rate, err := btcFeeRate(chain, speed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to estimate fee rate: %v", err)
+		}
+		// 按 1 输入 2 输出估算体积，实际提现时由 RefineTransfer 按选币结果修正
+		quote = &FeeQuote{
+			GasPrice: rate,
+			GasLimit: transactionVSize(1, 2),
+		}
+
+	case models.NetworkTRON:
+		if _, exists := btcFeeRates[speed]; !exists {
+			return nil, fmt.Errorf("unsupported fee speed: %s", speed)
+		}
+		// TRON 没有竞价机制，各档费用相同
+		quote = &FeeQuote{
+			GasPrice:  big.NewInt(tronBandwidthPrice),
+			GasLimit:  tronTransferBandwidth,
+			Bandwidth: tronTransferBandwidth,
+		}
+		if token {
+			quote.GasLimit = tronTokenBandwidth
+			quote.Bandwidth = tronTokenBandwidth
+			quote.Energy = tronTokenEnergy
+		}
+
+	default:
+		return nil, fmt.Errorf("unsupported network: %s", network)
+	}
+
+	quote.Speed = speed
+	quote.feeBaseUnits = new(big.Int).Mul(quote.GasPrice, new(big.Int).SetUint64(quote.GasLimit))
+	if quote.Energy > 0 {
+		energyFee := new(big.Int).SetUint64(quote.Energy * tronEnergyPrice)
+		quote.feeBaseUnits.Add(quote.feeBaseUnits, energyFee)
+	}
+	quote.Fee = fromBaseUnits(network, quote.feeBaseUnits)
+	return quote, nil
+}
+
+// btcFeeRate 节点能估算费率时按该档的确认目标估算，否则按倍数放大模拟链的 base fee
+func btcFeeRate(chain Blockchain, speed FeeSpeed) (*big.Int, error) {
+	if estimator, ok := chain.(FeeRateChain); ok {
+		return estimator.EstimateFeeRate(btcConfirmationTargets[speed])
+	}
+	return new(big.Int).Mul(big.NewInt(btcFeeRates[speed]), chain.BaseFee()), nil
+}
+
+// feeCap 返回签名使用的单价：EIP-1559 报价为单价上限，实际按 base fee 加小费计费
+func (q *FeeQuote) feeCap() *big.Int {
+	if q.MaxFeePerGas != nil {
+		return q.MaxFeePerGas
+	}
+	return q.GasPrice
+}
+
+// RefineTransfer 对 UTXO 链按实际选币结果重新计算手续费和交易体积
+func (e *FeeEstimator) RefineTransfer(network models.Network, quote *FeeQuote, from string, amount *big.Int) error {
+	chain, err := e.chains.Get(network)
+	if err != nil {
+		return err
+	}
+	utxoChain, ok := chain.(UTXOChain)
+	if !ok {
+		return nil
+	}
+
+	fee, vsize, err := utxoChain.EstimateTransferFee(from, amount, quote.GasPrice)
+	if err != nil {
+		return err
+	}
+	quote.GasLimit = vsize
+	quote.feeBaseUnits = fee
+	quote.Fee = fromBaseUnits(network, fee)
+	return nil
+}
+
+// QuoteWithin 返回费用不超过 maxFee 的最快一档报价
+func (e *FeeEstimator) QuoteWithin(network models.Network, maxFee float64, token bool) (*FeeQuote, error) {
+	quotes, err := e.Estimate(network, token)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(quotes) - 1; i >= 0; i-- {
+		if quotes[i].Fee <= maxFee {
+			return quotes[i], nil
+		}
+	}
+	return nil, fmt.Errorf("no fee option within max fee %v", maxFee)
+}
@@ -0,0 +1,158 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultNodeTimeout      = 10 * time.Second
+	defaultNodeRetryBackoff = 500 * time.Millisecond
+
+	// maxRPCResponseSize bounds the response read from a node
+	maxRPCResponseSize = 32 << 20
+)
+
+// jsonRPCClient sends JSON-RPC requests over HTTP. A request that cannot
+// reach a node, or gets no JSON-RPC answer from it, fails over to the next
+// endpoint; once every endpoint failed it is retried after a growing
+// backoff. Errors returned by a node are final and passed to the caller as
+// *rpcError. The endpoint that answered last is tried first next time.
+type jsonRPCClient struct {
+	endpoints []string
+	version   string // "2.0", or "1.0" for Bitcoin Core
+	http      *http.Client
+	retries   int
+	backoff   time.Duration
+
+	mutex   sync.Mutex
+	current int // Index of the endpoint tried first
+	nextID  atomic.Uint64
+}
+
+type rpcCall struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcReply struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+func newJSONRPCClient(policy config.NodePolicy, version string) (*jsonRPCClient, error) {
+	if len(policy.URLs) == 0 {
+		return nil, fmt.Errorf("no node URLs configured")
+	}
+	for _, endpoint := range policy.URLs {
+		if _, err := url.ParseRequestURI(endpoint); err != nil {
+			return nil, fmt.Errorf("invalid node URL: %v", err)
+		}
+	}
+
+	timeout := policy.Timeout
+	if timeout <= 0 {
+		timeout = defaultNodeTimeout
+	}
+	backoff := policy.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultNodeRetryBackoff
+	}
+	return &jsonRPCClient{
+		endpoints: policy.URLs,
+		version:   version,
+		http:      &http.Client{Timeout: timeout},
+		retries:   policy.MaxRetries,
+		backoff:   backoff,
+	}, nil
+}
+
+// call invokes method and decodes its result into result, which is left
+// untouched when the node answers null
+func (c *jsonRPCClient) call(result interface{}, method string, params ...interface{}) error {
+	if params == nil {
+		params = []interface{}{}
+	}
+	body, err := json.Marshal(rpcCall{JSONRPC: c.version, ID: c.nextID.Add(1), Method: method, Params: params})
+	if err != nil {
+		return err
+	}
+
+	var lastErr error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(c.backoff << (attempt - 1))
+		}
+
+		c.mutex.Lock()
+		first := c.current
+		c.mutex.Unlock()
+
+		for i := range c.endpoints {
+			index := (first + i) % len(c.endpoints)
+			reply, err := c.post(c.endpoints[index], body)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+
+			c.mutex.Lock()
+			c.current = index
+			c.mutex.Unlock()
+
+			if reply.Error != nil {
+				return reply.Error
+			}
+			if result == nil || len(reply.Result) == 0 || string(reply.Result) == "null" {
+				return nil
+			}
+			if err := json.Unmarshal(reply.Result, result); err != nil {
+				return fmt.Errorf("invalid %s result: %v", method, err)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s failed on all nodes: %v", method, lastErr)
+}
+
+// post sends one request. Nodes such as Bitcoin Core answer RPC errors with
+// an HTTP error status, so any response carrying a JSON-RPC body counts as
+// an answer.
+func (c *jsonRPCClient) post(endpoint string, body []byte) (*rpcReply, error) {
+	name := endpoint
+	if parsed, err := url.Parse(endpoint); err == nil {
+		name = parsed.Redacted()
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRPCResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	var reply rpcReply
+	if err := json.Unmarshal(data, &reply); err != nil || (reply.Error == nil && reply.Result == nil) {
+		return nil, fmt.Errorf("%s: HTTP %d", name, resp.StatusCode)
+	}
+	return &reply, nil
+}
@@ -0,0 +1,139 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"golang.org/x/crypto/argon2"
+	"gorm.io/gorm"
+	"log"
+	"strings"
+	"sync"
+)
+
+// encryptedKeyPrefix 标记以 AES-GCM 加密保存的私钥，密钥由口令经 Argon2id 派生，
+// 格式为 argon2id:<盐>:<nonce 与密文>
+const encryptedKeyPrefix = "argon2id:"
+
+// Argon2id 参数，取 RFC 9106 推荐的低内存配置
+const (
+	keyDerivationTime    = 3
+	keyDerivationMemory  = 64 * 1024 // KiB
+	keyDerivationThreads = 4
+	keySaltSize          = 16
+)
+
+// KeyStore 托管平台地址（用户充值地址、热/冷钱包）的签名私钥，
+// 每个地址生成时同时生成并保存其私钥，签名时按地址取出
+type KeyStore struct {
+	db     *gorm.DB
+	btc    *BitcoinParams // BTC 地址所在的比特币网络
+	secret string         // 为空时私钥以明文保存
+	salt   []byte         // 本进程新保存的私钥使用的盐
+
+	mutex sync.Mutex
+	aeads map[string]cipher.AEAD // 按盐缓存派生的密钥，避免每次签名重新派生
+}
+
+// NewKeyStore 未配置加密口令时拒绝创建，除非显式允许明文保存（仅用于开发和模拟链环境）
+func NewKeyStore(db *gorm.DB, cfg *config.CryptoConfig, btc *BitcoinParams) (*KeyStore, error) {
+	if cfg.KeyEncryptionSecret == "" && !cfg.AllowPlaintextKeys {
+		return nil, fmt.Errorf("key encryption secret is required to store signing keys")
+	}
+	store := &KeyStore{db: db, btc: btc, secret: cfg.KeyEncryptionSecret, aeads: make(map[string]cipher.AEAD)}
+	if store.secret == "" {
+		log.Printf("key store: no encryption secret configured, signing keys are stored in plaintext")
+		return store, nil
+	}
+	store.salt = make([]byte, keySaltSize)
+	if _, err := rand.Read(store.salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	return store, nil
+}
+
+// aead 返回由口令和盐派生的 AES-256-GCM 实例
+func (s *KeyStore) aead(salt []byte) cipher.AEAD {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if aead, exists := s.aeads[string(salt)]; exists {
+		return aead
+	}
+	key := argon2.IDKey([]byte(s.secret), salt, keyDerivationTime, keyDerivationMemory, keyDerivationThreads, 32)
+	// 32 字节的 AES-256 密钥不会出错
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+	s.aeads[string(salt)] = aead
+	return aead
+}
+
+// NewAddress 生成新私钥并在调用方事务内保存，返回其在 network 上的地址
+func (s *KeyStore) NewAddress(tx *gorm.DB, network models.Network) (string, error) {
+	key, err := GeneratePrivateKey()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate key: %v", err)
+	}
+	sealed, err := s.seal(key.Bytes())
+	if err != nil {
+		return "", err
+	}
+
+	address := key.Address(network, s.btc)
+	if err := tx.Create(&models.SigningKey{Network: network, Address: address, PrivateKey: sealed}).Error; err != nil {
+		return "", fmt.Errorf("failed to save signing key: %v", err)
+	}
+	return address, nil
+}
+
+// Key 返回地址的签名私钥
+func (s *KeyStore) Key(network models.Network, address string) (*PrivateKey, error) {
+	var stored models.SigningKey
+	if err := s.db.Where("network = ? AND address = ?", network, address).First(&stored).Error; err != nil {
+		return nil, fmt.Errorf("no signing key for %s address %s: %v", network, address, err)
+	}
+	data, err := s.open(stored.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key for %s: %v", address, err)
+	}
+	return ParsePrivateKey(data)
+}
+
+// seal 加密私钥，未配置口令时返回十六进制明文
+func (s *KeyStore) seal(key []byte) (string, error) {
+	if s.secret == "" {
+		return hex.EncodeToString(key), nil
+	}
+	aead := s.aead(s.salt)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return encryptedKeyPrefix + hex.EncodeToString(s.salt) + ":" + hex.EncodeToString(aead.Seal(nonce, nonce, key, nil)), nil
+}
+
+// open 解密 seal 保存的私钥，配置口令前保存的明文私钥仍可读取
+func (s *KeyStore) open(stored string) ([]byte, error) {
+	if !strings.HasPrefix(stored, encryptedKeyPrefix) {
+		return hex.DecodeString(stored)
+	}
+	if s.secret == "" {
+		return nil, fmt.Errorf("key is encrypted but no encryption secret is configured")
+	}
+	encodedSalt, encoded, found := strings.Cut(strings.TrimPrefix(stored, encryptedKeyPrefix), ":")
+	salt, saltErr := hex.DecodeString(encodedSalt)
+	data, err := hex.DecodeString(encoded)
+	if !found || saltErr != nil || err != nil {
+		return nil, fmt.Errorf("invalid encrypted key")
+	}
+	aead := s.aead(salt)
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("invalid encrypted key")
+	}
+	nonce, sealed := data[:aead.NonceSize()], data[aead.NonceSize():]
+	return aead.Open(nil, nonce, sealed, nil)
+}
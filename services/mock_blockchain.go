@@ -1,147 +1,897 @@
 package services
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
 	"math/big"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 )
 
-// BlockchainTransaction represents a transaction on the blockchain
-type BlockchainTransaction struct {
-	Hash          string
-	From          string
-	To            string
-	Amount        *big.Int
-	BlockNumber   uint64
-	Confirmations int
-	Timestamp     time.Time
-	Status        string // success, pending, failed
-	Fee           *big.Int
-	Raw           []byte // Raw transaction data
-}
-
-// MockBlockchain simulates a blockchain network
+const (
+	// defaultGasLimit is the gas used by a plain value transfer
+	defaultGasLimit = 21000
+
+	// tokenTransferGasLimit is the gas used by a token transfer call
+	tokenTransferGasLimit = 65000
+)
+
+// replacementFeeBump is the minimum fee increase, in percent, for a
+// transaction replacing a pooled one with the same nonce
+const replacementFeeBump = 10
+
+// maxBlockTransactions is the number of mempool transactions a block holds,
+// the rest wait for the next block
+const maxBlockTransactions = 100
+
+// errNotExecutable means the transaction is valid but has to wait in the
+// pool, either for a nonce gap to be filled or for its fee to reach the
+// base fee
+var errNotExecutable = errors.New("transaction not executable yet")
+
+// ledger holds the value state of a mock chain. apply validates a new
+// transaction against the state, fills in its fee and applies it; revert undoes it during a reorg. apply
+// returns errNotExecutable without touching the state for transactions
+// that must wait in the pool.
+type ledger interface {
+	apply(tx *BlockchainTransaction, opts *TxOptions, baseFee *big.Int) error
+	revert(tx *BlockchainTransaction)
+	fail(tx *BlockchainTransaction) error
+	credit(tx *BlockchainTransaction, contract string) error
+	uncredit(tx *BlockchainTransaction)
+	balance(address string) *big.Int
+	nextNonce(address string) uint64
+	exportState() (json.RawMessage, error)
+	importState(data json.RawMessage) error
+}
+
+// MockBlockchain simulates a blockchain network. Accepted transactions are
+// executed against the ledger right away and wait in the mempool until a
+// block includes them; blocks are only produced by MineBlocks, so the chain
+// advances exactly as fast as its caller wants.
 type MockBlockchain struct {
 	mutex        sync.RWMutex
 	transactions map[string]*BlockchainTransaction
-	balances     map[string]*big.Int
-	currentBlock uint64
+	ledger       ledger
+	pool         map[string]*pooledTx // Waiting transactions keyed by sender and nonce
+	mempool      []string             // Executed transactions waiting for a block, in arrival order
+	blocks       []*MockBlock         // Canonical chain indexed by height, starting at genesis
+	baseFee      *big.Int
+	decode       txDecoder // Parses raw transactions and verifies their signatures
+	chainID      *big.Int  // EIP-155 chain ID, EVM chains only
+	clock        config.Clock
+
+	injected map[string]bool // Transfers credited from outside the chain, see InjectTransfer
+	held     map[string]bool // Mempool transactions kept out of blocks, see HoldTransaction
+	faults   MockFaults
+
+	persist func(state []byte) error // Called with the new state after every change
+}
+
+// MockBlock is a block of a mock chain
+type MockBlock struct {
+	Number       uint64    `json:"number"`
+	Hash         string    `json:"hash"`
+	ParentHash   string    `json:"parent_hash"`
+	Timestamp    time.Time `json:"timestamp"`
+	Transactions []string  `json:"transactions"`
+
+	deltas balanceDeltas // Balance changes of the block's transactions
+}
+
+// balanceDeltas are the balance changes made by the transactions of one
+// block, keyed by lower-cased token contract ("" for the native coin) and
+// address
+type balanceDeltas map[string]map[string]*big.Int
+
+func (d balanceDeltas) add(contract, address string, amount *big.Int) {
+	key := strings.ToLower(contract)
+	if d[key] == nil {
+		d[key] = make(map[string]*big.Int)
+	}
+	if d[key][address] == nil {
+		d[key][address] = big.NewInt(0)
+	}
+	d[key][address].Add(d[key][address], amount)
 }
 
-func NewMockBlockchain() *MockBlockchain {
+// addTransaction records the changes a mined transaction made to the
+// ledger: UTXO transactions spend their inputs and pay their outputs; on
+// account chains the sender pays the fee even when the transaction failed.
+// Injected transfers only credit their receiver.
+func (d balanceDeltas) addTransaction(tx *BlockchainTransaction, injected bool) {
+	if len(tx.Inputs) > 0 || len(tx.Outputs) > 0 {
+		for _, output := range tx.Outputs {
+			d.add("", output.Address, output.Amount)
+		}
+		for _, input := range tx.Inputs {
+			d.add("", input.Address, new(big.Int).Neg(input.Amount))
+		}
+		return
+	}
+
+	if tx.Status == "success" {
+		d.add("", tx.To, tx.Amount)
+		if !injected {
+			d.add("", tx.From, new(big.Int).Neg(tx.Amount))
+		}
+		for _, transfer := range tx.TokenTransfers {
+			d.add(transfer.Contract, transfer.To, transfer.Amount)
+			if !injected {
+				d.add(transfer.Contract, transfer.From, new(big.Int).Neg(transfer.Amount))
+			}
+		}
+	}
+	if !injected && tx.Fee != nil {
+		d.add("", tx.From, new(big.Int).Neg(tx.Fee))
+	}
+}
+
+type pooledTx struct {
+	tx   *BlockchainTransaction
+	opts *TxOptions
+}
+
+// newMockEVMBlockchain creates an account based chain accepting legacy and
+// EIP-1559 transactions signed for chainID
+func newMockEVMBlockchain(chainID uint64, clock config.Clock) *MockBlockchain {
+	id := new(big.Int).SetUint64(chainID)
+	b := newMockBlockchain(newAccountLedger(), big.NewInt(10_000_000_000), ethDecoder(id), clock) // 10 gwei
+	b.chainID = id
+	return b
+}
+
+// newMockBlockchain creates a chain whose block and transaction timestamps
+// and injected latency follow clock
+func newMockBlockchain(l ledger, baseFee *big.Int, decode txDecoder, clock config.Clock) *MockBlockchain {
 	return &MockBlockchain{
 		transactions: make(map[string]*BlockchainTransaction),
-		balances:     make(map[string]*big.Int),
-		currentBlock: 0,
+		ledger:       l,
+		pool:         make(map[string]*pooledTx),
+		blocks:       []*MockBlock{genesisBlock(clock.Now())},
+		baseFee:      baseFee,
+		decode:       decode,
+		clock:        clock,
+		injected:     make(map[string]bool),
+		held:         make(map[string]bool),
+	}
+}
+
+func genesisBlock(timestamp time.Time) *MockBlock {
+	return &MockBlock{
+		Hash:       generateTxHash(),
+		ParentHash: "0x" + strings.Repeat("0", 64),
+		Timestamp:  timestamp,
 	}
 }
 
 // GetTransaction retrieves a specific transaction by hash
 func (b *MockBlockchain) GetTransaction(txHash string) (*BlockchainTransaction, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 
 	tx, exists := b.transactions[txHash]
 	if !exists {
-		return nil, fmt.Errorf("transaction not found")
+		return nil, ErrTransactionNotFound
 	}
-	return tx, nil
+	return b.view(tx), nil
 }
 
-// SendTransaction simulates sending a transaction to the blockchain
-func (b *MockBlockchain) SendTransaction(from, to string, amount *big.Int) (string, error) {
+// view returns a copy of tx with its confirmations counted from the chain
+// tip. Only queued transactions can be replaced, pending ones have already
+// been applied to the ledger.
+func (b *MockBlockchain) view(tx *BlockchainTransaction) *BlockchainTransaction {
+	view := *tx
+	view.Replaceable = tx.Status == "queued"
+	view.Confirmations = 0
+	if tx.BlockHash != "" {
+		view.Confirmations = int(b.tip().Number-tx.BlockNumber) + 1
+	}
+	return &view
+}
+
+func (b *MockBlockchain) tip() *MockBlock {
+	return b.blocks[len(b.blocks)-1]
+}
+
+// SendRawTransaction verifies the signatures of a raw transaction against
+// its sender and broadcasts it. On account chains a transaction with a
+// future nonce or a fee below the base fee waits in the pool as "queued"
+// and can be replaced by one with the same nonce and a higher fee.
+// Resending a transaction the chain already holds returns its hash with
+// ErrTransactionKnown.
+func (b *MockBlockchain) SendRawTransaction(raw string) (string, error) {
+	if err := b.fault(); err != nil {
+		return "", err
+	}
+
+	data, err := hex.DecodeString(strings.TrimPrefix(raw, "0x"))
+	if err != nil {
+		return "", fmt.Errorf("invalid raw transaction: %v", err)
+	}
+
 	b.mutex.Lock()
 	defer b.mutex.Unlock()
 
-	// Check sender balance
-	balance, exists := b.balances[from]
-	if !exists {
-		balance = big.NewInt(0)
+	decoded, err := b.decode(data, b.prevout)
+	if err != nil {
+		// A resent UTXO transaction no longer finds the outputs it spent
+		if known := b.findRaw(data); known != nil {
+			return known.Hash, ErrTransactionKnown
+		}
+		return "", fmt.Errorf("invalid raw transaction: %v", err)
 	}
 
-	if balance.Cmp(amount) < 0 {
-		return "", fmt.Errorf("insufficient balance")
+	txHash := decoded.Hash
+	if _, exists := b.transactions[txHash]; exists {
+		return txHash, ErrTransactionKnown
 	}
-
-	// Generate transaction hash
-	txHash := generateTxHash()
+	from, opts := decoded.From, decoded.Options
 
 	// Create transaction record
 	tx := &BlockchainTransaction{
 		Hash:          txHash,
 		From:          from,
-		To:            to,
-		Amount:        amount,
-		BlockNumber:   b.currentBlock + 1,
+		To:            decoded.To,
+		Amount:        decoded.Amount,
+		Memo:          opts.memo(),
 		Confirmations: 0,
-		Timestamp:     time.Now(),
+		Timestamp:     b.clock.Now(),
 		Status:        "pending",
-		Fee:           big.NewInt(21000), // Mock gas fee
-		Raw:           []byte(fmt.Sprintf("mock_tx_data_%s", txHash)),
+		GasUsed:       decoded.VSize,
+		Inputs:        decoded.Inputs,
+		Outputs:       decoded.Outputs,
+		Raw:           data,
 	}
 
-	// Update balances
-	b.balances[from] = new(big.Int).Sub(balance, amount)
-	toBalance, exists := b.balances[to]
-	if !exists {
-		toBalance = big.NewInt(0)
+	// Check sender funds and update balances
+	err = b.ledger.apply(tx, opts, b.baseFee)
+	if errors.Is(err, errNotExecutable) {
+		if err := b.enqueue(tx, opts); err != nil {
+			return "", err
+		}
+		b.save()
+		return txHash, nil
+	}
+	if err != nil {
+		return "", err
 	}
-	b.balances[to] = new(big.Int).Add(toBalance, amount)
-
-	// Store transaction
-	b.transactions[txHash] = tx
-	b.currentBlock++
 
-	// Start confirmation simulation
-	go b.simulateConfirmations(txHash)
+	b.admit(tx)
+	b.promote(from)
+	b.save()
 
 	return txHash, nil
 }
 
-func (b *MockBlockchain) simulateConfirmations(txHash string) {
-	for i := 1; i <= 12; i++ {
-		time.Sleep(5 * time.Second) // Simulate block time
+// prevout returns the unspent output a UTXO transaction input refers to
+func (b *MockBlockchain) prevout(txHash string, index uint32) *UTXO {
+	utxos, ok := b.ledger.(*utxoLedger)
+	if !ok {
+		return nil
+	}
+	return utxos.utxos[outpoint(txHash, index)]
+}
+
+func (b *MockBlockchain) findRaw(data []byte) *BlockchainTransaction {
+	for _, tx := range b.transactions {
+		if bytes.Equal(tx.Raw, data) {
+			return tx
+		}
+	}
+	return nil
+}
+
+// admit adds an executed transaction to the mempool, where it stays
+// "pending" until a block includes it
+func (b *MockBlockchain) admit(tx *BlockchainTransaction) {
+	tx.Status = "pending"
+
+	// A pooled transaction with the same nonce has been replaced
+	key := poolKey(tx.From, tx.Nonce)
+	if pooled, exists := b.pool[key]; exists && pooled.tx.Hash != tx.Hash {
+		pooled.tx.Status = "replaced"
+	}
+	delete(b.pool, key)
+
+	b.transactions[tx.Hash] = tx
+	b.mempool = append(b.mempool, tx.Hash)
+}
+
+// MineBlocks produces n blocks on top of the tip, each including up to
+// maxBlockTransactions mempool transactions in arrival order, and returns
+// them
+func (b *MockBlockchain) MineBlocks(n int) []MockBlock {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var mined []MockBlock
+	for i := 0; i < n; i++ {
+		mined = append(mined, *b.mineBlock())
+	}
+	b.save()
+	return mined
+}
+
+func (b *MockBlockchain) mineBlock() *MockBlock {
+	parent := b.tip()
+	block := &MockBlock{
+		Number:     parent.Number + 1,
+		Hash:       generateTxHash(),
+		ParentHash: parent.Hash,
+		Timestamp:  b.clock.Now(),
+	}
+
+	var waiting []string
+	for _, hash := range b.mempool {
+		tx, exists := b.transactions[hash]
+		if !exists {
+			continue
+		}
+		if b.held[hash] || len(block.Transactions) >= maxBlockTransactions {
+			waiting = append(waiting, hash)
+			continue
+		}
+		tx.BlockNumber = block.Number
+		tx.BlockHash = block.Hash
+		tx.Timestamp = block.Timestamp
+		if tx.Status != "failed" {
+			tx.Status = "success" // Executed once mined; finality is decided by the caller's policy
+		}
+		if utxos, ok := b.ledger.(*utxoLedger); ok {
+			utxos.confirm(tx)
+		}
+		block.Transactions = append(block.Transactions, hash)
+	}
+	b.mempool = waiting
+	block.deltas = b.blockDeltas(block)
+
+	b.blocks = append(b.blocks, block)
+	return block
+}
+
+func (b *MockBlockchain) enqueue(tx *BlockchainTransaction, opts *TxOptions) error {
+	key := poolKey(tx.From, tx.Nonce)
+	if pooled, exists := b.pool[key]; exists {
+		if !replacementPriced(pooled, tx, opts) {
+			return fmt.Errorf("replacement transaction underpriced")
+		}
+		pooled.tx.Status = "replaced"
+	}
+
+	tx.Status = "queued"
+	b.pool[key] = &pooledTx{tx: tx, opts: pinNonce(opts, tx.Nonce)}
+	b.transactions[tx.Hash] = tx
+	return nil
+}
+
+// replacementPriced reports whether tx pays enough to replace the pooled
+// one. As in geth, an EIP-1559 replacement must raise both the fee cap and
+// the tip by replacementFeeBump percent, other transactions the fee
+func replacementPriced(pooled *pooledTx, tx *BlockchainTransaction, opts *TxOptions) bool {
+	if dynamicFee(pooled.opts) && dynamicFee(opts) {
+		return feeBumped(pooled.opts.GasPrice, opts.GasPrice) &&
+			feeBumped(pooled.opts.MaxPriorityFee, opts.MaxPriorityFee)
+	}
+	return feeBumped(pooled.tx.Fee, tx.Fee)
+}
+
+func dynamicFee(opts *TxOptions) bool {
+	return opts != nil && opts.GasPrice != nil && opts.MaxPriorityFee != nil
+}
+
+// feeBumped reports whether next exceeds prev by at least replacementFeeBump percent
+func feeBumped(prev, next *big.Int) bool {
+	minimum := new(big.Int).Mul(prev, big.NewInt(100+replacementFeeBump))
+	minimum.Div(minimum, big.NewInt(100))
+	return next.Cmp(minimum) >= 0
+}
+
+// promote mines pooled transactions of the sender that became executable
+func (b *MockBlockchain) promote(from string) {
+	for {
+		pooled, exists := b.pool[poolKey(from, b.ledger.nextNonce(from))]
+		if !exists {
+			return
+		}
+		if err := b.ledger.apply(pooled.tx, pooled.opts, b.baseFee); err != nil {
+			return
+		}
+		b.admit(pooled.tx)
+	}
+}
+
+func poolKey(from string, nonce uint64) string {
+	return fmt.Sprintf("%s/%d", from, nonce)
+}
+
+func pinNonce(opts *TxOptions, nonce uint64) *TxOptions {
+	pinned := TxOptions{}
+	if opts != nil {
+		pinned = *opts
+	}
+	pinned.Nonce = &nonce
+	return &pinned
+}
+
+// GetNonce returns the next nonce the chain will execute for address
+func (b *MockBlockchain) GetNonce(address string) (uint64, error) {
+	if err := b.fault(); err != nil {
+		return 0, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.ledger.nextNonce(address), nil
+}
+
+// ChainID returns the EIP-155 chain ID transactions must be signed for
+func (b *MockBlockchain) ChainID() (*big.Int, error) {
+	if b.chainID == nil {
+		return nil, fmt.Errorf("chain has no chain ID")
+	}
+	return new(big.Int).Set(b.chainID), nil
+}
+
+// GetBlockHash returns the hash of the canonical block at the given height
+func (b *MockBlockchain) GetBlockHash(number uint64) (string, error) {
+	if err := b.fault(); err != nil {
+		return "", err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if number >= uint64(len(b.blocks)) {
+		return "", fmt.Errorf("block %d not found", number)
+	}
+	return b.blocks[number].Hash, nil
+}
+
+// GetBlockTime returns the timestamp of the canonical block at the given
+// height
+func (b *MockBlockchain) GetBlockTime(number uint64) (time.Time, error) {
+	if err := b.fault(); err != nil {
+		return time.Time{}, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if number >= uint64(len(b.blocks)) {
+		return time.Time{}, fmt.Errorf("block %d not found", number)
+	}
+	return b.blocks[number].Timestamp, nil
+}
+
+// BaseFee returns the current base fee (wei per gas, or sat/vB on UTXO chains)
+func (b *MockBlockchain) BaseFee() *big.Int {
+	b.delay()
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return new(big.Int).Set(b.baseFee)
+}
+
+// SetBaseFee changes the simulated network congestion
+func (b *MockBlockchain) SetBaseFee(baseFee *big.Int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.baseFee = new(big.Int).Set(baseFee)
+
+	// Pooled transactions may have become executable
+	for _, pooled := range b.pool {
+		b.promote(pooled.tx.From)
+	}
+	b.save()
+}
+
+// CurrentBlock returns the height of the chain tip
+func (b *MockBlockchain) CurrentBlock() uint64 {
+	b.delay()
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.tip().Number
+}
+
+// SimulateReorg replaces the last depth blocks with new ones. Transactions in
+// the orphaned blocks are either dropped (their balance changes reverted) or,
+// when dropTxs is false, re-included at the same heights of the replacement
+// blocks under the new block hashes. It returns the affected tx hashes.
+func (b *MockBlockchain) SimulateReorg(depth int, dropTxs bool) ([]string, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 
-		b.mutex.Lock()
-		if tx, exists := b.transactions[txHash]; exists {
-			tx.Confirmations = i
-			if i >= 6 { // After 6 confirmations
-				tx.Status = "success"
+	tip := b.tip().Number
+	if depth <= 0 || uint64(depth) > tip {
+		return nil, fmt.Errorf("invalid reorg depth: %d", depth)
+	}
+	forkPoint := tip - uint64(depth)
+
+	var orphaned []*BlockchainTransaction
+	for number := forkPoint + 1; number <= tip; number++ {
+		block := b.blocks[number]
+		block.Hash = generateTxHash()
+		block.ParentHash = b.blocks[number-1].Hash
+		for _, hash := range block.Transactions {
+			if tx, exists := b.transactions[hash]; exists {
+				orphaned = append(orphaned, tx)
 			}
 		}
-		b.mutex.Unlock()
+		if dropTxs {
+			block.Transactions = nil
+			block.deltas = nil
+		}
+	}
+	// Undo the newest transactions first so spends are reverted before the
+	// outputs they consumed are restored
+	sort.SliceStable(orphaned, func(i, j int) bool { return orphaned[i].BlockNumber > orphaned[j].BlockNumber })
+
+	affected := make([]string, 0, len(orphaned))
+	for i := len(orphaned) - 1; i >= 0; i-- {
+		affected = append(affected, orphaned[i].Hash)
 	}
+	for _, tx := range orphaned {
+		if dropTxs {
+			if b.injected[tx.Hash] {
+				b.ledger.uncredit(tx)
+			} else {
+				b.ledger.revert(tx)
+			}
+			delete(b.transactions, tx.Hash)
+			delete(b.injected, tx.Hash)
+			continue
+		}
+		tx.BlockHash = b.blocks[tx.BlockNumber].Hash
+	}
+	b.save()
+
+	return affected, nil
 }
 
 // GetTransactionHistory returns all transactions for an address within a time range
 func (b *MockBlockchain) GetTransactionHistory(address string, startTime, endTime time.Time) ([]*BlockchainTransaction, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 
 	var transactions []*BlockchainTransaction
 	for _, tx := range b.transactions {
-		if (tx.From == address || tx.To == address) &&
+		if involvesAddress(tx, address) &&
 			tx.Timestamp.After(startTime) &&
 			tx.Timestamp.Before(endTime) {
-			transactions = append(transactions, tx)
+			transactions = append(transactions, b.view(tx))
 		}
 	}
 	return transactions, nil
 }
 
+func involvesAddress(tx *BlockchainTransaction, address string) bool {
+	if tx.From == address || tx.To == address {
+		return true
+	}
+	for _, transfer := range tx.TokenTransfers {
+		if transfer.From == address || transfer.To == address {
+			return true
+		}
+	}
+	return false
+}
+
 // GetAddressBalance returns the current balance of an address from blockchain
 func (b *MockBlockchain) GetAddressBalance(address string) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.ledger.balance(address), nil
+}
+
+// blockDeltas computes the balance changes of a block from its transactions
+func (b *MockBlockchain) blockDeltas(block *MockBlock) balanceDeltas {
+	deltas := make(balanceDeltas)
+	for _, hash := range block.Transactions {
+		if tx, exists := b.transactions[hash]; exists {
+			deltas.addTransaction(tx, b.injected[hash])
+		}
+	}
+	return deltas
+}
+
+// balanceAt sums the deltas of the blocks up to number. The caller must
+// hold the lock and check that the block exists.
+func (b *MockBlockchain) balanceAt(contract, address string, number uint64) *big.Int {
+	total := big.NewInt(0)
+	for _, block := range b.blocks[:number+1] {
+		if delta := block.deltas[strings.ToLower(contract)][address]; delta != nil {
+			total.Add(total, delta)
+		}
+	}
+	return total
+}
+
+// blockAt returns the last block mined at or before t, the genesis block
+// when t precedes every block. Block timestamps never decrease.
+func (b *MockBlockchain) blockAt(t time.Time) uint64 {
+	next := sort.Search(len(b.blocks), func(i int) bool { return b.blocks[i].Timestamp.After(t) })
+	if next == 0 {
+		return 0
+	}
+	return uint64(next - 1)
+}
+
+func (b *MockBlockchain) checkBlock(number uint64) error {
+	if number > b.tip().Number {
+		return fmt.Errorf("block %d not found", number)
+	}
+	return nil
+}
+
+// GetAddressBalanceAt returns the balance of an address after block number.
+// Unlike GetAddressBalance it excludes mempool transactions.
+func (b *MockBlockchain) GetAddressBalanceAt(address string, number uint64) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if err := b.checkBlock(number); err != nil {
+		return nil, err
+	}
+	return b.balanceAt("", address, number), nil
+}
+
+// GetAddressBalanceAtTime returns the balance of an address after the last
+// block mined at or before t
+func (b *MockBlockchain) GetAddressBalanceAtTime(address string, t time.Time) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.balanceAt("", address, b.blockAt(t)), nil
+}
+
+// GetTokenBalanceAt returns the token balance of an address after block
+// number
+func (b *MockBlockchain) GetTokenBalanceAt(contract, address string, number uint64) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if _, ok := b.ledger.(*accountLedger); !ok {
+		return nil, fmt.Errorf("tokens are not supported on this chain")
+	}
+	if err := b.checkBlock(number); err != nil {
+		return nil, err
+	}
+	return b.balanceAt(contract, address, number), nil
+}
+
+// GetTokenBalanceAtTime returns the token balance of an address after the
+// last block mined at or before t
+func (b *MockBlockchain) GetTokenBalanceAtTime(contract, address string, t time.Time) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if _, ok := b.ledger.(*accountLedger); !ok {
+		return nil, fmt.Errorf("tokens are not supported on this chain")
+	}
+	return b.balanceAt(contract, address, b.blockAt(t)), nil
+}
+
+// GetTokenBalance returns the token balance of an address
+func (b *MockBlockchain) GetTokenBalance(contract, address string) (*big.Int, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
 	b.mutex.RLock()
 	defer b.mutex.RUnlock()
 
-	balance, exists := b.balances[address]
+	accounts, ok := b.ledger.(*accountLedger)
+	if !ok {
+		return nil, fmt.Errorf("tokens are not supported on this chain")
+	}
+	return accounts.tokenBalance(contract, address), nil
+}
+
+// accountLedger keeps one balance and nonce per address, plus token
+// balances per contract
+type accountLedger struct {
+	balances map[string]*big.Int
+	nonces   map[string]uint64
+	tokens   map[string]map[string]*big.Int
+}
+
+func newAccountLedger() *accountLedger {
+	return &accountLedger{
+		balances: make(map[string]*big.Int),
+		nonces:   make(map[string]uint64),
+		tokens:   make(map[string]map[string]*big.Int),
+	}
+}
+
+func (l *accountLedger) apply(tx *BlockchainTransaction, opts *TxOptions, baseFee *big.Int) error {
+	// A token transfer is a call to the contract that emits a Transfer event
+	if opts != nil && opts.TokenContract != "" && len(tx.TokenTransfers) == 0 {
+		tx.TokenTransfers = []TokenTransfer{{
+			Contract: opts.TokenContract,
+			From:     tx.From,
+			To:       tx.To,
+			Amount:   tx.Amount,
+		}}
+		tx.To = opts.TokenContract
+		tx.Amount = big.NewInt(0)
+	}
+
+	gasLimit := uint64(defaultGasLimit)
+	if len(tx.TokenTransfers) > 0 {
+		gasLimit = tokenTransferGasLimit
+	}
+	gasPrice := new(big.Int).Set(baseFee)
+	if opts != nil && opts.GasLimit > 0 {
+		gasLimit = opts.GasLimit
+	}
+	if opts != nil && opts.GasPrice != nil {
+		gasPrice = opts.GasPrice
+	}
+	// EIP-1559: GasPrice is the fee cap, the sender pays the base fee plus at
+	// most the tip
+	if opts != nil && opts.GasPrice != nil && opts.MaxPriorityFee != nil {
+		if price := new(big.Int).Add(baseFee, opts.MaxPriorityFee); price.Cmp(gasPrice) < 0 {
+			gasPrice = price
+		}
+	}
+	fee := new(big.Int).Mul(gasPrice, new(big.Int).SetUint64(gasLimit))
+	if opts != nil && opts.Fee != nil {
+		fee = opts.Fee
+	}
+
+	tx.Fee = fee
+	tx.GasPrice = gasPrice
+	tx.GasUsed = gasLimit
+
+	// Transactions must use the sender's nonces strictly in order
+	nonce := l.nextNonce(tx.From)
+	tx.Nonce = nonce
+	if opts != nil && opts.Nonce != nil {
+		tx.Nonce = *opts.Nonce
+		if tx.Nonce < nonce {
+			return fmt.Errorf("nonce too low: next nonce is %d", nonce)
+		}
+	}
+	if tx.Nonce > nonce || gasPrice.Cmp(baseFee) < 0 {
+		return errNotExecutable
+	}
+
+	// Check sender balance
+	balance := l.balance(tx.From)
+	total := new(big.Int).Add(tx.Amount, fee)
+	if balance.Cmp(total) < 0 {
+		return fmt.Errorf("insufficient balance")
+	}
+	for _, transfer := range tx.TokenTransfers {
+		if l.tokenBalance(transfer.Contract, transfer.From).Cmp(transfer.Amount) < 0 {
+			return fmt.Errorf("insufficient token balance")
+		}
+	}
+
+	l.balances[tx.From] = new(big.Int).Sub(balance, total)
+	l.balances[tx.To] = new(big.Int).Add(l.balance(tx.To), tx.Amount)
+	l.nonces[tx.From] = nonce + 1
+	for _, transfer := range tx.TokenTransfers {
+		l.addToken(transfer.Contract, transfer.From, new(big.Int).Neg(transfer.Amount))
+		l.addToken(transfer.Contract, transfer.To, transfer.Amount)
+	}
+	return nil
+}
+
+func (l *accountLedger) revert(tx *BlockchainTransaction) {
+	// A failed transaction only spent its fee
+	if tx.Status != "failed" {
+		l.fail(tx)
+	}
+	if tx.Fee != nil {
+		l.balances[tx.From] = new(big.Int).Add(l.balance(tx.From), tx.Fee)
+	}
+	l.nonces[tx.From] = tx.Nonce
+}
+
+// fail undoes the transfers of an executed transaction, the sender keeps
+// paying the fee and the nonce stays used
+func (l *accountLedger) fail(tx *BlockchainTransaction) error {
+	l.balances[tx.From] = new(big.Int).Add(l.balance(tx.From), tx.Amount)
+	l.balances[tx.To] = new(big.Int).Sub(l.balance(tx.To), tx.Amount)
+	for _, transfer := range tx.TokenTransfers {
+		l.addToken(transfer.Contract, transfer.From, transfer.Amount)
+		l.addToken(transfer.Contract, transfer.To, new(big.Int).Neg(transfer.Amount))
+	}
+	return nil
+}
+
+// credit pays the amount of tx, or of the token contract when set, to its
+// receiver without debiting the sender
+func (l *accountLedger) credit(tx *BlockchainTransaction, contract string) error {
+	if contract != "" {
+		tx.TokenTransfers = []TokenTransfer{{Contract: contract, From: tx.From, To: tx.To, Amount: tx.Amount}}
+		tx.To = contract
+		tx.Amount = big.NewInt(0)
+	}
+	l.balances[tx.To] = new(big.Int).Add(l.balance(tx.To), tx.Amount)
+	for _, transfer := range tx.TokenTransfers {
+		l.addToken(transfer.Contract, transfer.To, transfer.Amount)
+	}
+	return nil
+}
+
+func (l *accountLedger) uncredit(tx *BlockchainTransaction) {
+	l.balances[tx.To] = new(big.Int).Sub(l.balance(tx.To), tx.Amount)
+	for _, transfer := range tx.TokenTransfers {
+		l.addToken(transfer.Contract, transfer.To, new(big.Int).Neg(transfer.Amount))
+	}
+}
+
+func (l *accountLedger) tokenBalance(contract, address string) *big.Int {
+	balance, exists := l.tokens[strings.ToLower(contract)][address]
 	if !exists {
-		return big.NewInt(0), nil
+		return big.NewInt(0)
 	}
-	return balance, nil
+	return balance
+}
+
+func (l *accountLedger) addToken(contract, address string, delta *big.Int) {
+	key := strings.ToLower(contract)
+	if l.tokens[key] == nil {
+		l.tokens[key] = make(map[string]*big.Int)
+	}
+	l.tokens[key][address] = new(big.Int).Add(l.tokenBalance(contract, address), delta)
+}
+
+func (l *accountLedger) balance(address string) *big.Int {
+	balance, exists := l.balances[address]
+	if !exists {
+		return big.NewInt(0)
+	}
+	return balance
+}
+
+func (l *accountLedger) nextNonce(address string) uint64 {
+	return l.nonces[address]
 }
 
 // 生成模拟的交易哈希
@@ -151,9 +901,18 @@ func generateTxHash() string {
 	return "0x" + hex.EncodeToString(bytes)
 }
 
-// 生成模拟的钱包地址
-func GenerateAddress() string {
+// 生成模拟的钱包地址，格式与网络一致：BTC 为 P2WPKH，TRON 为 0x41 前缀的 Base58Check，
+// ETH/BSC 为 EIP-55 校验的 0x 地址
+func GenerateAddress(network models.Network) string {
 	bytes := make([]byte, 20)
 	rand.Read(bytes)
-	return "0x" + hex.EncodeToString(bytes)
+
+	switch network {
+	case models.NetworkBTC:
+		return encodeSegwitAddress(BitcoinMainnet.HRP, 0, bytes)
+	case models.NetworkTRON:
+		return base58CheckEncode(append([]byte{tronAddressPrefix}, bytes...))
+	default:
+		return toChecksumAddress(hex.EncodeToString(bytes))
+	}
 }
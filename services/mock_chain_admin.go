@@ -0,0 +1,203 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"time"
+)
+
+// maxMinedBlocks 单次请求最多产生的区块数
+const maxMinedBlocks = 1000
+
+// mockChain 模拟链提供的管理操作
+type mockChain interface {
+	InjectTransfer(from, to string, amount *big.Int, contract, memo string) (string, error)
+	MineBlocks(n int) []MockBlock
+	FailTransaction(txHash string) error
+	HoldTransaction(txHash string, hold bool) error
+	SetFaults(faults MockFaults) error
+	Status() MockChainStatus
+	Blocks(count int) []MockBlock
+	Block(number uint64) (*MockBlock, error)
+	Mempool() []*BlockchainTransaction
+}
+
+// MockChainAdmin 模拟链的管理操作：注资、模拟外部转入、出块、
+// 强制交易失败或挂起、注入 RPC 故障、查看区块和内存池以及推进时钟
+type MockChainAdmin struct {
+	chains  Chains
+	assets  *AssetRegistry
+	clock   config.Clock              // 服务和模拟链共用的时钟
+	faucets map[models.Network]string // 各网络水龙头的发送地址
+}
+
+func NewMockChainAdmin(chains Chains, assets *AssetRegistry, clock config.Clock) *MockChainAdmin {
+	faucets := make(map[models.Network]string, len(chains))
+	for network := range chains {
+		faucets[network] = GenerateAddress(network)
+	}
+	return &MockChainAdmin{chains: chains, assets: assets, clock: clock, faucets: faucets}
+}
+
+// AllMock 判断所有网络是否都使用模拟链
+func (c Chains) AllMock() bool {
+	for _, chain := range c {
+		if _, ok := chain.(mockChain); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *MockChainAdmin) chain(network models.Network) (mockChain, error) {
+	chain, err := a.chains.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	mock, ok := chain.(mockChain)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a mock chain", network)
+	}
+	return mock, nil
+}
+
+// Faucet 从网络的水龙头地址向 address 转入资产，asset 为空时为原生币
+func (a *MockChainAdmin) Faucet(network models.Network, address, asset string, amount float64) (string, error) {
+	return a.Transfer(network, a.faucets[network], address, asset, amount, "")
+}
+
+// Transfer 模拟外部地址向 to 的转账，发送方无需持有余额，
+// from 为空时随机生成外部地址。交易进入内存池，出块后才有确认
+func (a *MockChainAdmin) Transfer(network models.Network, from, to, asset string, amount float64, memo string) (string, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return "", err
+	}
+	if to == "" {
+		return "", fmt.Errorf("receiving address is required")
+	}
+	if amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+	registered, err := a.assets.Get(network, asset)
+	if err != nil {
+		return "", err
+	}
+	if from == "" {
+		from = GenerateAddress(network)
+	}
+
+	return chain.InjectTransfer(from, to, toUnits(amount, registered.Decimals), registered.ContractAddress, memo)
+}
+
+// MineBlocks 立即产生 n 个区块
+func (a *MockChainAdmin) MineBlocks(network models.Network, n int) ([]MockBlock, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return nil, err
+	}
+	if n <= 0 || n > maxMinedBlocks {
+		return nil, fmt.Errorf("block count must be between 1 and %d", maxMinedBlocks)
+	}
+	return chain.MineBlocks(n), nil
+}
+
+// FailTransaction 使内存池中的交易执行失败，手续费照常扣除
+func (a *MockChainAdmin) FailTransaction(network models.Network, txHash string) error {
+	chain, err := a.chain(network)
+	if err != nil {
+		return err
+	}
+	return chain.FailTransaction(txHash)
+}
+
+// HoldTransaction 使内存池中的交易不被打包（hold 为 false 时恢复），交易保持待确认
+func (a *MockChainAdmin) HoldTransaction(network models.Network, txHash string, hold bool) error {
+	chain, err := a.chain(network)
+	if err != nil {
+		return err
+	}
+	return chain.HoldTransaction(txHash, hold)
+}
+
+// SetFaults 设置 RPC 调用的故障注入，比例为百分比，全部为 0 时关闭
+func (a *MockChainAdmin) SetFaults(network models.Network, errorRate, latencyRate float64, latency time.Duration) error {
+	chain, err := a.chain(network)
+	if err != nil {
+		return err
+	}
+	return chain.SetFaults(MockFaults{ErrorRate: errorRate, LatencyRate: latencyRate, Latency: latency})
+}
+
+// Status 返回链顶高度、内存池大小和故障注入设置
+func (a *MockChainAdmin) Status(network models.Network) (*MockChainStatus, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return nil, err
+	}
+	status := chain.Status()
+	return &status, nil
+}
+
+// GetBlocks 返回从链顶往下的 count 个区块
+func (a *MockChainAdmin) GetBlocks(network models.Network, count int) ([]MockBlock, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return nil, err
+	}
+	if count <= 0 {
+		return nil, fmt.Errorf("block count must be positive")
+	}
+	return chain.Blocks(count), nil
+}
+
+// GetBlock 返回指定高度的区块
+func (a *MockChainAdmin) GetBlock(network models.Network, number uint64) (*MockBlock, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return nil, err
+	}
+	return chain.Block(number)
+}
+
+// GetMempool 返回等待打包的交易
+func (a *MockChainAdmin) GetMempool(network models.Network) ([]*BlockchainTransaction, error) {
+	chain, err := a.chain(network)
+	if err != nil {
+		return nil, err
+	}
+	return chain.Mempool(), nil
+}
+
+// Clock 返回当前时间以及时钟是否可以推进
+func (a *MockChainAdmin) Clock() (time.Time, bool) {
+	_, fake := a.clock.(*config.FakeClock)
+	return a.clock.Now(), fake
+}
+
+// AdvanceClock 将模拟时钟推进 d，只在使用 FakeClock 时可用。
+// 时间推进不会自动出块，新区块的时间戳取推进后的时间
+func (a *MockChainAdmin) AdvanceClock(d time.Duration) (time.Time, error) {
+	fake, ok := a.clock.(*config.FakeClock)
+	if !ok {
+		return time.Time{}, fmt.Errorf("clock is not controllable")
+	}
+	if d <= 0 {
+		return time.Time{}, fmt.Errorf("duration must be positive")
+	}
+	return fake.Advance(d), nil
+}
+
+// SetClock 将模拟时钟设置到 t，只能向后设置
+func (a *MockChainAdmin) SetClock(t time.Time) (time.Time, error) {
+	fake, ok := a.clock.(*config.FakeClock)
+	if !ok {
+		return time.Time{}, fmt.Errorf("clock is not controllable")
+	}
+	if err := fake.Set(t); err != nil {
+		return time.Time{}, err
+	}
+	return fake.Now(), nil
+}
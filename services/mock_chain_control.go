@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"time"
+)
+
+// ErrInjectedFault is returned by RPC calls failed on purpose, see SetFaults
+var ErrInjectedFault = errors.New("mock chain: injected RPC error")
+
+// MockFaults configures the failures injected into the RPC calls of a mock
+// chain. Rates are percentages of calls.
+type MockFaults struct {
+	ErrorRate   float64       `json:"error_rate"`   // Calls failing with ErrInjectedFault
+	LatencyRate float64       `json:"latency_rate"` // Calls delayed by Latency
+	Latency     time.Duration `json:"latency"`
+}
+
+// MockChainStatus summarizes the state of a mock chain
+type MockChainStatus struct {
+	Tip          uint64     `json:"tip"`
+	TipHash      string     `json:"tip_hash"`
+	BaseFee      *big.Int   `json:"base_fee"`
+	Mempool      int        `json:"mempool"`
+	Held         []string   `json:"held"`
+	Transactions int        `json:"transactions"`
+	Faults       MockFaults `json:"faults"`
+}
+
+// InjectTransfer credits amount to an address as if it was sent by an
+// external wallet, or pays a token when contract is set. The sender is not
+// debited, which makes it the faucet of the mock chain. The transfer waits in
+// the mempool like any other transaction.
+func (b *MockBlockchain) InjectTransfer(from, to string, amount *big.Int, contract, memo string) (string, error) {
+	if amount == nil || amount.Sign() <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	tx := &BlockchainTransaction{
+		Hash:      generateTxHash(),
+		From:      from,
+		To:        to,
+		Amount:    new(big.Int).Set(amount),
+		Memo:      memo,
+		Timestamp: b.clock.Now(),
+		Fee:       big.NewInt(0),
+		GasPrice:  big.NewInt(0),
+	}
+	if err := b.ledger.credit(tx, contract); err != nil {
+		return "", err
+	}
+
+	tx.Status = "pending"
+	b.transactions[tx.Hash] = tx
+	b.mempool = append(b.mempool, tx.Hash)
+	b.injected[tx.Hash] = true
+	b.save()
+
+	return tx.Hash, nil
+}
+
+// FailTransaction makes a mempool transaction fail: its transfers are undone
+// but the fee stays spent, and it is still mined with status "failed"
+func (b *MockBlockchain) FailTransaction(txHash string) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	tx, err := b.mempoolTransaction(txHash)
+	if err != nil {
+		return err
+	}
+	// Failing twice would undo the transfers again
+	if tx.Status != "pending" {
+		return fmt.Errorf("transaction %s is already %s", txHash, tx.Status)
+	}
+	if b.injected[txHash] {
+		return fmt.Errorf("injected transfers cannot fail")
+	}
+	if err := b.ledger.fail(tx); err != nil {
+		return err
+	}
+	tx.Status = "failed"
+	b.save()
+
+	return nil
+}
+
+// HoldTransaction keeps a mempool transaction out of new blocks until it is
+// released, so it stays pending however many blocks are mined. Later
+// transactions of the same sender are not held back.
+func (b *MockBlockchain) HoldTransaction(txHash string, hold bool) error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if _, err := b.mempoolTransaction(txHash); err != nil {
+		return err
+	}
+	if hold {
+		b.held[txHash] = true
+	} else {
+		delete(b.held, txHash)
+	}
+	b.save()
+
+	return nil
+}
+
+func (b *MockBlockchain) mempoolTransaction(txHash string) (*BlockchainTransaction, error) {
+	tx, exists := b.transactions[txHash]
+	if !exists {
+		return nil, ErrTransactionNotFound
+	}
+	if tx.BlockHash != "" || (tx.Status != "pending" && tx.Status != "failed") {
+		return nil, fmt.Errorf("transaction %s is not in the mempool", txHash)
+	}
+	return tx, nil
+}
+
+// SetFaults replaces the failures injected into RPC calls, zero values turn
+// injection off. Calls that cannot return an error are only delayed. Faults
+// are not part of the persisted state.
+func (b *MockBlockchain) SetFaults(faults MockFaults) error {
+	if faults.ErrorRate < 0 || faults.ErrorRate > 100 || faults.LatencyRate < 0 || faults.LatencyRate > 100 {
+		return fmt.Errorf("fault rates must be between 0 and 100")
+	}
+	if faults.Latency < 0 {
+		return fmt.Errorf("latency must not be negative")
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.faults = faults
+	return nil
+}
+
+// fault delays and fails an RPC call according to the configured faults. It
+// is called before taking the chain lock so a delayed call does not block
+// the others.
+func (b *MockBlockchain) fault() error {
+	faults := b.delay()
+	if faults.ErrorRate > 0 && rand.Float64()*100 < faults.ErrorRate {
+		return ErrInjectedFault
+	}
+	return nil
+}
+
+// delay sleeps on the chain clock for the configured latency on the share of
+// calls it applies to. A fake clock advances instead of blocking.
+func (b *MockBlockchain) delay() MockFaults {
+	b.mutex.RLock()
+	faults := b.faults
+	b.mutex.RUnlock()
+
+	if faults.LatencyRate > 0 && rand.Float64()*100 < faults.LatencyRate {
+		b.clock.Sleep(faults.Latency)
+	}
+	return faults
+}
+
+// Status returns the tip, mempool and fault settings of the chain
+func (b *MockBlockchain) Status() MockChainStatus {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	tip := b.tip()
+	return MockChainStatus{
+		Tip:          tip.Number,
+		TipHash:      tip.Hash,
+		BaseFee:      new(big.Int).Set(b.baseFee),
+		Mempool:      len(b.mempool),
+		Held:         sortedKeys(b.held),
+		Transactions: len(b.transactions),
+		Faults:       b.faults,
+	}
+}
+
+// Blocks returns up to count blocks from the tip down
+func (b *MockBlockchain) Blocks(count int) []MockBlock {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	blocks := make([]MockBlock, 0, count)
+	for i := len(b.blocks) - 1; i >= 0 && len(blocks) < count; i-- {
+		blocks = append(blocks, *b.blocks[i])
+	}
+	return blocks
+}
+
+// Block returns the canonical block at the given height
+func (b *MockBlockchain) Block(number uint64) (*MockBlock, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	if number >= uint64(len(b.blocks)) {
+		return nil, fmt.Errorf("block %d not found", number)
+	}
+	block := *b.blocks[number]
+	return &block, nil
+}
+
+// Mempool returns the transactions waiting for a block in arrival order
+func (b *MockBlockchain) Mempool() []*BlockchainTransaction {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	transactions := make([]*BlockchainTransaction, 0, len(b.mempool))
+	for _, hash := range b.mempool {
+		if tx, exists := b.transactions[hash]; exists {
+			transactions = append(transactions, b.view(tx))
+		}
+	}
+	return transactions
+}
@@ -0,0 +1,404 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// mockChainState is the serialized state of a mock chain
+type mockChainState struct {
+	Transactions []*BlockchainTransaction `json:"transactions"`
+	Pool         []pooledTxState          `json:"pool"`
+	Mempool      []string                 `json:"mempool"`
+	Blocks       []*MockBlock             `json:"blocks"`
+	Injected     []string                 `json:"injected"`
+	Held         []string                 `json:"held"`
+	BaseFee      *big.Int                 `json:"base_fee"`
+	Ledger       json.RawMessage          `json:"ledger"`
+}
+
+// pooledTxState refers to a pooled transaction by hash, the transaction
+// itself is stored with the others
+type pooledTxState struct {
+	Hash    string     `json:"hash"`
+	Options *TxOptions `json:"options"`
+}
+
+// ExportState encodes the complete chain state: transactions, the pool and
+// mempool, blocks, the fee level and balances
+func (b *MockBlockchain) ExportState() ([]byte, error) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.exportState()
+}
+
+func (b *MockBlockchain) exportState() ([]byte, error) {
+	ledgerState, err := b.ledger.exportState()
+	if err != nil {
+		return nil, err
+	}
+
+	state := mockChainState{
+		Transactions: make([]*BlockchainTransaction, 0, len(b.transactions)),
+		Pool:         make([]pooledTxState, 0, len(b.pool)),
+		Mempool:      b.mempool,
+		Blocks:       b.blocks,
+		BaseFee:      b.baseFee,
+		Ledger:       ledgerState,
+		Injected:     sortedKeys(b.injected),
+		Held:         sortedKeys(b.held),
+	}
+	for _, tx := range b.transactions {
+		state.Transactions = append(state.Transactions, tx)
+	}
+	sort.Slice(state.Transactions, func(i, j int) bool {
+		return state.Transactions[i].Timestamp.Before(state.Transactions[j].Timestamp)
+	})
+	for _, pooled := range b.pool {
+		state.Pool = append(state.Pool, pooledTxState{Hash: pooled.tx.Hash, Options: pooled.opts})
+	}
+	return json.Marshal(state)
+}
+
+// ImportState replaces the chain state with one produced by ExportState
+func (b *MockBlockchain) ImportState(data []byte) error {
+	var state mockChainState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid chain state: %v", err)
+	}
+
+	transactions := make(map[string]*BlockchainTransaction, len(state.Transactions))
+	for _, tx := range state.Transactions {
+		transactions[tx.Hash] = tx
+	}
+	pool := make(map[string]*pooledTx, len(state.Pool))
+	for _, pooled := range state.Pool {
+		tx, exists := transactions[pooled.Hash]
+		if !exists {
+			return fmt.Errorf("pooled transaction %s not found", pooled.Hash)
+		}
+		pool[poolKey(tx.From, tx.Nonce)] = &pooledTx{tx: tx, opts: pooled.Options}
+	}
+	blocks := state.Blocks
+	if len(blocks) == 0 {
+		blocks = []*MockBlock{genesisBlock(b.clock.Now())}
+	}
+	for number, block := range blocks {
+		if block == nil || block.Number != uint64(number) {
+			return fmt.Errorf("block %d missing from chain state", number)
+		}
+	}
+
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if err := b.ledger.importState(state.Ledger); err != nil {
+		return err
+	}
+	b.transactions = transactions
+	b.pool = pool
+	b.mempool = state.Mempool
+	b.blocks = blocks
+	b.injected = hashSet(state.Injected)
+	for _, block := range b.blocks {
+		block.deltas = b.blockDeltas(block)
+	}
+	b.held = hashSet(state.Held)
+	if state.BaseFee != nil {
+		b.baseFee = state.BaseFee
+	}
+	b.save()
+	return nil
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hashSet(hashes []string) map[string]bool {
+	set := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		set[hash] = true
+	}
+	return set
+}
+
+// setPersistence registers the hook receiving the state after every change
+func (b *MockBlockchain) setPersistence(persist func(state []byte) error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.persist = persist
+}
+
+// save hands the current state to the persistence hook. Callers hold the
+// write lock.
+func (b *MockBlockchain) save() {
+	if b.persist == nil {
+		return
+	}
+	state, err := b.exportState()
+	if err == nil {
+		err = b.persist(state)
+	}
+	if err != nil {
+		log.Printf("mock chain: failed to persist state: %v", err)
+	}
+}
+
+type accountLedgerState struct {
+	Balances map[string]*big.Int            `json:"balances"`
+	Nonces   map[string]uint64              `json:"nonces"`
+	Tokens   map[string]map[string]*big.Int `json:"tokens"`
+}
+
+func (l *accountLedger) exportState() (json.RawMessage, error) {
+	return json.Marshal(accountLedgerState{Balances: l.balances, Nonces: l.nonces, Tokens: l.tokens})
+}
+
+func (l *accountLedger) importState(data json.RawMessage) error {
+	var state accountLedgerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid ledger state: %v", err)
+	}
+	restored := newAccountLedger()
+	for address, balance := range state.Balances {
+		restored.balances[address] = balance
+	}
+	for address, nonce := range state.Nonces {
+		restored.nonces[address] = nonce
+	}
+	for contract, holders := range state.Tokens {
+		for address, balance := range holders {
+			restored.addToken(contract, address, balance)
+		}
+	}
+	*l = *restored
+	return nil
+}
+
+type utxoLedgerState struct {
+	UTXOs []*UTXO `json:"utxos"`
+}
+
+func (l *utxoLedger) exportState() (json.RawMessage, error) {
+	state := utxoLedgerState{UTXOs: make([]*UTXO, 0, len(l.utxos))}
+	for _, utxo := range l.utxos {
+		state.UTXOs = append(state.UTXOs, utxo)
+	}
+	sort.Slice(state.UTXOs, func(i, j int) bool {
+		return outpoint(state.UTXOs[i].TxHash, state.UTXOs[i].Index) < outpoint(state.UTXOs[j].TxHash, state.UTXOs[j].Index)
+	})
+	return json.Marshal(state)
+}
+
+func (l *utxoLedger) importState(data json.RawMessage) error {
+	var state utxoLedgerState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid ledger state: %v", err)
+	}
+	l.utxos = make(map[string]*UTXO, len(state.UTXOs))
+	for _, utxo := range state.UTXOs {
+		l.utxos[outpoint(utxo.TxHash, utxo.Index)] = utxo
+	}
+	return nil
+}
+
+// statefulChain is implemented by the mock chains
+type statefulChain interface {
+	ExportState() ([]byte, error)
+	ImportState(data []byte) error
+	setPersistence(persist func(state []byte) error)
+}
+
+// snapshotName limits snapshot names to safe file names
+var snapshotName = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// ChainSnapshot describes a stored snapshot
+type ChainSnapshot struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Size      int64     `json:"size"`
+}
+
+// MockChainStore keeps the mock chains on disk. The live state of every
+// network is rewritten after each change and reloaded on start; named
+// snapshots capture all networks at once so test scenarios can be reset to
+// a known state.
+type MockChainStore struct {
+	dir    string
+	chains Chains
+}
+
+// NewMockChainStore creates the store directory if needed
+func NewMockChainStore(dir string, chains Chains) (*MockChainStore, error) {
+	if err := os.MkdirAll(filepath.Join(dir, "snapshots"), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create chain store: %v", err)
+	}
+	return &MockChainStore{dir: dir, chains: chains}, nil
+}
+
+// Load restores the saved state of every mock chain and persists all later
+// changes. Networks without saved state start empty.
+func (s *MockChainStore) Load() error {
+	for network, chain := range s.chains {
+		stateful, ok := chain.(statefulChain)
+		if !ok {
+			continue
+		}
+
+		path := s.statePath(network)
+		data, err := os.ReadFile(path)
+		if err == nil {
+			if err := stateful.ImportState(data); err != nil {
+				return fmt.Errorf("failed to load %s chain state: %v", network, err)
+			}
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s chain state: %v", network, err)
+		}
+
+		stateful.setPersistence(func(state []byte) error {
+			return writeFileAtomic(path, state)
+		})
+	}
+	return nil
+}
+
+// SaveSnapshot stores the current state of all mock chains under name,
+// replacing an existing snapshot with the same name
+func (s *MockChainStore) SaveSnapshot(name string) (*ChainSnapshot, error) {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[models.Network]json.RawMessage)
+	for network, chain := range s.chains {
+		stateful, ok := chain.(statefulChain)
+		if !ok {
+			continue
+		}
+		state, err := stateful.ExportState()
+		if err != nil {
+			return nil, fmt.Errorf("failed to export %s chain state: %v", network, err)
+		}
+		states[network] = state
+	}
+
+	data, err := json.Marshal(states)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return nil, err
+	}
+	return &ChainSnapshot{Name: name, CreatedAt: time.Now(), Size: int64(len(data))}, nil
+}
+
+// RestoreSnapshot replaces the state of all mock chains with a snapshot
+func (s *MockChainStore) RestoreSnapshot(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %s not found", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	var states map[models.Network]json.RawMessage
+	if err := json.Unmarshal(data, &states); err != nil {
+		return fmt.Errorf("invalid snapshot %s: %v", name, err)
+	}
+	// Check every network first so a snapshot is never applied partially
+	for network, chain := range s.chains {
+		if _, ok := chain.(statefulChain); ok && states[network] == nil {
+			return fmt.Errorf("snapshot %s has no state for %s", name, network)
+		}
+	}
+
+	for network, chain := range s.chains {
+		stateful, ok := chain.(statefulChain)
+		if !ok {
+			continue
+		}
+		if err := stateful.ImportState(states[network]); err != nil {
+			return fmt.Errorf("failed to restore %s chain state: %v", network, err)
+		}
+	}
+	return nil
+}
+
+// ListSnapshots returns the stored snapshots ordered by name
+func (s *MockChainStore) ListSnapshots() ([]ChainSnapshot, error) {
+	entries, err := os.ReadDir(filepath.Join(s.dir, "snapshots"))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := make([]ChainSnapshot, 0, len(entries))
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".json")
+		if entry.IsDir() || name == entry.Name() || !snapshotName.MatchString(name) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, ChainSnapshot{Name: name, CreatedAt: info.ModTime(), Size: info.Size()})
+	}
+	return snapshots, nil
+}
+
+// DeleteSnapshot removes a stored snapshot
+func (s *MockChainStore) DeleteSnapshot(name string) error {
+	path, err := s.snapshotPath(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); os.IsNotExist(err) {
+		return fmt.Errorf("snapshot %s not found", name)
+	} else if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (s *MockChainStore) statePath(network models.Network) string {
+	return filepath.Join(s.dir, strings.ToLower(string(network))+".json")
+}
+
+func (s *MockChainStore) snapshotPath(name string) (string, error) {
+	if !snapshotName.MatchString(name) {
+		return "", fmt.Errorf("invalid snapshot name: %q", name)
+	}
+	return filepath.Join(s.dir, "snapshots", name+".json"), nil
+}
+
+// writeFileAtomic replaces path with data so readers never see a partial file
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,737 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// mockChainIDs are the EIP-155 chain IDs reported by the JSON-RPC facade.
+// They are dev chain IDs so tooling never mistakes the mock for a real
+// network.
+var mockChainIDs = map[models.Network]uint64{
+	models.NetworkETH: 1337,
+	models.NetworkBSC: 1338,
+}
+
+// transferEventTopic is keccak256("Transfer(address,address,uint256)")
+const transferEventTopic = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
+
+// JSON-RPC 2.0 error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// MarshalJSON leaves out the result of error responses, a JSON-RPC response
+// carries either a result (possibly null) or an error
+func (r rpcResponse) MarshalJSON() ([]byte, error) {
+	if r.Error != nil {
+		return json.Marshal(struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Error   *rpcError       `json:"error"`
+		}{r.JSONRPC, r.ID, r.Error})
+	}
+	type plain rpcResponse
+	return json.Marshal(plain(r))
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return e.Message
+}
+
+func invalidParams(format string, args ...interface{}) *rpcError {
+	return &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf(format, args...)}
+}
+
+// MockRPCServer serves the core eth_ JSON-RPC methods from the state of an
+// account based mock chain, so Ethereum tooling can use it as a local dev
+// node. Injected faults apply to every call.
+type MockRPCServer struct {
+	chain   *MockBlockchain
+	chainID uint64
+}
+
+// NewMockRPCServer creates the JSON-RPC facade of an EVM network
+func NewMockRPCServer(chains Chains, network models.Network) (*MockRPCServer, error) {
+	chainID, ok := mockChainIDs[network]
+	if !ok {
+		return nil, fmt.Errorf("JSON-RPC is not available for %s", network)
+	}
+	chain, err := chains.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	mock, ok := chain.(*MockBlockchain)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a mock chain", network)
+	}
+	return &MockRPCServer{chain: mock, chainID: chainID}, nil
+}
+
+// ServeHTTP handles single and batch JSON-RPC requests
+func (s *MockRPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "JSON-RPC requests must use POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcParseError, Message: "parse error"}})
+		return
+	}
+
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(body, &batch); err != nil || len(batch) == 0 {
+			writeRPC(w, rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+				Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid batch"}})
+			return
+		}
+		responses := make([]rpcResponse, 0, len(batch))
+		for _, message := range batch {
+			responses = append(responses, s.handle(message))
+		}
+		writeRPC(w, responses)
+		return
+	}
+	writeRPC(w, s.handle(body))
+}
+
+func writeRPC(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+func (s *MockRPCServer) handle(message json.RawMessage) rpcResponse {
+	var req rpcRequest
+	if err := json.Unmarshal(message, &req); err != nil || req.Method == "" {
+		return rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"),
+			Error: &rpcError{Code: rpcInvalidRequest, Message: "invalid request"}}
+	}
+	if req.ID == nil {
+		req.ID = json.RawMessage("null")
+	}
+
+	result, err := s.call(req.Method, req.Params)
+	response := rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result}
+	if err != nil {
+		var rpcErr *rpcError
+		if !errors.As(err, &rpcErr) {
+			rpcErr = &rpcError{Code: rpcServerError, Message: err.Error()}
+		}
+		response.Error = rpcErr
+	}
+	return response
+}
+
+func (s *MockRPCServer) call(method string, params []json.RawMessage) (interface{}, error) {
+	if err := s.chain.fault(); err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "eth_chainId":
+		return hexUint(s.chainID), nil
+	case "net_version":
+		return strconv.FormatUint(s.chainID, 10), nil
+	case "eth_blockNumber":
+		return hexUint(s.tip()), nil
+	case "eth_gasPrice":
+		s.chain.mutex.RLock()
+		defer s.chain.mutex.RUnlock()
+		return hexBig(s.chain.baseFee), nil
+	case "eth_getBalance":
+		return s.getBalance(params)
+	case "eth_getTransactionCount":
+		return s.getTransactionCount(params)
+	case "eth_getBlockByNumber":
+		return s.getBlockByNumber(params)
+	case "eth_getTransactionByHash":
+		return s.getTransactionByHash(params)
+	case "eth_getTransactionReceipt":
+		return s.getTransactionReceipt(params)
+	case "eth_getLogs":
+		return s.getLogs(params)
+	case "eth_sendRawTransaction":
+		return s.sendRawTransaction(params)
+	case "eth_feeHistory":
+		return s.feeHistory(params)
+	case "eth_call":
+		return s.callContract(params)
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("the method %s does not exist/is not available", method)}
+	}
+}
+
+func (s *MockRPCServer) getBalance(params []json.RawMessage) (interface{}, error) {
+	address, err := addressParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	number, latest, err := s.stateBlock(params, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	if !latest {
+		return hexBig(s.chain.balanceAt("", address, number)), nil
+	}
+	return hexBig(s.chain.ledger.balance(address)), nil
+}
+
+func (s *MockRPCServer) getTransactionCount(params []json.RawMessage) (interface{}, error) {
+	address, err := addressParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.requireLatest(params, 1); err != nil {
+		return nil, err
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	return hexUint(s.chain.ledger.nextNonce(address)), nil
+}
+
+// stateBlock resolves the block a state query runs against. Block numbers
+// are answered from the per-block balance changes, the latest state (a
+// missing parameter or a tag naming the tip) from the ledger, which
+// includes the mempool.
+func (s *MockRPCServer) stateBlock(params []json.RawMessage, index int) (uint64, bool, error) {
+	if len(params) <= index {
+		return 0, true, nil
+	}
+	var value string
+	if err := json.Unmarshal(params[index], &value); err != nil {
+		return 0, false, invalidParams("invalid block number")
+	}
+	number, err := s.blockParam(params[index])
+	if err != nil {
+		return 0, false, err
+	}
+	if number > s.tip() {
+		return 0, false, &rpcError{Code: rpcServerError, Message: "header not found"}
+	}
+	return number, !strings.HasPrefix(value, "0x") && value != "earliest", nil
+}
+
+// requireLatest accepts a missing block parameter or one naming the tip,
+// only the current state is kept
+func (s *MockRPCServer) requireLatest(params []json.RawMessage, index int) error {
+	if len(params) <= index {
+		return nil
+	}
+	number, err := s.blockParam(params[index])
+	if err != nil {
+		return err
+	}
+	if number != s.tip() {
+		return &rpcError{Code: rpcServerError, Message: "historical state is not available"}
+	}
+	return nil
+}
+
+func (s *MockRPCServer) getBlockByNumber(params []json.RawMessage) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, invalidParams("missing block number")
+	}
+	number, err := s.blockParam(params[0])
+	if err != nil {
+		return nil, err
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	if number >= uint64(len(s.chain.blocks)) {
+		return nil, nil
+	}
+	block := s.chain.blocks[number]
+
+	// The second parameter asks for full transaction objects
+	var full bool
+	if len(params) > 1 {
+		if err := json.Unmarshal(params[1], &full); err != nil {
+			return nil, invalidParams("invalid argument 1: %v", err)
+		}
+	}
+	transactions := make([]interface{}, 0, len(block.Transactions))
+	for _, hash := range block.Transactions {
+		tx, exists := s.chain.transactions[hash]
+		if !exists {
+			continue
+		}
+		if full {
+			transactions = append(transactions, s.transactionObject(tx))
+		} else {
+			transactions = append(transactions, hash)
+		}
+	}
+
+	return map[string]interface{}{
+		"number":       hexUint(block.Number),
+		"hash":         block.Hash,
+		"parentHash":   block.ParentHash,
+		"timestamp":    hexUint(uint64(block.Timestamp.Unix())),
+		"transactions": transactions,
+	}, nil
+}
+
+func (s *MockRPCServer) getTransactionByHash(params []json.RawMessage) (interface{}, error) {
+	hash, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	tx, exists := s.chain.transactions[hash]
+	if !exists || tx.Status == "queued" || tx.Status == "replaced" {
+		return nil, nil
+	}
+	return s.transactionObject(tx), nil
+}
+
+// transactionObject encodes a transaction the way nodes return it. Callers
+// hold the read lock.
+func (s *MockRPCServer) transactionObject(tx *BlockchainTransaction) map[string]interface{} {
+	result := map[string]interface{}{
+		"hash":             tx.Hash,
+		"nonce":            hexUint(tx.Nonce),
+		"from":             tx.From,
+		"to":               tx.To,
+		"value":            hexBig(tx.Amount),
+		"gas":              hexUint(tx.GasUsed),
+		"gasPrice":         hexBig(tx.GasPrice),
+		"input":            transferInput(tx),
+		"chainId":          hexUint(s.chainID),
+		"blockHash":        nil,
+		"blockNumber":      nil,
+		"transactionIndex": nil,
+	}
+	if tx.BlockHash != "" {
+		result["blockHash"] = tx.BlockHash
+		result["blockNumber"] = hexUint(tx.BlockNumber)
+		result["transactionIndex"] = hexUint(s.transactionIndex(tx))
+	}
+	return result
+}
+
+func (s *MockRPCServer) getTransactionReceipt(params []json.RawMessage) (interface{}, error) {
+	hash, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	tx, exists := s.chain.transactions[hash]
+	if !exists || tx.BlockHash == "" {
+		return nil, nil
+	}
+
+	status := "0x1"
+	if tx.Status == "failed" {
+		status = "0x0"
+	}
+	return map[string]interface{}{
+		"transactionHash":   tx.Hash,
+		"transactionIndex":  hexUint(s.transactionIndex(tx)),
+		"blockHash":         tx.BlockHash,
+		"blockNumber":       hexUint(tx.BlockNumber),
+		"from":              tx.From,
+		"to":                tx.To,
+		"gasUsed":           hexUint(tx.GasUsed),
+		"cumulativeGasUsed": hexUint(tx.GasUsed),
+		"effectiveGasPrice": hexBig(tx.GasPrice),
+		"contractAddress":   nil,
+		"logs":              s.transactionLogs(tx),
+		"logsBloom":         "0x" + strings.Repeat("0", 512),
+		"status":            status,
+		"type":              "0x0",
+	}, nil
+}
+
+// rpcLogFilter is the filter object of eth_getLogs. Address and each topic
+// position are either a single value or a list of alternatives.
+type rpcLogFilter struct {
+	FromBlock json.RawMessage   `json:"fromBlock"`
+	ToBlock   json.RawMessage   `json:"toBlock"`
+	BlockHash string            `json:"blockHash"`
+	Address   json.RawMessage   `json:"address"`
+	Topics    []json.RawMessage `json:"topics"`
+}
+
+func (s *MockRPCServer) getLogs(params []json.RawMessage) (interface{}, error) {
+	var filter rpcLogFilter
+	if len(params) > 0 {
+		if err := json.Unmarshal(params[0], &filter); err != nil {
+			return nil, invalidParams("invalid filter: %v", err)
+		}
+	}
+	addresses, err := oneOrMany(filter.Address)
+	if err != nil {
+		return nil, invalidParams("invalid address filter")
+	}
+	topics := make([][]string, len(filter.Topics))
+	for i, topic := range filter.Topics {
+		if topics[i], err = oneOrMany(topic); err != nil {
+			return nil, invalidParams("invalid topic filter")
+		}
+	}
+
+	from, to := s.tip(), s.tip()
+	if filter.FromBlock != nil {
+		if from, err = s.blockParam(filter.FromBlock); err != nil {
+			return nil, err
+		}
+	}
+	if filter.ToBlock != nil {
+		if to, err = s.blockParam(filter.ToBlock); err != nil {
+			return nil, err
+		}
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	blocks := s.chain.blocks
+	if filter.BlockHash != "" {
+		from, to = 1, 0
+		for _, block := range blocks {
+			if strings.EqualFold(block.Hash, filter.BlockHash) {
+				from, to = block.Number, block.Number
+			}
+		}
+	}
+
+	logs := make([]map[string]interface{}, 0)
+	for number := from; number <= to && number < uint64(len(blocks)); number++ {
+		for _, hash := range blocks[number].Transactions {
+			tx, exists := s.chain.transactions[hash]
+			if !exists {
+				continue
+			}
+			for _, log := range s.transactionLogs(tx) {
+				if matchesLog(log, addresses, topics) {
+					logs = append(logs, log)
+				}
+			}
+		}
+	}
+	return logs, nil
+}
+
+// transactionLogs returns the Transfer events of a mined transaction, failed
+// transactions emit none. Callers hold the read lock.
+func (s *MockRPCServer) transactionLogs(tx *BlockchainTransaction) []map[string]interface{} {
+	logs := make([]map[string]interface{}, 0, len(tx.TokenTransfers))
+	if tx.Status == "failed" {
+		return logs
+	}
+	index := s.transactionIndex(tx)
+	for _, transfer := range tx.TokenTransfers {
+		logs = append(logs, map[string]interface{}{
+			"address":          transfer.Contract,
+			"topics":           []string{transferEventTopic, padWord(transfer.From), padWord(transfer.To)},
+			"data":             "0x" + fmt.Sprintf("%064x", transfer.Amount),
+			"blockNumber":      hexUint(tx.BlockNumber),
+			"blockHash":        tx.BlockHash,
+			"transactionHash":  tx.Hash,
+			"transactionIndex": hexUint(index),
+			"logIndex":         hexUint(uint64(transfer.LogIndex)),
+			"removed":          false,
+		})
+	}
+	return logs
+}
+
+func matchesLog(log map[string]interface{}, addresses []string, topics [][]string) bool {
+	if len(addresses) > 0 && !containsFold(addresses, log["address"].(string)) {
+		return false
+	}
+	logTopics := log["topics"].([]string)
+	for i, alternatives := range topics {
+		if len(alternatives) == 0 {
+			continue
+		}
+		if i >= len(logTopics) || !containsFold(alternatives, logTopics[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// transactionIndex is the position of a mined transaction in its block.
+// Callers hold the read lock.
+func (s *MockRPCServer) transactionIndex(tx *BlockchainTransaction) uint64 {
+	if tx.BlockNumber >= uint64(len(s.chain.blocks)) {
+		return 0
+	}
+	for i, hash := range s.chain.blocks[tx.BlockNumber].Transactions {
+		if hash == tx.Hash {
+			return uint64(i)
+		}
+	}
+	return 0
+}
+
+// feeHistory reports the current base fee for every requested block, the
+// mock has no fee market and no priority fees
+func (s *MockRPCServer) feeHistory(params []json.RawMessage) (interface{}, error) {
+	if len(params) < 2 {
+		return nil, invalidParams("missing block count or newest block")
+	}
+	var count hexUint64
+	if err := json.Unmarshal(params[0], &count); err != nil {
+		var plain uint64
+		if err := json.Unmarshal(params[0], &plain); err != nil {
+			return nil, invalidParams("invalid block count")
+		}
+		count = hexUint64(plain)
+	}
+	newest, err := s.blockParam(params[1])
+	if err != nil {
+		return nil, err
+	}
+	var percentiles []float64
+	if len(params) > 2 {
+		if err := json.Unmarshal(params[2], &percentiles); err != nil {
+			return nil, invalidParams("invalid reward percentiles")
+		}
+	}
+	if uint64(count) > newest+1 {
+		count = hexUint64(newest + 1)
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	baseFees := make([]string, 0, count+1)
+	ratios := make([]float64, 0, count)
+	rewards := make([][]string, 0, count)
+	for i := uint64(0); i < uint64(count); i++ {
+		baseFees = append(baseFees, hexBig(s.chain.baseFee))
+		ratios = append(ratios, 0.5)
+		reward := make([]string, len(percentiles))
+		for j := range reward {
+			reward[j] = "0x0"
+		}
+		rewards = append(rewards, reward)
+	}
+	baseFees = append(baseFees, hexBig(s.chain.baseFee))
+
+	result := map[string]interface{}{
+		"oldestBlock":   hexUint(newest + 1 - uint64(count)),
+		"baseFeePerGas": baseFees,
+		"gasUsedRatio":  ratios,
+	}
+	if len(percentiles) > 0 {
+		result["reward"] = rewards
+	}
+	return result, nil
+}
+
+// callContract answers balanceOf(address) calls on token contracts, the
+// only contract call the mock supports
+func (s *MockRPCServer) callContract(params []json.RawMessage) (interface{}, error) {
+	if len(params) < 1 {
+		return nil, invalidParams("missing call object")
+	}
+	var call struct {
+		To   string `json:"to"`
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(params[0], &call); err != nil {
+		return nil, invalidParams("invalid call object: %v", err)
+	}
+	number, latest, err := s.stateBlock(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	data := strings.TrimPrefix(call.Data, "0x")
+	if !strings.HasPrefix(data, strings.TrimPrefix(balanceOfSelector, "0x")) || len(data) != 8+64 {
+		return nil, &rpcError{Code: rpcServerError, Message: "execution reverted"}
+	}
+
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	accounts, ok := s.chain.ledger.(*accountLedger)
+	if !ok {
+		return nil, &rpcError{Code: rpcServerError, Message: "execution reverted"}
+	}
+	holder := topicAddress(data[8:])
+	if !latest {
+		return fmt.Sprintf("0x%064x", s.chain.balanceAt(call.To, holder, number)), nil
+	}
+	balance := accounts.tokenBalance(call.To, holder)
+	return fmt.Sprintf("0x%064x", balance), nil
+}
+
+// sendRawTransaction accepts legacy (EIP-155) and EIP-1559 transactions
+// signed for the chain ID of the network
+func (s *MockRPCServer) sendRawTransaction(params []json.RawMessage) (interface{}, error) {
+	raw, err := stringParam(params, 0)
+	if err != nil {
+		return nil, err
+	}
+	raw = strings.TrimPrefix(raw, "0x")
+	if _, err := hex.DecodeString(raw); err != nil {
+		return nil, invalidParams("invalid raw transaction")
+	}
+
+	hash, err := s.chain.SendRawTransaction(raw)
+	if errors.Is(err, ErrTransactionKnown) {
+		return nil, &rpcError{Code: rpcServerError, Message: "already known"}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return hash, nil
+}
+
+// tip reads the chain height without the latency injected into CurrentBlock,
+// faults are applied once per call
+func (s *MockRPCServer) tip() uint64 {
+	s.chain.mutex.RLock()
+	defer s.chain.mutex.RUnlock()
+
+	return s.chain.tip().Number
+}
+
+// blockParam resolves a block number or tag to a height
+func (s *MockRPCServer) blockParam(param json.RawMessage) (uint64, error) {
+	var value string
+	if err := json.Unmarshal(param, &value); err != nil {
+		return 0, invalidParams("invalid block number")
+	}
+	switch value {
+	case "latest", "pending", "safe", "finalized":
+		return s.tip(), nil
+	case "earliest":
+		return 0, nil
+	}
+	number, err := strconv.ParseUint(strings.TrimPrefix(value, "0x"), 16, 64)
+	if err != nil || !strings.HasPrefix(value, "0x") {
+		return 0, invalidParams("invalid block number: %s", value)
+	}
+	return number, nil
+}
+
+func stringParam(params []json.RawMessage, index int) (string, error) {
+	if len(params) <= index {
+		return "", invalidParams("missing value for required argument %d", index)
+	}
+	var value string
+	if err := json.Unmarshal(params[index], &value); err != nil {
+		return "", invalidParams("invalid argument %d: %v", index, err)
+	}
+	return value, nil
+}
+
+// addressParam reads an address and returns it in the checksummed form the
+// mock ledger is keyed by
+func addressParam(params []json.RawMessage, index int) (string, error) {
+	address, err := stringParam(params, index)
+	if err != nil {
+		return "", err
+	}
+	if _, err := hex.DecodeString(strings.TrimPrefix(address, "0x")); err != nil || len(address) != 42 {
+		return "", invalidParams("invalid address: %s", address)
+	}
+	return toChecksumAddress(address), nil
+}
+
+// oneOrMany decodes a filter value that is null, a string or a list of strings
+func oneOrMany(raw json.RawMessage) ([]string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var single string
+	if err := json.Unmarshal(raw, &single); err == nil {
+		return []string{single}, nil
+	}
+	var many []string
+	if err := json.Unmarshal(raw, &many); err != nil {
+		return nil, err
+	}
+	return many, nil
+}
+
+// transferInput encodes the transfer(address,uint256) call of a token
+// transfer, plain transfers carry no input
+func transferInput(tx *BlockchainTransaction) string {
+	if len(tx.TokenTransfers) == 0 {
+		return "0x"
+	}
+	transfer := tx.TokenTransfers[0]
+	return "0xa9059cbb" + strings.TrimPrefix(padWord(transfer.To), "0x") + fmt.Sprintf("%064x", transfer.Amount)
+}
+
+// padWord left pads an address to a 32 byte word
+func padWord(address string) string {
+	word := strings.ToLower(strings.TrimPrefix(address, "0x"))
+	if len(word) < 64 {
+		word = strings.Repeat("0", 64-len(word)) + word
+	}
+	return "0x" + word
+}
+
+func hexUint(value uint64) string {
+	return "0x" + strconv.FormatUint(value, 16)
+}
+
+func hexBig(value *big.Int) string {
+	if value == nil {
+		return "0x0"
+	}
+	return "0x" + value.Text(16)
+}
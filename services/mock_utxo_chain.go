@@ -0,0 +1,243 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+const (
+	// P2WPKH transaction sizes in virtual bytes
+	vsizeOverhead = 11
+	vsizeInput    = 68
+	vsizeOutput   = 31
+
+	// dustLimit is the smallest change output worth creating, in satoshi
+	dustLimit = 546
+)
+
+// MockUTXOBlockchain simulates a UTXO based network (BTC)
+type MockUTXOBlockchain struct {
+	*MockBlockchain
+	utxos *utxoLedger
+}
+
+// NewMockUTXOBlockchain creates a UTXO chain whose base fee is the market
+// fee rate in sat/vB
+func NewMockUTXOBlockchain(clock config.Clock) *MockUTXOBlockchain {
+	utxos := newUTXOLedger()
+	return &MockUTXOBlockchain{
+		MockBlockchain: newMockBlockchain(utxos, big.NewInt(1), bitcoinDecoder(BitcoinMainnet), clock),
+		utxos:          utxos,
+	}
+}
+
+// ListUnspent returns the unspent outputs owned by an address
+func (b *MockUTXOBlockchain) ListUnspent(address string) ([]*UTXO, error) {
+	if err := b.fault(); err != nil {
+		return nil, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	return b.utxos.unspent(address), nil
+}
+
+// EstimateTransferFee runs coin selection without broadcasting
+func (b *MockUTXOBlockchain) EstimateTransferFee(from string, amount, feeRate *big.Int) (*big.Int, uint64, error) {
+	if err := b.fault(); err != nil {
+		return nil, 0, err
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	selection, err := selectCoins(b.utxos.unspent(from), amount, feeRate)
+	if err != nil {
+		return nil, 0, err
+	}
+	return selection.fee, selection.vsize, nil
+}
+
+// utxoLedger tracks unspent outputs keyed by outpoint
+type utxoLedger struct {
+	utxos map[string]*UTXO
+}
+
+func newUTXOLedger() *utxoLedger {
+	return &utxoLedger{utxos: make(map[string]*UTXO)}
+}
+
+// outpoint keys an output by its transaction hash without the 0x prefix
+// carried by injected transfers, the form inputs of signed transactions use
+func outpoint(txHash string, index uint32) string {
+	return fmt.Sprintf("%s:%d", strings.TrimPrefix(txHash, "0x"), index)
+}
+
+// transactionVSize estimates the virtual size of a P2WPKH transaction
+func transactionVSize(inputs, outputs int) uint64 {
+	return uint64(vsizeOverhead + vsizeInput*inputs + vsizeOutput*outputs)
+}
+
+type coinSelection struct {
+	inputs []*UTXO
+	change *big.Int
+	fee    *big.Int
+	vsize  uint64
+}
+
+// selectCoins picks the largest outputs first until amount plus the fee for
+// the resulting size is covered. Change below the dust limit is left to the
+// miner instead of creating an output.
+func selectCoins(candidates []*UTXO, amount, feeRate *big.Int) (*coinSelection, error) {
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Amount.Cmp(candidates[j].Amount) > 0 })
+
+	selected := make([]*UTXO, 0, len(candidates))
+	sum := big.NewInt(0)
+	for _, utxo := range candidates {
+		selected = append(selected, utxo)
+		sum.Add(sum, utxo.Amount)
+
+		vsize := transactionVSize(len(selected), 2)
+		fee := new(big.Int).Mul(feeRate, new(big.Int).SetUint64(vsize))
+		change := new(big.Int).Sub(sum, new(big.Int).Add(amount, fee))
+		if change.Cmp(big.NewInt(dustLimit)) >= 0 {
+			return &coinSelection{inputs: selected, change: change, fee: fee, vsize: vsize}, nil
+		}
+
+		// Without a change output the remainder goes to the miner, which only
+		// has to cover the smaller single-output transaction
+		vsize = transactionVSize(len(selected), 1)
+		minFee := new(big.Int).Mul(feeRate, new(big.Int).SetUint64(vsize))
+		if remainder := new(big.Int).Sub(sum, amount); remainder.Cmp(minFee) >= 0 {
+			return &coinSelection{
+				inputs: selected,
+				change: big.NewInt(0),
+				fee:    remainder,
+				vsize:  vsize,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("insufficient balance")
+}
+
+// apply spends the inputs of a signed transaction and creates its outputs.
+// The fee is what the inputs leave to the miner, tx.GasUsed must hold the
+// virtual size.
+func (l *utxoLedger) apply(tx *BlockchainTransaction, opts *TxOptions, baseFee *big.Int) error {
+	if opts != nil && opts.TokenContract != "" {
+		return fmt.Errorf("tokens are not supported on UTXO chains")
+	}
+
+	inputTotal, spent := big.NewInt(0), make(map[string]bool, len(tx.Inputs))
+	for _, input := range tx.Inputs {
+		key := outpoint(input.PrevTxHash, input.PrevIndex)
+		if _, exists := l.utxos[key]; !exists || spent[key] {
+			return fmt.Errorf("input %s is missing or spent", key)
+		}
+		spent[key] = true
+		inputTotal.Add(inputTotal, input.Amount)
+	}
+	outputTotal := big.NewInt(0)
+	for _, output := range tx.Outputs {
+		outputTotal.Add(outputTotal, output.Amount)
+	}
+	fee := new(big.Int).Sub(inputTotal, outputTotal)
+	if fee.Sign() < 0 {
+		return fmt.Errorf("outputs exceed inputs")
+	}
+
+	for key := range spent {
+		delete(l.utxos, key)
+	}
+	l.addOutputs(tx)
+
+	tx.Fee = fee
+	tx.GasPrice = big.NewInt(0)
+	if tx.GasUsed > 0 {
+		tx.GasPrice = new(big.Int).Div(fee, new(big.Int).SetUint64(tx.GasUsed))
+	}
+	return nil
+}
+
+func (l *utxoLedger) addOutputs(tx *BlockchainTransaction) {
+	for _, output := range tx.Outputs {
+		l.utxos[outpoint(tx.Hash, output.Index)] = &UTXO{
+			TxHash:      tx.Hash,
+			Index:       output.Index,
+			Address:     output.Address,
+			Amount:      output.Amount,
+			BlockNumber: tx.BlockNumber,
+		}
+	}
+}
+
+// confirm records the block that included tx on its outputs
+func (l *utxoLedger) confirm(tx *BlockchainTransaction) {
+	for _, output := range tx.Outputs {
+		if utxo, exists := l.utxos[outpoint(tx.Hash, output.Index)]; exists {
+			utxo.BlockNumber = tx.BlockNumber
+		}
+	}
+}
+
+func (l *utxoLedger) revert(tx *BlockchainTransaction) {
+	for _, output := range tx.Outputs {
+		delete(l.utxos, outpoint(tx.Hash, output.Index))
+	}
+	for _, input := range tx.Inputs {
+		l.utxos[outpoint(input.PrevTxHash, input.PrevIndex)] = &UTXO{
+			TxHash:  input.PrevTxHash,
+			Index:   input.PrevIndex,
+			Address: input.Address,
+			Amount:  input.Amount,
+		}
+	}
+}
+
+// fail is not supported, a valid UTXO transaction cannot fail once mined
+func (l *utxoLedger) fail(tx *BlockchainTransaction) error {
+	return fmt.Errorf("transactions cannot fail on UTXO chains")
+}
+
+// credit creates a single output paying the amount of tx to its receiver
+func (l *utxoLedger) credit(tx *BlockchainTransaction, contract string) error {
+	if contract != "" {
+		return fmt.Errorf("tokens are not supported on UTXO chains")
+	}
+	tx.Outputs = []TxOutput{{Index: 0, Address: tx.To, Amount: tx.Amount}}
+	l.addOutputs(tx)
+	return nil
+}
+
+// uncredit removes the outputs of a credited transaction, which has no inputs
+// to restore
+func (l *utxoLedger) uncredit(tx *BlockchainTransaction) {
+	l.revert(tx)
+}
+
+func (l *utxoLedger) balance(address string) *big.Int {
+	total := big.NewInt(0)
+	for _, utxo := range l.unspent(address) {
+		total.Add(total, utxo.Amount)
+	}
+	return total
+}
+
+// nextNonce is always zero, UTXO chains have no sender nonces
+func (l *utxoLedger) nextNonce(address string) uint64 {
+	return 0
+}
+
+func (l *utxoLedger) unspent(address string) []*UTXO {
+	var result []*UTXO
+	for _, utxo := range l.utxos {
+		if utxo.Address == address {
+			result = append(result, utxo)
+		}
+	}
+	return result
+}
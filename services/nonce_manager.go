@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"sync"
+	"time"
+)
+
+// defaultStuckAfter 在途超过该时长仍未被打包的交易视为卡住
+const defaultStuckAfter = 10 * time.Minute
+
+// NonceManager 为 ETH/BSC 等账户模型网络的发送地址分配 nonce
+type NonceManager struct {
+	db         *gorm.DB
+	chains     Chains
+	stuckAfter time.Duration
+	clock      config.Clock
+
+	mutex sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+// NonceReport 发送地址的 nonce 使用情况
+type NonceReport struct {
+	Network    models.Network             `json:"network"`
+	Address    string                     `json:"address"`
+	ChainNonce uint64                     `json:"chain_nonce"` // 链上下一个将被执行的 nonce
+	NextNonce  uint64                     `json:"next_nonce"`  // 本地下一个待分配的 nonce
+	Gaps       []uint64                   `json:"gaps"`        // 已分配但没有在途交易的 nonce
+	Stuck      []models.CryptoTransaction `json:"stuck"`       // 长时间未被打包的在途交易
+}
+
+func NewNonceManager(db *gorm.DB, chains Chains, clock config.Clock) *NonceManager {
+	return &NonceManager{
+		db:         db,
+		chains:     chains,
+		stuckAfter: defaultStuckAfter,
+		clock:      clock,
+		locks:      make(map[string]*sync.Mutex),
+	}
+}
+
+// usesNonces 判断网络的交易是否按发送方 nonce 排序
+func usesNonces(network models.Network) bool {
+	return network == models.NetworkETH || network == models.NetworkBSC
+}
+
+func (m *NonceManager) accountChain(network models.Network) (AccountChain, error) {
+	if !usesNonces(network) {
+		return nil, fmt.Errorf("network %s does not use nonces", network)
+	}
+	chain, err := m.chains.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	accountChain, ok := chain.(AccountChain)
+	if !ok {
+		return nil, fmt.Errorf("chain for %s does not expose nonces", network)
+	}
+	return accountChain, nil
+}
+
+func (m *NonceManager) addressLock(network models.Network, address string) *sync.Mutex {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	key := string(network) + "/" + address
+	lock, exists := m.locks[key]
+	if !exists {
+		lock = &sync.Mutex{}
+		m.locks[key] = lock
+	}
+	return lock
+}
+
+// Allocate 在调用方事务内分配下一个 nonce，事务回滚时该 nonce 不会被占用
+func (m *NonceManager) Allocate(tx *gorm.DB, network models.Network, address string) (uint64, error) {
+	chain, err := m.accountChain(network)
+	if err != nil {
+		return 0, err
+	}
+
+	lock := m.addressLock(network, address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	var record models.AddressNonce
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("network = ? AND address = ?", network, address).
+		First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		record = models.AddressNonce{Network: network, Address: address}
+	} else if err != nil {
+		return 0, err
+	}
+
+	// 地址可能在系统外发送过交易，以链上和本地记录中较大的为准
+	chainNonce, err := chain.GetNonce(address)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chain nonce: %v", err)
+	}
+	nonce := record.NextNonce
+	if chainNonce > nonce {
+		nonce = chainNonce
+	}
+
+	record.NextNonce = nonce + 1
+	if err := tx.Save(&record).Error; err != nil {
+		return 0, fmt.Errorf("failed to persist nonce: %v", err)
+	}
+	return nonce, nil
+}
+
+// Release 在调用方事务内归还分配后未能发出的 nonce。只有它仍是最近分配的一个时才回退，
+// 否则留下的空洞由 Inspect 报告
+func (m *NonceManager) Release(tx *gorm.DB, network models.Network, address string, nonce uint64) error {
+	lock := m.addressLock(network, address)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return tx.Model(&models.AddressNonce{}).
+		Where("network = ? AND address = ? AND next_nonce = ?", network, address, nonce+1).
+		Update("next_nonce", nonce).Error
+}
+
+// Inspect 检查地址的 nonce 空洞和卡住的交易
+func (m *NonceManager) Inspect(network models.Network, address string) (*NonceReport, error) {
+	chain, err := m.accountChain(network)
+	if err != nil {
+		return nil, err
+	}
+
+	chainNonce, err := chain.GetNonce(address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chain nonce: %v", err)
+	}
+
+	var record models.AddressNonce
+	err = m.db.Where("network = ? AND address = ?", network, address).First(&record).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	var inFlight []models.CryptoTransaction
+	err = m.db.Where("network = ? AND from_address = ? AND type = ? AND nonce >= ? AND status IN ?",
+		network, address, models.TransactionWithdraw, chainNonce, []string{
+			models.CryptoTxStatusCreated,
+			models.CryptoTxStatusBroadcast,
+		}).
+		Order("nonce ASC").
+		Find(&inFlight).Error
+	if err != nil {
+		return nil, err
+	}
+
+	report := &NonceReport{
+		Network:    network,
+		Address:    address,
+		ChainNonce: chainNonce,
+		NextNonce:  record.NextNonce,
+		Gaps:       []uint64{},
+		Stuck:      []models.CryptoTransaction{},
+	}
+
+	// 内部划转等其他交易同样占用发送地址的 nonce，以发件箱中已签名的意图为准
+	var signed []models.BroadcastIntent
+	err = m.db.Where("network = ? AND from_address = ? AND nonce >= ? AND status <> ?",
+		network, address, chainNonce, models.BroadcastIntentFailed).
+		Find(&signed).Error
+	if err != nil {
+		return nil, err
+	}
+
+	used := make(map[uint64]bool, len(inFlight)+len(signed))
+	for _, intent := range signed {
+		used[*intent.Nonce] = true
+	}
+	for _, tx := range inFlight {
+		used[*tx.Nonce] = true
+		if m.clock.Now().Sub(tx.CreatedAt) >= m.stuckAfter {
+			report.Stuck = append(report.Stuck, tx)
+		}
+	}
+	for nonce := chainNonce; nonce < record.NextNonce; nonce++ {
+		if !used[nonce] {
+			report.Gaps = append(report.Gaps, nonce)
+		}
+	}
+
+	return report, nil
+}
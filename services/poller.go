@@ -0,0 +1,44 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// poller 按固定间隔执行任务，供后台 worker 复用
+type poller struct {
+	interval time.Duration
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newPoller(interval time.Duration) *poller {
+	return &poller{
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+func (p *poller) run(name string, fn func() error) {
+	go func() {
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				if err := fn(); err != nil {
+					log.Printf("%s: %v", name, err)
+				}
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台轮询
+func (p *poller) Stop() {
+	p.stopOnce.Do(func() { close(p.stop) })
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"github.com/shopspring/decimal"
+	"gorm.io/gorm"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrPriceUnavailable 在指定时间之前没有记录过该符号的价格
+var ErrPriceUnavailable = errors.New("price unavailable")
+
+// 组合持仓的类型
+const (
+	HoldingKindCrypto = "crypto"
+	HoldingKindFiat   = "fiat"
+)
+
+// PriceSource 价格源，返回各符号当前的美元价格，不支持的符号不出现在结果中
+type PriceSource interface {
+	Name() string
+	Prices(symbols []string) (map[string]decimal.Decimal, error)
+}
+
+// FilePriceSource 从 JSON 文件读取价格，文件内容为符号到美元价格的映射，每次刷新时重新读取
+type FilePriceSource struct {
+	path string
+}
+
+func NewFilePriceSource(path string) *FilePriceSource {
+	return &FilePriceSource{path: path}
+}
+
+func (s *FilePriceSource) Name() string {
+	return "file"
+}
+
+func (s *FilePriceSource) Prices(symbols []string) (map[string]decimal.Decimal, error) {
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price file: %v", err)
+	}
+	var all map[string]decimal.Decimal
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse price file: %v", err)
+	}
+
+	prices := make(map[string]decimal.Decimal, len(symbols))
+	for symbol, price := range all {
+		symbol = strings.ToUpper(symbol)
+		for _, wanted := range symbols {
+			if wanted == symbol {
+				prices[symbol] = price
+			}
+		}
+	}
+	return prices, nil
+}
+
+// PriceOracle 保存资产和法币的美元价格历史，为交易估值并按报告货币汇总用户资产。
+// 价格来自可替换的价格源，也可以通过接口直接录入（包括补录历史价格）
+type PriceOracle struct {
+	db     *gorm.DB
+	source PriceSource
+	cfg    *config.CryptoConfig
+
+	*poller
+}
+
+// NewPriceOracle 创建价格预言机，source 为 nil 时只使用录入的价格
+func NewPriceOracle(db *gorm.DB, source PriceSource, cfg *config.CryptoConfig) *PriceOracle {
+	return &PriceOracle{
+		db:     db,
+		source: source,
+		cfg:    cfg,
+		poller: newPoller(cfg.Pricing.Interval),
+	}
+}
+
+// Now 返回预言机时钟的当前时间，作为价格查询的默认时间
+func (o *PriceOracle) Now() time.Time {
+	return o.cfg.Clock.Now()
+}
+
+// Start 启动定期刷新，没有价格源时不启动
+func (o *PriceOracle) Start() {
+	if o.source == nil {
+		return
+	}
+	o.run("price oracle", o.Refresh)
+}
+
+// Refresh 从价格源拉取所有需要的价格并保存
+func (o *PriceOracle) Refresh() error {
+	if o.source == nil {
+		return fmt.Errorf("no price source configured")
+	}
+
+	symbols, err := o.symbols()
+	if err != nil {
+		return err
+	}
+	prices, err := o.source.Prices(symbols)
+	if err != nil {
+		return fmt.Errorf("failed to fetch prices: %v", err)
+	}
+
+	now := o.cfg.Clock.Now()
+	for symbol, price := range prices {
+		if _, err := o.RecordPrice(symbol, price, now, o.source.Name()); err != nil {
+			log.Printf("price oracle: record %s: %v", symbol, err)
+		}
+	}
+	return nil
+}
+
+// symbols 返回需要报价的符号：已注册的资产、配置的法币以及法币钱包使用的货币
+func (o *PriceOracle) symbols() ([]string, error) {
+	var assets, currencies []string
+	if err := o.db.Model(&models.Asset{}).Distinct("symbol").Pluck("symbol", &assets).Error; err != nil {
+		return nil, err
+	}
+	if err := o.db.Model(&models.Wallet{}).Distinct("currency").Pluck("currency", &currencies).Error; err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, group := range [][]string{assets, o.cfg.Pricing.Currencies, currencies} {
+		for _, symbol := range group {
+			symbol = strings.ToUpper(symbol)
+			if symbol == models.PriceCurrency || seen[symbol] {
+				continue
+			}
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return symbols, nil
+}
+
+// RecordPrice 保存一条美元价格，observedAt 为零值时取当前时间
+func (o *PriceOracle) RecordPrice(symbol string, price decimal.Decimal, observedAt time.Time, source string) (*models.Price, error) {
+	symbol = strings.ToUpper(symbol)
+	if symbol == "" {
+		return nil, fmt.Errorf("symbol is required")
+	}
+	if symbol == models.PriceCurrency {
+		return nil, fmt.Errorf("%s is the pricing currency", models.PriceCurrency)
+	}
+	if !price.IsPositive() {
+		return nil, fmt.Errorf("price must be positive")
+	}
+	if observedAt.IsZero() {
+		observedAt = o.cfg.Clock.Now()
+	}
+
+	record := &models.Price{
+		Symbol:     symbol,
+		Price:      price,
+		Source:     source,
+		ObservedAt: observedAt,
+	}
+	if err := o.db.Create(record).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// PriceAt 返回 at 时刻（含）之前最近一次观测到的美元价格
+func (o *PriceOracle) PriceAt(symbol string, at time.Time) (*models.Price, error) {
+	return priceAt(o.db, symbol, at)
+}
+
+// GetPriceHistory 返回时间范围内的价格历史，按观测时间升序
+func (o *PriceOracle) GetPriceHistory(symbol string, startTime, endTime time.Time) ([]models.Price, error) {
+	var prices []models.Price
+	err := o.db.Where("symbol = ? AND observed_at BETWEEN ? AND ?", strings.ToUpper(symbol), startTime, endTime).
+		Order("observed_at ASC").
+		Find(&prices).Error
+	return prices, err
+}
+
+// Convert 按 at 时刻的价格将金额从一种资产或货币换算为另一种，经由美元交叉换算
+func (o *PriceOracle) Convert(amount decimal.Decimal, from, to string, at time.Time) (decimal.Decimal, error) {
+	rate, err := o.rate(from, to, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return amount.Mul(rate), nil
+}
+
+// rate 返回 1 单位 from 以 to 计的价格
+func (o *PriceOracle) rate(from, to string, at time.Time) (decimal.Decimal, error) {
+	fromPrice, err := o.PriceAt(from, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	toPrice, err := o.PriceAt(to, at)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	return fromPrice.Price.Div(toPrice.Price), nil
+}
+
+// priceAt 查询 at 时刻之前最近的价格，美元本身的价格恒为 1
+func priceAt(db *gorm.DB, symbol string, at time.Time) (*models.Price, error) {
+	symbol = strings.ToUpper(symbol)
+	if symbol == models.PriceCurrency {
+		return &models.Price{Symbol: symbol, Price: decimal.NewFromInt(1), ObservedAt: at}, nil
+	}
+
+	var price models.Price
+	err := db.Where("symbol = ? AND observed_at <= ?", symbol, at).
+		Order("observed_at DESC").
+		First(&price).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("%w: %s at %s", ErrPriceUnavailable, symbol, at.Format(time.RFC3339))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &price, nil
+}
+
+// valueTransaction 按记录创建时（数据库时钟）的最新价格为交易估值。没有价格时不估值，
+// 估值失败不影响资金流程
+func valueTransaction(db *gorm.DB, record *models.CryptoTransaction) {
+	symbol := record.Asset
+	if symbol == "" {
+		symbol = record.Network.NativeSymbol()
+	}
+
+	price, err := priceAt(db, symbol, db.NowFunc())
+	if err != nil {
+		if !errors.Is(err, ErrPriceUnavailable) {
+			log.Printf("price oracle: value transaction %s: %v", record.TxHash, err)
+		}
+		return
+	}
+
+	record.FiatCurrency = models.PriceCurrency
+	record.FiatPrice = price.Price
+	record.FiatValue = price.Price.Mul(decimal.NewFromFloat(record.Amount))
+	record.PricedAt = &price.ObservedAt
+}
+
+// PortfolioHolding 组合中的一项持仓，Price 和 Value 以报告货币计，没有可用价格时 Priced 为 false
+type PortfolioHolding struct {
+	Kind     string          `json:"kind"`
+	WalletID uint            `json:"wallet_id"`
+	Network  models.Network  `json:"network,omitempty"`
+	Asset    string          `json:"asset"`
+	Amount   decimal.Decimal `json:"amount"`
+	Price    decimal.Decimal `json:"price"`
+	Value    decimal.Decimal `json:"value"`
+	Priced   bool            `json:"priced"`
+}
+
+// Portfolio 用户所有加密货币钱包和法币钱包按报告货币汇总的价值
+type Portfolio struct {
+	UserID   uint               `json:"user_id"`
+	Currency string             `json:"currency"`
+	Total    decimal.Decimal    `json:"total"`
+	Holdings []PortfolioHolding `json:"holdings"`
+	ValuedAt time.Time          `json:"valued_at"`
+}
+
+// GetPortfolio 汇总用户的全部持仓并按当前价格换算为报告货币，没有价格的持仓不计入总额
+func (o *PriceOracle) GetPortfolio(userID uint, currency string) (*Portfolio, error) {
+	currency = strings.ToUpper(currency)
+	now := o.cfg.Clock.Now()
+	quote, err := o.PriceAt(currency, now)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported reporting currency: %v", err)
+	}
+
+	holdings, err := o.holdings(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	portfolio := &Portfolio{
+		UserID:   userID,
+		Currency: currency,
+		Total:    decimal.Zero,
+		Holdings: holdings,
+		ValuedAt: now,
+	}
+	for i := range holdings {
+		price, err := o.PriceAt(holdings[i].Asset, now)
+		if errors.Is(err, ErrPriceUnavailable) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		holdings[i].Price = price.Price.Div(quote.Price)
+		holdings[i].Value = holdings[i].Amount.Mul(holdings[i].Price)
+		holdings[i].Priced = true
+		portfolio.Total = portfolio.Total.Add(holdings[i].Value)
+	}
+	return portfolio, nil
+}
+
+// holdings 返回用户各钱包的余额：加密货币钱包的原生币和代币，以及各法币钱包
+func (o *PriceOracle) holdings(userID uint) ([]PortfolioHolding, error) {
+	var cryptoWallets []models.CryptoWallet
+	if err := o.db.Where("user_id = ?", userID).Order("id ASC").Find(&cryptoWallets).Error; err != nil {
+		return nil, err
+	}
+
+	var holdings []PortfolioHolding
+	for _, wallet := range cryptoWallets {
+		holdings = append(holdings, PortfolioHolding{
+			Kind:     HoldingKindCrypto,
+			WalletID: wallet.ID,
+			Network:  wallet.Network,
+			Asset:    wallet.Network.NativeSymbol(),
+			Amount:   decimal.NewFromFloat(wallet.Balance),
+		})
+
+		var tokens []models.CryptoWalletBalance
+		if err := o.db.Where("wallet_id = ?", wallet.ID).Order("asset ASC").Find(&tokens).Error; err != nil {
+			return nil, err
+		}
+		for _, token := range tokens {
+			holdings = append(holdings, PortfolioHolding{
+				Kind:     HoldingKindCrypto,
+				WalletID: wallet.ID,
+				Network:  wallet.Network,
+				Asset:    token.Asset,
+				Amount:   decimal.NewFromFloat(token.Balance),
+			})
+		}
+	}
+
+	var fiatWallets []models.Wallet
+	if err := o.db.Where("user_id = ?", userID).Order("id ASC").Find(&fiatWallets).Error; err != nil {
+		return nil, err
+	}
+	for _, wallet := range fiatWallets {
+		holdings = append(holdings, PortfolioHolding{
+			Kind:     HoldingKindFiat,
+			WalletID: wallet.ID,
+			Asset:    strings.ToUpper(wallet.Currency),
+			Amount:   wallet.Balance,
+		})
+	}
+	return holdings, nil
+}
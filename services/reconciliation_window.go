@@ -0,0 +1,254 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"math/big"
+	"time"
+)
+
+// maxBlockClockSkew 区块时间可能超前于本地时钟的最大值（比特币允许超前两小时），
+// 查询对账期间的链上历史时时间范围两端需包含这部分
+const maxBlockClockSkew = 2 * time.Hour
+
+// reconciliationWindow 对账期间，按区块范围或时间范围界定
+type reconciliationWindow struct {
+	byBlock              bool
+	startBlock, endBlock uint64
+	startTime, endTime   time.Time
+}
+
+// resolve 补全期间的另一种表示：按区块对账时取起止区块的出块时间，
+// 按时间对账时取 endTime 或之前的最后一个区块，用于判断系统记录的交易是否在期末之后才上链
+func (w *reconciliationWindow) resolve(chain Blockchain) error {
+	if !w.byBlock {
+		endBlock, err := blockAtTime(chain, w.endTime)
+		if err != nil {
+			return fmt.Errorf("failed to find the block at %s: %v", w.endTime, err)
+		}
+		w.endBlock = endBlock
+		return nil
+	}
+
+	if tip := chain.CurrentBlock(); w.endBlock > tip {
+		return fmt.Errorf("end block %d is beyond the chain tip %d", w.endBlock, tip)
+	}
+	var err error
+	if w.startTime, err = chain.GetBlockTime(w.startBlock); err != nil {
+		return fmt.Errorf("failed to get block %d: %v", w.startBlock, err)
+	}
+	if w.endTime, err = chain.GetBlockTime(w.endBlock); err != nil {
+		return fmt.Errorf("failed to get block %d: %v", w.endBlock, err)
+	}
+	return nil
+}
+
+// blockAtTime 二分查找 t 或之前的最后一个区块，t 早于所有区块时返回创世区块
+func blockAtTime(chain Blockchain, t time.Time) (uint64, error) {
+	low, high := uint64(0), chain.CurrentBlock()+1
+	for low < high {
+		mid := low + (high-low)/2
+		blockTime, err := chain.GetBlockTime(mid)
+		if err != nil {
+			return 0, err
+		}
+		if blockTime.After(t) {
+			high = mid
+		} else {
+			low = mid + 1
+		}
+	}
+	if low == 0 {
+		return 0, nil
+	}
+	return low - 1, nil
+}
+
+// historyRange 返回查询链上历史的时间范围，只覆盖对账期间。区块时间不严格递增，
+// 且节点对时间边界的处理不一，两端放宽 maxBlockClockSkew，再由 includes 精确筛选
+func (w *reconciliationWindow) historyRange() (time.Time, time.Time) {
+	return w.startTime.Add(-maxBlockClockSkew), w.endTime.Add(maxBlockClockSkew)
+}
+
+// systemTransactions 返回期间内的系统交易：按区块对账时按所在区块筛选（未上链的记录区块号为 0），
+// 按时间对账时按创建时间筛选
+func (w *reconciliationWindow) systemTransactions(db *gorm.DB, walletID uint, assets []string) ([]models.CryptoTransaction, error) {
+	query := db.Where("wallet_id = ? AND asset IN ?", walletID, assets)
+	if w.byBlock {
+		query = query.Where("block_number > 0 AND block_number BETWEEN ? AND ?", w.startBlock, w.endBlock)
+	} else {
+		query = query.Where("created_at BETWEEN ? AND ?", w.startTime, w.endTime)
+	}
+	var transactions []models.CryptoTransaction
+	if err := query.Find(&transactions).Error; err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// includes 判断链上交易是否属于期间：按区块对账时须在范围内上链，按时间对账时按交易时间判断
+func (w *reconciliationWindow) includes(tx *BlockchainTransaction) bool {
+	if w.byBlock {
+		return tx.BlockNumber > 0 && tx.BlockNumber >= w.startBlock && tx.BlockNumber <= w.endBlock
+	}
+	return !tx.Timestamp.Before(w.startTime) && !tx.Timestamp.After(w.endTime)
+}
+
+// minedBy 判断交易在期初（closing 为 false）或期末是否已上链
+func (w *reconciliationWindow) minedBy(tx *BlockchainTransaction, closing bool) bool {
+	if tx.BlockNumber == 0 {
+		return false
+	}
+	if w.byBlock {
+		if closing {
+			return tx.BlockNumber <= w.endBlock
+		}
+		return tx.BlockNumber < w.startBlock
+	}
+	if closing {
+		return !tx.Timestamp.After(w.endTime)
+	}
+	return tx.Timestamp.Before(w.startTime)
+}
+
+// balance 返回地址在期初（closing 为 false）或期末的链上资产余额。
+// 按区块对账时期初为 startBlock 的前一个区块，从创世区块开始的期间期初余额为零
+func (w *reconciliationWindow) balance(chain Blockchain, asset *models.Asset, address string, closing bool) (*big.Int, error) {
+	var tokenChain TokenChain
+	if !asset.IsNative() {
+		var ok bool
+		if tokenChain, ok = chain.(TokenChain); !ok {
+			return nil, fmt.Errorf("network %s does not support tokens", asset.Network)
+		}
+	}
+
+	if !w.byBlock {
+		t := w.startTime.Add(-time.Nanosecond)
+		if closing {
+			t = w.endTime
+		}
+		if tokenChain != nil {
+			return tokenChain.GetTokenBalanceAtTime(asset.ContractAddress, address, t)
+		}
+		return chain.GetAddressBalanceAtTime(address, t)
+	}
+
+	number := w.endBlock
+	if !closing {
+		if w.startBlock == 0 {
+			return big.NewInt(0), nil
+		}
+		number = w.startBlock - 1
+	}
+	if tokenChain != nil {
+		return tokenChain.GetTokenBalanceAt(asset.ContractAddress, address, number)
+	}
+	return chain.GetAddressBalanceAt(address, number)
+}
+
+// lateChecker 返回判断交易是否在期末之后才上链（或尚未上链）的函数。
+// 期间内的链上历史中没有的交易按系统记录的区块号判断，没有区块号的（在途或早期记录）向节点查询
+func (w *reconciliationWindow) lateChecker(chain Blockchain, history []*BlockchainTransaction) func(txHash string, recorded uint64) (bool, error) {
+	byHash := make(map[string]*BlockchainTransaction, len(history))
+	for _, tx := range history {
+		byHash[tx.Hash] = tx
+	}
+	return func(txHash string, recorded uint64) (bool, error) {
+		tx, exists := byHash[txHash]
+		if !exists {
+			if recorded > 0 {
+				return recorded > w.endBlock, nil
+			}
+			var err error
+			tx, err = chain.GetTransaction(txHash)
+			if errors.Is(err, ErrTransactionNotFound) {
+				return true, nil
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+		return !w.minedBy(tx, true), nil
+	}
+}
+
+// lateSystemChange 返回 late 判定的交易对系统余额的影响：已入账的充值，
+// 以及从钱包地址发出、尚未退款的提现（含以原生币计的手续费）。热钱包代发的提现与内部差额相互抵消，不需要扣除
+func (s *CryptoReconciliationService) lateSystemChange(wallet *models.CryptoWallet, asset *models.Asset, addresses []string, late func(string, uint64) (bool, error)) (float64, error) {
+	var records []models.CryptoTransaction
+	if err := s.db.Where("wallet_id = ? AND tx_hash <> ? AND type IN ? AND status IN ?",
+		wallet.ID, "", []models.TransactionType{models.TransactionDeposit, models.TransactionWithdraw},
+		[]string{models.CryptoTxStatusCreated, models.CryptoTxStatusBroadcast, models.CryptoTxStatusConfirming, models.CryptoTxStatusCompleted}).
+		Find(&records).Error; err != nil {
+		return 0, err
+	}
+
+	change := 0.0
+	for _, record := range records {
+		isLate, err := late(record.TxHash, record.BlockNumber)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get transaction %s: %v", record.TxHash, err)
+		}
+		if !isLate {
+			continue
+		}
+		sameAsset := record.Asset == asset.Symbol || (asset.IsNative() && record.Asset == "")
+		switch record.Type {
+		case models.TransactionDeposit:
+			// 退回确认中的充值尚未入账
+			if sameAsset && record.Status == models.CryptoTxStatusCompleted {
+				change += record.Amount
+			}
+		case models.TransactionWithdraw:
+			if !containsAddress(addresses, record.FromAddress) {
+				continue
+			}
+			if sameAsset {
+				change -= record.Amount
+			}
+			if asset.IsNative() {
+				change -= record.Fee
+			}
+		}
+	}
+	return change, nil
+}
+
+// lateInternalAdjustment 返回 late 判定的内部划转在 internalAdjustment 中的部分
+func (s *CryptoReconciliationService) lateInternalAdjustment(asset *models.Asset, assets, addresses []string, late func(string, uint64) (bool, error)) (float64, error) {
+	var transfers []models.InternalTransfer
+	if err := s.db.Where("(from_address IN ? OR to_address IN ?) AND status IN ? AND tx_hash <> ?",
+		addresses, addresses, onChainTransferStatuses, "").
+		Find(&transfers).Error; err != nil {
+		return 0, err
+	}
+
+	adjustment := 0.0
+	for _, transfer := range transfers {
+		isLate, err := late(transfer.TxHash, transfer.BlockNumber)
+		if err != nil {
+			return 0, fmt.Errorf("failed to get transaction %s: %v", transfer.TxHash, err)
+		}
+		if !isLate {
+			continue
+		}
+		sameAsset := false
+		for _, symbol := range assets {
+			sameAsset = sameAsset || transfer.Asset == symbol
+		}
+		if sameAsset && containsAddress(addresses, transfer.ToAddress) {
+			adjustment += transfer.Amount
+		}
+		if containsAddress(addresses, transfer.FromAddress) {
+			if sameAsset {
+				adjustment -= transfer.Amount
+			}
+			if asset.IsNative() {
+				adjustment -= transfer.Fee
+			}
+		}
+	}
+	return adjustment, nil
+}
@@ -0,0 +1,378 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"log"
+	"time"
+)
+
+// reorgDepth 重组检查覆盖的区块深度，链上查不到的交易持续这么多区块后才视为已丢弃
+const reorgDepth = 12
+
+// ReorgDetector 检查最近区块内已记录的交易，发现链重组后回滚或重新确认
+type ReorgDetector struct {
+	db     *gorm.DB
+	chains Chains
+	depth  uint64 // 只检查距链顶 depth 个区块内的交易
+
+	*poller
+}
+
+func NewReorgDetector(db *gorm.DB, chains Chains, interval time.Duration) *ReorgDetector {
+	return &ReorgDetector{
+		db:     db,
+		chains: chains,
+		depth:  reorgDepth,
+		poller: newPoller(interval),
+	}
+}
+
+// Start 启动后台检查
+func (d *ReorgDetector) Start() {
+	d.run("reorg detector", d.Check)
+}
+
+// Check 对比最近交易记录的区块哈希与各网络当前的规范链，
+// 单个网络或交易出错时记录日志后继续检查其余的
+func (d *ReorgDetector) Check() error {
+	for network, chain := range d.chains {
+		if err := d.checkNetwork(network, chain); err != nil {
+			log.Printf("reorg detector: %s: %v", network, err)
+		}
+	}
+	return nil
+}
+
+func (d *ReorgDetector) checkNetwork(network models.Network, chain Blockchain) error {
+	tip := chain.CurrentBlock()
+	var fromBlock uint64
+	if tip > d.depth {
+		fromBlock = tip - d.depth
+	}
+
+	var records []models.CryptoTransaction
+	// block_number 为 0 表示尚未被跟踪到区块信息的在途提现，查不到的交易在回滚或退款前持续检查，
+	// 退回确认中的充值在再次入账前持续检查
+	err := d.db.Where("network = ? AND tx_hash <> '' AND (block_number > ? OR block_number = 0 OR missing_since_block > 0 OR (type = ? AND status = ?)) AND type IN ? AND status IN ?",
+		network,
+		fromBlock,
+		models.TransactionDeposit,
+		models.CryptoTxStatusConfirming,
+		[]models.TransactionType{models.TransactionDeposit, models.TransactionWithdraw},
+		[]string{
+			models.CryptoTxStatusBroadcast,
+			models.CryptoTxStatusConfirming,
+			models.CryptoTxStatusCompleted,
+		}).Find(&records).Error
+	if err != nil {
+		return fmt.Errorf("failed to load recent %s transactions: %v", network, err)
+	}
+
+	for i := range records {
+		if err := d.checkTransaction(chain, &records[i]); err != nil {
+			log.Printf("reorg detector: tx %s: %v", records[i].TxHash, err)
+		}
+	}
+
+	// 已完成的内部划转，在途的由 TreasuryService.Refresh 跟踪
+	var transfers []models.InternalTransfer
+	err = d.db.Where("network = ? AND tx_hash <> '' AND block_number > ? AND status = ?",
+		network, fromBlock, models.CryptoTxStatusCompleted).
+		Find(&transfers).Error
+	if err != nil {
+		return fmt.Errorf("failed to load recent %s internal transfers: %v", network, err)
+	}
+	for i := range transfers {
+		if err := d.checkTransfer(chain, &transfers[i]); err != nil {
+			log.Printf("reorg detector: transfer %s: %v", transfers[i].TxHash, err)
+		}
+	}
+	return nil
+}
+
+// checkTransfer 已完成的划转所在区块被重组后退回 broadcast，由 TreasuryService.Refresh 重新跟踪：
+// 查不到时重新发送，重新打包且确认足够后再次完成
+func (d *ReorgDetector) checkTransfer(chain Blockchain, transfer *models.InternalTransfer) error {
+	updates := map[string]interface{}{"status": models.CryptoTxStatusBroadcast}
+	chainTx, err := chain.GetTransaction(transfer.TxHash)
+	switch {
+	case errors.Is(err, ErrTransactionNotFound):
+		hash, err := chain.GetBlockHash(transfer.BlockNumber)
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %v", transfer.BlockNumber, err)
+		}
+		if hash == transfer.BlockHash {
+			return fmt.Errorf("transaction not found but block %d is still canonical", transfer.BlockNumber)
+		}
+		updates["block_number"] = 0
+		updates["block_hash"] = ""
+	case err != nil:
+		return fmt.Errorf("failed to get transaction: %v", err)
+	case chainTx.BlockHash == transfer.BlockHash:
+		return nil
+	default:
+		updates["block_number"] = chainTx.BlockNumber
+		updates["block_hash"] = chainTx.BlockHash
+	}
+
+	return d.db.Model(&models.InternalTransfer{}).
+		Where("id = ? AND status = ? AND block_hash = ?", transfer.ID, models.CryptoTxStatusCompleted, transfer.BlockHash).
+		Updates(updates).Error
+}
+
+func (d *ReorgDetector) checkTransaction(chain Blockchain, record *models.CryptoTransaction) error {
+	chainTx, err := chain.GetTransaction(record.TxHash)
+	if errors.Is(err, ErrTransactionNotFound) {
+		if record.Type == models.TransactionDeposit {
+			return d.missingDeposit(chain, record)
+		}
+		return d.missingWithdrawal(chain, record)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get transaction: %v", err)
+	}
+	if record.MissingSinceBlock > 0 {
+		if err := d.setMissingSince(record, 0); err != nil {
+			return err
+		}
+	}
+
+	if record.Type == models.TransactionDeposit && record.Status == models.CryptoTxStatusConfirming {
+		return d.reconfirmDeposit(record, chainTx)
+	}
+
+	if record.BlockHash == "" || chainTx.BlockHash == record.BlockHash {
+		return nil
+	}
+
+	// 充值被重新打包后确认数低于策略要求时退回确认中
+	if record.Type == models.TransactionDeposit &&
+		(chainTx.Status != "success" || chainTx.Confirmations < record.RequiredConfirmations) {
+		return d.unconfirmDeposit(record, chainTx)
+	}
+
+	// 交易被重新打包进新的区块，更新区块信息，提现重新进入确认中
+	updates := map[string]interface{}{
+		"block_number":  chainTx.BlockNumber,
+		"block_hash":    chainTx.BlockHash,
+		"confirmations": chainTx.Confirmations,
+	}
+	if record.Type == models.TransactionWithdraw {
+		updates["status"] = models.CryptoTxStatusConfirming
+	}
+	return d.db.Model(&models.CryptoTransaction{}).
+		Where("id = ? AND block_hash = ?", record.ID, record.BlockHash).
+		Updates(updates).Error
+}
+
+// missingDeposit 处理链上查不到的充值。节点滞后或切换节点时已上链的交易也可能暂时查不到，
+// 只有入账时的区块已不在规范链上，且持续 depth 个区块查不到时才回滚
+func (d *ReorgDetector) missingDeposit(chain Blockchain, record *models.CryptoTransaction) error {
+	if record.BlockHash != "" {
+		hash, err := chain.GetBlockHash(record.BlockNumber)
+		if err != nil {
+			return fmt.Errorf("failed to get block %d: %v", record.BlockNumber, err)
+		}
+		if hash == record.BlockHash {
+			return fmt.Errorf("transaction not found but block %d is still canonical", record.BlockNumber)
+		}
+	}
+
+	tip := chain.CurrentBlock()
+	if record.MissingSinceBlock == 0 {
+		return d.setMissingSince(record, tip)
+	}
+	if tip < record.MissingSinceBlock+d.depth {
+		return nil
+	}
+	return d.clawbackDeposit(record)
+}
+
+// unconfirmDeposit 扣回被重新打包后确认数不足的充值，退回确认中，确认足够后由 reconfirmDeposit 再次入账
+func (d *ReorgDetector) unconfirmDeposit(record *models.CryptoTransaction, chainTx *BlockchainTransaction) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.CryptoTransaction{}).
+			Where("id = ? AND status = ? AND block_hash = ?", record.ID, models.CryptoTxStatusCompleted, record.BlockHash).
+			Updates(map[string]interface{}{
+				"status":        models.CryptoTxStatusConfirming,
+				"block_number":  chainTx.BlockNumber,
+				"block_hash":    chainTx.BlockHash,
+				"confirmations": chainTx.Confirmations,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if err := adjustBalance(tx, record.WalletID, record.Network, record.Asset, -record.Amount); err != nil {
+			return fmt.Errorf("failed to claw back balance: %v", err)
+		}
+
+		alert := &models.CryptoAlert{
+			WalletID:      record.WalletID,
+			TransactionID: record.ID,
+			TxHash:        record.TxHash,
+			Type:          models.AlertTypeReorgReconfirm,
+			Message: fmt.Sprintf("deposit of %v credited at block %d was re-included with %d/%d confirmations and is held until it confirms again",
+				record.Amount, record.BlockNumber, chainTx.Confirmations, record.RequiredConfirmations),
+		}
+		return tx.Create(alert).Error
+	})
+}
+
+// reconfirmDeposit 跟踪退回确认中的充值，确认数再次达到要求时重新入账，重新执行失败时回滚
+func (d *ReorgDetector) reconfirmDeposit(record *models.CryptoTransaction, chainTx *BlockchainTransaction) error {
+	if chainTx.Status == "failed" {
+		return d.clawbackDeposit(record)
+	}
+
+	updates := map[string]interface{}{
+		"block_number":  chainTx.BlockNumber,
+		"block_hash":    chainTx.BlockHash,
+		"confirmations": chainTx.Confirmations,
+	}
+	confirmed := chainTx.Status == "success" && chainTx.Confirmations >= record.RequiredConfirmations
+	if confirmed {
+		updates["status"] = models.CryptoTxStatusCompleted
+	}
+
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.CryptoTransaction{}).
+			Where("id = ? AND status = ?", record.ID, models.CryptoTxStatusConfirming).
+			Updates(updates)
+		if result.Error != nil || result.RowsAffected == 0 || !confirmed {
+			return result.Error
+		}
+		if err := adjustBalance(tx, record.WalletID, record.Network, record.Asset, record.Amount); err != nil {
+			return fmt.Errorf("failed to credit balance: %v", err)
+		}
+		return nil
+	})
+}
+
+// clawbackDeposit 回滚被重组丢弃的充值并生成告警，已入账的扣回入账金额，退回确认中的已经扣回
+func (d *ReorgDetector) clawbackDeposit(record *models.CryptoTransaction) error {
+	credited := record.Status == models.CryptoTxStatusCompleted
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.CryptoTransaction{}).
+			Where("id = ? AND status = ?", record.ID, record.Status).
+			Updates(map[string]interface{}{
+				"status":         models.CryptoTxStatusReversed,
+				"failure_reason": "block orphaned by chain reorganization",
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return nil
+		}
+
+		if credited {
+			if err := adjustBalance(tx, record.WalletID, record.Network, record.Asset, -record.Amount); err != nil {
+				return fmt.Errorf("failed to claw back balance: %v", err)
+			}
+
+			reversal := &models.CryptoTransaction{
+				WalletID:         record.WalletID,
+				Type:             models.TransactionReversal,
+				Network:          record.Network,
+				Asset:            record.Asset,
+				FromAddress:      record.ToAddress,
+				ToAddress:        record.FromAddress,
+				Amount:           record.Amount,
+				Status:           models.CryptoTxStatusCompleted,
+				RefTransactionID: record.ID,
+			}
+			valueTransaction(tx, reversal)
+			if err := tx.Create(reversal).Error; err != nil {
+				return err
+			}
+		}
+
+		alert := &models.CryptoAlert{
+			WalletID:      record.WalletID,
+			TransactionID: record.ID,
+			TxHash:        record.TxHash,
+			Type:          models.AlertTypeReorgClawback,
+			Message: fmt.Sprintf("deposit of %v credited at block %d was orphaned and clawed back",
+				record.Amount, record.BlockNumber),
+		}
+		return tx.Create(alert).Error
+	})
+}
+
+// missingWithdrawal 处理链上查不到的提现。节点滞后或切换节点时在途交易也可能查不到，
+// 被重组丢弃的交易也仍可能重新上链，已签名的交易依然有效，立即退款可能导致重复出款。
+// 因此先重新发送保存的签名交易；只有无法重新发送（nonce 已被占用或输入已被花费）
+// 且持续 depth 个区块查不到时才退款
+func (d *ReorgDetector) missingWithdrawal(chain Blockchain, record *models.CryptoTransaction) error {
+	resendErr := resendSigned(d.db, chain, record.TxHash)
+	if resendErr == nil {
+		// 交易重新进入内存池
+		if record.MissingSinceBlock > 0 {
+			return d.setMissingSince(record, 0)
+		}
+		return nil
+	}
+
+	tip := chain.CurrentBlock()
+	if record.MissingSinceBlock == 0 {
+		if err := d.setMissingSince(record, tip); err != nil {
+			return err
+		}
+		return fmt.Errorf("transaction not found and could not be re-broadcast: %v", resendErr)
+	}
+	if tip < record.MissingSinceBlock+d.depth {
+		return fmt.Errorf("transaction missing since block %d and could not be re-broadcast: %v", record.MissingSinceBlock, resendErr)
+	}
+	return d.refundWithdrawal(record)
+}
+
+// resendSigned 重新发送保存在发件箱中的签名交易，链上已有该交易时视为成功
+func resendSigned(db *gorm.DB, chain Blockchain, txHash string) error {
+	var intent models.BroadcastIntent
+	if err := db.Where("tx_hash = ? AND raw_tx <> ''", txHash).First(&intent).Error; err != nil {
+		return fmt.Errorf("signed transaction not found: %v", err)
+	}
+	if _, err := chain.SendRawTransaction(intent.RawTx); err != nil && !errors.Is(err, ErrTransactionKnown) {
+		return err
+	}
+	return nil
+}
+
+func (d *ReorgDetector) setMissingSince(record *models.CryptoTransaction, block uint64) error {
+	record.MissingSinceBlock = block
+	return d.db.Model(&models.CryptoTransaction{}).
+		Where("id = ?", record.ID).
+		Update("missing_since_block", block).Error
+}
+
+// refundWithdrawal 提现交易已失效且不会再上链，资金未离开钱包，退回余额并生成告警
+func (d *ReorgDetector) refundWithdrawal(record *models.CryptoTransaction) error {
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		// 交易未上链，手续费也未被消耗
+		refunded, err := refundWithdrawal(tx, record, true, "transaction dropped and could not be re-broadcast", []string{
+			models.CryptoTxStatusBroadcast,
+			models.CryptoTxStatusConfirming,
+			models.CryptoTxStatusCompleted,
+		})
+		if err != nil || !refunded {
+			return err
+		}
+
+		alert := &models.CryptoAlert{
+			WalletID:      record.WalletID,
+			TransactionID: record.ID,
+			TxHash:        record.TxHash,
+			Type:          models.AlertTypeReorgRefund,
+			Message: fmt.Sprintf("withdrawal of %v was missing from the chain since block %d, could not be re-broadcast and was refunded",
+				record.Amount, record.MissingSinceBlock),
+		}
+		return tx.Create(alert).Error
+	})
+}
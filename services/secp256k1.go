@@ -0,0 +1,360 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+)
+
+// secp256k1 curve parameters (y² = x³ + 7 over the prime field P)
+var (
+	secp256k1P, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f", 16)
+	secp256k1N, _  = new(big.Int).SetString("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141", 16)
+	secp256k1Gx, _ = new(big.Int).SetString("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798", 16)
+	secp256k1Gy, _ = new(big.Int).SetString("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8", 16)
+
+	secp256k1G     = ecPoint{x: secp256k1Gx, y: secp256k1Gy}
+	secp256k1HalfN = new(big.Int).Rsh(secp256k1N, 1)
+)
+
+var errInvalidSignature = errors.New("invalid signature")
+
+// ecPoint is an affine point of secp256k1, the point at infinity has a nil x
+type ecPoint struct {
+	x, y *big.Int
+}
+
+func (p ecPoint) infinity() bool {
+	return p.x == nil
+}
+
+func (p ecPoint) add(q ecPoint) ecPoint {
+	if p.infinity() {
+		return q
+	}
+	if q.infinity() {
+		return p
+	}
+	if p.x.Cmp(q.x) == 0 {
+		if p.y.Cmp(q.y) != 0 || p.y.Sign() == 0 {
+			return ecPoint{}
+		}
+		return p.double()
+	}
+
+	// λ = (y2 - y1) / (x2 - x1)
+	dx := new(big.Int).Sub(q.x, p.x)
+	dx.Mod(dx, secp256k1P)
+	lambda := new(big.Int).Sub(q.y, p.y)
+	lambda.Mul(lambda, new(big.Int).ModInverse(dx, secp256k1P))
+	lambda.Mod(lambda, secp256k1P)
+	return p.withSlope(lambda, q.x)
+}
+
+func (p ecPoint) double() ecPoint {
+	if p.infinity() || p.y.Sign() == 0 {
+		return ecPoint{}
+	}
+
+	// λ = 3x² / 2y
+	lambda := new(big.Int).Mul(p.x, p.x)
+	lambda.Mul(lambda, big.NewInt(3))
+	lambda.Mul(lambda, new(big.Int).ModInverse(new(big.Int).Lsh(p.y, 1), secp256k1P))
+	lambda.Mod(lambda, secp256k1P)
+	return p.withSlope(lambda, p.x)
+}
+
+// withSlope completes an addition of p and a point with x coordinate qx
+func (p ecPoint) withSlope(lambda, qx *big.Int) ecPoint {
+	x := new(big.Int).Mul(lambda, lambda)
+	x.Sub(x, p.x)
+	x.Sub(x, qx)
+	x.Mod(x, secp256k1P)
+
+	y := new(big.Int).Sub(p.x, x)
+	y.Mul(y, lambda)
+	y.Sub(y, p.y)
+	y.Mod(y, secp256k1P)
+	return ecPoint{x: x, y: y}
+}
+
+func (p ecPoint) mul(k *big.Int) ecPoint {
+	result := ecPoint{}
+	for i := k.BitLen() - 1; i >= 0; i-- {
+		result = result.double()
+		if k.Bit(i) == 1 {
+			result = result.add(p)
+		}
+	}
+	return result
+}
+
+// liftX returns the curve point with the given x coordinate and y parity
+func liftX(x *big.Int, odd bool) (ecPoint, error) {
+	if x.Sign() < 0 || x.Cmp(secp256k1P) >= 0 {
+		return ecPoint{}, fmt.Errorf("invalid point")
+	}
+	// y = (x³ + 7)^((P+1)/4), valid because P ≡ 3 mod 4
+	c := new(big.Int).Exp(x, big.NewInt(3), secp256k1P)
+	c.Add(c, big.NewInt(7))
+	c.Mod(c, secp256k1P)
+	exponent := new(big.Int).Add(secp256k1P, big.NewInt(1))
+	exponent.Rsh(exponent, 2)
+	y := new(big.Int).Exp(c, exponent, secp256k1P)
+	if new(big.Int).Exp(y, big.NewInt(2), secp256k1P).Cmp(c) != 0 {
+		return ecPoint{}, fmt.Errorf("invalid point")
+	}
+	if (y.Bit(0) == 1) != odd {
+		y.Sub(secp256k1P, y)
+	}
+	return ecPoint{x: x, y: y}, nil
+}
+
+// compressed encodes the point as 0x02/0x03 followed by x
+func (p ecPoint) compressed() []byte {
+	encoded := make([]byte, 33)
+	encoded[0] = 0x02 + byte(p.y.Bit(0))
+	p.x.FillBytes(encoded[1:])
+	return encoded
+}
+
+// uncompressed encodes the point as 0x04 followed by x and y
+func (p ecPoint) uncompressed() []byte {
+	encoded := make([]byte, 65)
+	encoded[0] = 0x04
+	p.x.FillBytes(encoded[1:33])
+	p.y.FillBytes(encoded[33:])
+	return encoded
+}
+
+func parseCompressedPoint(data []byte) (ecPoint, error) {
+	if len(data) != 33 || (data[0] != 0x02 && data[0] != 0x03) {
+		return ecPoint{}, fmt.Errorf("invalid compressed public key")
+	}
+	return liftX(new(big.Int).SetBytes(data[1:]), data[0] == 0x03)
+}
+
+// PrivateKey is a secp256k1 private key, the key type of every supported
+// network
+type PrivateKey struct {
+	d      *big.Int
+	public ecPoint
+}
+
+// GeneratePrivateKey creates a random key
+func GeneratePrivateKey() (*PrivateKey, error) {
+	for {
+		var seed [32]byte
+		if _, err := rand.Read(seed[:]); err != nil {
+			return nil, err
+		}
+		if key, err := ParsePrivateKey(seed[:]); err == nil {
+			return key, nil
+		}
+	}
+}
+
+// ParsePrivateKey reads a 32 byte big-endian private key
+func ParsePrivateKey(data []byte) (*PrivateKey, error) {
+	if len(data) != 32 {
+		return nil, fmt.Errorf("private key must be 32 bytes")
+	}
+	d := new(big.Int).SetBytes(data)
+	if d.Sign() == 0 || d.Cmp(secp256k1N) >= 0 {
+		return nil, fmt.Errorf("private key out of range")
+	}
+	return &PrivateKey{d: d, public: secp256k1G.mul(d)}, nil
+}
+
+// Bytes returns the 32 byte big-endian encoding of the key
+func (k *PrivateKey) Bytes() []byte {
+	return k.d.FillBytes(make([]byte, 32))
+}
+
+// Address returns the address the key controls on a network. btc selects
+// the bitcoin network of BTC addresses and is ignored elsewhere.
+func (k *PrivateKey) Address(network models.Network, btc *BitcoinParams) string {
+	if network == models.NetworkBTC {
+		return btc.publicKeyAddress(k.public)
+	}
+	return publicKeyAddress(network, k.public)
+}
+
+// ecdsaSignature is a signature with the recovery id of the nonce point:
+// bit 0 is the parity of its y coordinate, bit 1 set when its x overflowed N
+type ecdsaSignature struct {
+	r, s     *big.Int
+	recovery byte
+}
+
+// sign signs a 32 byte hash with a deterministic nonce (RFC 6979) and
+// returns the low-s form required by Bitcoin and Ethereum
+func (k *PrivateKey) sign(hash []byte) ecdsaSignature {
+	e := new(big.Int).SetBytes(hash)
+	e.Mod(e, secp256k1N)
+
+	nonces := newRFC6979(k.Bytes(), e.FillBytes(make([]byte, 32)))
+	for {
+		nonce := nonces.next()
+		point := secp256k1G.mul(nonce)
+		r := new(big.Int).Mod(point.x, secp256k1N)
+		if r.Sign() == 0 {
+			continue
+		}
+
+		s := new(big.Int).Mul(r, k.d)
+		s.Add(s, e)
+		s.Mul(s, new(big.Int).ModInverse(nonce, secp256k1N))
+		s.Mod(s, secp256k1N)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		recovery := byte(point.y.Bit(0))
+		if point.x.Cmp(secp256k1N) >= 0 {
+			recovery |= 2
+		}
+		if s.Cmp(secp256k1HalfN) > 0 {
+			s.Sub(secp256k1N, s)
+			recovery ^= 1
+		}
+		return ecdsaSignature{r: r, s: s, recovery: recovery}
+	}
+}
+
+// rfc6979 generates the deterministic nonces of RFC 6979 with HMAC-SHA256
+type rfc6979 struct {
+	k, v []byte
+}
+
+func newRFC6979(key, hash []byte) *rfc6979 {
+	g := &rfc6979{k: make([]byte, 32), v: make([]byte, 32)}
+	for i := range g.v {
+		g.v[i] = 0x01
+	}
+	g.k = g.mac(g.v, []byte{0x00}, key, hash)
+	g.v = g.mac(g.v)
+	g.k = g.mac(g.v, []byte{0x01}, key, hash)
+	g.v = g.mac(g.v)
+	return g
+}
+
+func (g *rfc6979) mac(parts ...[]byte) []byte {
+	h := hmac.New(sha256.New, g.k)
+	for _, part := range parts {
+		h.Write(part)
+	}
+	return h.Sum(nil)
+}
+
+// next returns the next candidate nonce in [1, N)
+func (g *rfc6979) next() *big.Int {
+	for {
+		g.v = g.mac(g.v)
+		nonce := new(big.Int).SetBytes(g.v)
+
+		// Prepare the next candidate in case this one is rejected
+		g.k = g.mac(g.v, []byte{0x00})
+		g.v = g.mac(g.v)
+
+		if nonce.Sign() > 0 && nonce.Cmp(secp256k1N) < 0 {
+			return nonce
+		}
+	}
+}
+
+// verifySignature checks a signature of hash against a public key
+func verifySignature(public ecPoint, hash []byte, r, s *big.Int) bool {
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(secp256k1N) >= 0 || s.Cmp(secp256k1N) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(s, secp256k1N)
+	u1 := new(big.Int).Mul(e, w)
+	u1.Mod(u1, secp256k1N)
+	u2 := new(big.Int).Mul(r, w)
+	u2.Mod(u2, secp256k1N)
+
+	point := secp256k1G.mul(u1).add(public.mul(u2))
+	if point.infinity() {
+		return false
+	}
+	return new(big.Int).Mod(point.x, secp256k1N).Cmp(r) == 0
+}
+
+// recoverPublicKey returns the public key that produced a signature of hash
+func recoverPublicKey(hash []byte, sig ecdsaSignature) (ecPoint, error) {
+	if sig.r.Sign() <= 0 || sig.s.Sign() <= 0 || sig.r.Cmp(secp256k1N) >= 0 || sig.s.Cmp(secp256k1N) >= 0 || sig.recovery > 3 {
+		return ecPoint{}, errInvalidSignature
+	}
+	x := new(big.Int).Set(sig.r)
+	if sig.recovery&2 != 0 {
+		x.Add(x, secp256k1N)
+	}
+	point, err := liftX(x, sig.recovery&1 == 1)
+	if err != nil {
+		return ecPoint{}, errInvalidSignature
+	}
+
+	// Q = r⁻¹(sR - eG)
+	e := new(big.Int).SetBytes(hash)
+	e.Neg(e).Mod(e, secp256k1N)
+	rInverse := new(big.Int).ModInverse(sig.r, secp256k1N)
+	public := point.mul(sig.s).add(secp256k1G.mul(e)).mul(rInverse)
+	if public.infinity() {
+		return ecPoint{}, errInvalidSignature
+	}
+	return public, nil
+}
+
+// encodeDER encodes a signature in the DER form used by Bitcoin scripts
+func (sig ecdsaSignature) encodeDER() []byte {
+	integer := func(value *big.Int) []byte {
+		encoded := value.Bytes()
+		if len(encoded) == 0 || encoded[0]&0x80 != 0 {
+			encoded = append([]byte{0x00}, encoded...)
+		}
+		return append([]byte{0x02, byte(len(encoded))}, encoded...)
+	}
+	body := append(integer(sig.r), integer(sig.s)...)
+	return append([]byte{0x30, byte(len(body))}, body...)
+}
+
+// parseDER reads a strictly encoded DER signature (BIP 66)
+func parseDER(data []byte) (*big.Int, *big.Int, error) {
+	if len(data) < 8 || data[0] != 0x30 || int(data[1]) != len(data)-2 {
+		return nil, nil, errInvalidSignature
+	}
+	rest := data[2:]
+	integer := func() (*big.Int, error) {
+		if len(rest) < 2 || rest[0] != 0x02 {
+			return nil, errInvalidSignature
+		}
+		length := int(rest[1])
+		if length == 0 || length > 33 || len(rest) < 2+length {
+			return nil, errInvalidSignature
+		}
+		value := rest[2 : 2+length]
+		if value[0]&0x80 != 0 || (length > 1 && value[0] == 0 && value[1]&0x80 == 0) {
+			return nil, errInvalidSignature
+		}
+		rest = rest[2+length:]
+		return new(big.Int).SetBytes(value), nil
+	}
+	r, err := integer()
+	if err != nil {
+		return nil, nil, err
+	}
+	s, err := integer()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(rest) != 0 {
+		return nil, nil, errInvalidSignature
+	}
+	return r, s, nil
+}
@@ -0,0 +1,240 @@
+package services
+
+import (
+	"crypto/rand"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"math/big"
+)
+
+// memoDigits 共享充值地址模式下分配给钱包的数字备注位数
+const memoDigits = 9
+
+// SharedDepositResult 共享地址充值的处理结果，Transaction 与 Held 二者其一非空
+type SharedDepositResult struct {
+	Transaction *models.CryptoTransaction `json:"transaction,omitempty"` // 已按备注入账
+	Held        *models.UnassignedDeposit `json:"held,omitempty"`        // 备注缺失或未知，等待人工分配
+}
+
+// generateMemo 为网络生成一个未被占用的数字备注
+func generateMemo(tx *gorm.DB, network models.Network) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(memoDigits), nil)
+	for i := 0; i < 10; i++ {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		memo := fmt.Sprintf("%0*d", memoDigits, n)
+
+		var count int64
+		if err := tx.Model(&models.CryptoWallet{}).
+			Where("network = ? AND memo = ?", network, memo).
+			Count(&count).Error; err != nil {
+			return "", err
+		}
+		if count == 0 {
+			return memo, nil
+		}
+	}
+	return "", fmt.Errorf("failed to allocate a unique memo")
+}
+
+// ProcessSharedDeposit 处理转入网络共享充值地址的交易：按备注找到钱包入账，
+// 备注缺失或没有匹配的钱包时放入待分配队列
+func (s *CryptoWalletService) ProcessSharedDeposit(network models.Network, txHash string) (*SharedDepositResult, error) {
+	if !s.cfg.SharedDepositAddress(network) {
+		return nil, fmt.Errorf("network %s does not use a shared deposit address", network)
+	}
+
+	var count int64
+	if err := s.db.Model(&models.CryptoTransaction{}).
+		Where("tx_hash = ? AND status <> ?", txHash, models.CryptoTxStatusReversed).
+		Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count > 0 {
+		return nil, fmt.Errorf("transaction already processed")
+	}
+
+	deposit, err := s.treasury.SystemWallet(network, models.WalletTierDeposit)
+	if err != nil {
+		return nil, err
+	}
+	chain, err := s.chains.Get(network)
+	if err != nil {
+		return nil, err
+	}
+	blockchainTx, err := chain.GetTransaction(txHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %v", err)
+	}
+
+	reason := models.UnassignedReasonMissingMemo
+	if blockchainTx.Memo != "" {
+		var wallet models.CryptoWallet
+		err := s.db.Where("network = ? AND memo = ?", network, blockchainTx.Memo).First(&wallet).Error
+		if err == nil {
+			record, err := s.creditDeposit(&wallet, []string{wallet.Address}, blockchainTx)
+			if err != nil {
+				return nil, err
+			}
+			return &SharedDepositResult{Transaction: record}, nil
+		}
+		if err != gorm.ErrRecordNotFound {
+			return nil, err
+		}
+		reason = models.UnassignedReasonUnknownMemo
+	}
+
+	held, err := s.holdDeposit(&models.CryptoWallet{Network: network, Address: deposit.Address}, blockchainTx, reason)
+	if err != nil {
+		return nil, err
+	}
+	return &SharedDepositResult{Held: held}, nil
+}
+
+// holdDeposit 将无法识别收款钱包的充值放入待分配队列，重复处理同一交易时返回已有记录
+func (s *CryptoWalletService) holdDeposit(shared *models.CryptoWallet, blockchainTx *BlockchainTransaction, reason string) (*models.UnassignedDeposit, error) {
+	asset, _, amount, err := s.depositAmount(shared.Network, []string{shared.Address}, blockchainTx)
+	if err != nil {
+		return nil, err
+	}
+
+	// 与正常入账一样，确认数足够后才进入队列
+	required := s.cfg.Confirmations.Required(shared.Network, asset, amount)
+	if blockchainTx.Confirmations < required {
+		return nil, fmt.Errorf("insufficient confirmations: %d/%d", blockchainTx.Confirmations, required)
+	}
+
+	held := models.UnassignedDeposit{
+		Network:     shared.Network,
+		Asset:       asset,
+		FromAddress: blockchainTx.From,
+		ToAddress:   shared.Address,
+		Amount:      amount,
+		Memo:        blockchainTx.Memo,
+		TxHash:      blockchainTx.Hash,
+		BlockNumber: blockchainTx.BlockNumber,
+		Reason:      reason,
+		Status:      models.UnassignedDepositPending,
+	}
+	if err := s.db.Where("tx_hash = ?", blockchainTx.Hash).FirstOrCreate(&held).Error; err != nil {
+		return nil, fmt.Errorf("failed to hold deposit: %v", err)
+	}
+	return &held, nil
+}
+
+// ListUnassignedDeposits 分页返回待分配队列中的充值，network 和 status 为空时不过滤
+func (s *CryptoWalletService) ListUnassignedDeposits(network models.Network, status string, page, pageSize int) ([]models.UnassignedDeposit, int64, error) {
+	var (
+		deposits []models.UnassignedDeposit
+		total    int64
+	)
+
+	query := s.db.Model(&models.UnassignedDeposit{})
+	if network != "" {
+		query = query.Where("network = ?", network)
+	}
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at ASC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&deposits).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return deposits, total, nil
+}
+
+// AssignDeposit 操作员将待分配的充值指派给使用共享地址的钱包并入账，
+// 入账前重新确认链上交易仍然有效
+func (s *CryptoWalletService) AssignDeposit(depositID, walletID uint, operatorID string) (*models.CryptoTransaction, error) {
+	if !s.cfg.Approvals.IsOperator(operatorID) {
+		return nil, ErrNotApprover
+	}
+
+	var held models.UnassignedDeposit
+	if err := s.db.First(&held, depositID).Error; err != nil {
+		return nil, fmt.Errorf("unassigned deposit not found: %v", err)
+	}
+	if held.Status != models.UnassignedDepositPending {
+		return nil, fmt.Errorf("deposit is already %s", held.Status)
+	}
+
+	var wallet models.CryptoWallet
+	if err := s.db.First(&wallet, walletID).Error; err != nil {
+		return nil, fmt.Errorf("wallet not found: %v", err)
+	}
+	if wallet.Network != held.Network || wallet.Address != held.ToAddress {
+		return nil, fmt.Errorf("wallet does not use the shared deposit address of %s", held.Network)
+	}
+
+	chain, err := s.chains.Get(held.Network)
+	if err != nil {
+		return nil, err
+	}
+	blockchainTx, err := chain.GetTransaction(held.TxHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get transaction: %v", err)
+	}
+	required := s.cfg.Confirmations.Required(held.Network, held.Asset, held.Amount)
+	if blockchainTx.Confirmations < required {
+		return nil, fmt.Errorf("insufficient confirmations: %d/%d", blockchainTx.Confirmations, required)
+	}
+
+	record := &models.CryptoTransaction{
+		WalletID:              wallet.ID,
+		Type:                  models.TransactionDeposit,
+		Network:               wallet.Network,
+		Asset:                 held.Asset,
+		FromAddress:           blockchainTx.From,
+		ToAddress:             wallet.Address,
+		Memo:                  blockchainTx.Memo,
+		Amount:                held.Amount,
+		Status:                models.CryptoTxStatusCompleted,
+		TxHash:                blockchainTx.Hash,
+		Confirmations:         blockchainTx.Confirmations,
+		RequiredConfirmations: required,
+		BlockNumber:           blockchainTx.BlockNumber,
+		BlockHash:             blockchainTx.BlockHash,
+		Raw:                   string(blockchainTx.Raw),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&held, depositID).Error; err != nil {
+			return err
+		}
+		if held.Status != models.UnassignedDepositPending {
+			return fmt.Errorf("deposit is already %s", held.Status)
+		}
+
+		if err := adjustBalance(tx, wallet.ID, wallet.Network, held.Asset, held.Amount); err != nil {
+			return err
+		}
+		valueTransaction(tx, record)
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+
+		now := s.cfg.Clock.Now()
+		return tx.Model(&held).Updates(map[string]interface{}{
+			"status":         models.UnassignedDepositAssigned,
+			"wallet_id":      wallet.ID,
+			"transaction_id": record.ID,
+			"assigned_by":    operatorID,
+			"assigned_at":    &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
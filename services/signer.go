@@ -0,0 +1,107 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"golang.org/x/crypto/ripemd160"
+	"golang.org/x/crypto/sha3"
+	"math/big"
+)
+
+// Signer builds the raw transactions of one network and signs them with the
+// sender's key. Chain data the transaction commits to (nonces, spendable
+// outputs, reference blocks) is read from the network's chain.
+type Signer interface {
+	SignTransaction(key *PrivateKey, to string, amount *big.Int, opts *TxOptions) (*SignedTransaction, error)
+}
+
+// decodedTransaction is a signed raw transaction whose signatures have been
+// checked against its sender
+type decodedTransaction struct {
+	Hash    string
+	From    string
+	To      string
+	Amount  *big.Int
+	Options *TxOptions
+
+	// UTXO chains only, inputs carry the spent outputs they were signed for
+	Inputs  []TxInput
+	Outputs []TxOutput
+	VSize   uint64
+}
+
+// txDecoder parses a signed raw transaction of a network and verifies its
+// signatures. prevout resolves the outputs spent by UTXO transactions and
+// returns nil for unknown or spent ones.
+type txDecoder func(raw []byte, prevout func(txHash string, index uint32) *UTXO) (*decodedTransaction, error)
+
+// evmChain is implemented by chains whose signatures commit to a chain ID
+// (EIP-155)
+type evmChain interface {
+	ChainID() (*big.Int, error)
+}
+
+// Signers 按网络索引的交易签名器
+type Signers map[models.Network]Signer
+
+// NewSigners 为每条链创建对应网络的签名器，ETH 使用 EIP-1559 交易，BSC 使用传统交易，
+// BTC 地址属于 btc 对应的比特币网络，TRON 交易的时间戳和过期时间取自 clock
+func NewSigners(chains Chains, btc *BitcoinParams, clock config.Clock) Signers {
+	signers := make(Signers, len(chains))
+	for network, chain := range chains {
+		switch network {
+		case models.NetworkETH:
+			signers[network] = newEthSigner(chain, true)
+		case models.NetworkBSC:
+			signers[network] = newEthSigner(chain, false)
+		case models.NetworkBTC:
+			signers[network] = newBitcoinSigner(chain, btc)
+		case models.NetworkTRON:
+			signers[network] = newTronSigner(chain, clock)
+		}
+	}
+	return signers
+}
+
+// Get 返回指定网络的签名器
+func (s Signers) Get(network models.Network) (Signer, error) {
+	signer, exists := s[network]
+	if !exists {
+		return nil, fmt.Errorf("signing is not supported for %s", network)
+	}
+	return signer, nil
+}
+
+// publicKeyAddress returns the P2WPKH address of a public key
+func (p *BitcoinParams) publicKeyAddress(public ecPoint) string {
+	return encodeSegwitAddress(p.HRP, 0, hash160(public.compressed()))
+}
+
+// publicKeyAddress derives the address of a public key on an account
+// network: Keccak-256 of the uncompressed key on ETH/BSC, the same 20 bytes
+// in TRON's Base58Check form
+func publicKeyAddress(network models.Network, public ecPoint) string {
+	account := keccak256(public.uncompressed()[1:])[12:]
+	if network == models.NetworkTRON {
+		return base58CheckEncode(append([]byte{tronAddressPrefix}, account...))
+	}
+	return toChecksumAddress(hex.EncodeToString(account))
+}
+
+func hash160(data []byte) []byte {
+	digest := sha256.Sum256(data)
+	hasher := ripemd160.New()
+	hasher.Write(digest[:])
+	return hasher.Sum(nil)
+}
+
+func keccak256(parts ...[]byte) []byte {
+	hasher := sha3.NewLegacyKeccak256()
+	for _, part := range parts {
+		hasher.Write(part)
+	}
+	return hasher.Sum(nil)
+}
@@ -0,0 +1,614 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"log"
+	"math/big"
+)
+
+// TreasuryService 管理平台的热/冷钱包：提现统一由热钱包发出，
+// 定期将用户充值地址的资金归集到热钱包，并按额度在热冷钱包之间调拨。
+// 所有内部划转记录在 internal_transfers 中，不影响用户的入账余额
+type TreasuryService struct {
+	db           *gorm.DB
+	chains       Chains
+	assets       *AssetRegistry
+	feeEstimator *FeeEstimator
+	broadcaster  *Broadcaster
+	keys         *KeyStore
+	cfg          *config.CryptoConfig
+
+	*poller
+}
+
+func NewTreasuryService(db *gorm.DB, chains Chains, assets *AssetRegistry, feeEstimator *FeeEstimator, broadcaster *Broadcaster, keys *KeyStore, cfg *config.CryptoConfig) *TreasuryService {
+	return &TreasuryService{
+		db:           db,
+		chains:       chains,
+		assets:       assets,
+		feeEstimator: feeEstimator,
+		broadcaster:  broadcaster,
+		keys:         keys,
+		cfg:          cfg,
+		poller:       newPoller(cfg.SweepInterval),
+	}
+}
+
+// Start 启动后台归集和额度检查
+func (s *TreasuryService) Start() {
+	s.run("treasury", s.Run)
+}
+
+// Run 依次更新在途划转的状态、归集充值地址并调整热钱包额度
+func (s *TreasuryService) Run() error {
+	if err := s.Refresh(); err != nil {
+		return err
+	}
+	if err := s.Sweep(); err != nil {
+		return err
+	}
+	return s.Rebalance()
+}
+
+// EnsureSystemWallets 为每个网络创建缺失的热钱包和冷钱包，使用共享充值地址的网络同时创建充值地址
+func (s *TreasuryService) EnsureSystemWallets() error {
+	for network := range s.chains {
+		tiers := []string{models.WalletTierHot, models.WalletTierCold}
+		if s.cfg.SharedDepositAddress(network) {
+			tiers = append(tiers, models.WalletTierDeposit)
+		}
+		for _, tier := range tiers {
+			if err := s.ensureSystemWallet(network, tier); err != nil {
+				return fmt.Errorf("failed to create %s %s wallet: %v", network, tier, err)
+			}
+		}
+	}
+	return nil
+}
+
+// ensureSystemWallet 钱包不存在时生成私钥并创建
+func (s *TreasuryService) ensureSystemWallet(network models.Network, tier string) error {
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.SystemWallet{}).Where("network = ? AND tier = ?", network, tier).Count(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			return nil
+		}
+		address, err := s.keys.NewAddress(tx, network)
+		if err != nil {
+			return err
+		}
+		return tx.Create(&models.SystemWallet{Network: network, Tier: tier, Address: address}).Error
+	})
+}
+
+// SystemWallet 返回指定网络和层级的平台钱包
+func (s *TreasuryService) SystemWallet(network models.Network, tier string) (*models.SystemWallet, error) {
+	var wallet models.SystemWallet
+	if err := s.db.Where("network = ? AND tier = ?", network, tier).First(&wallet).Error; err != nil {
+		return nil, fmt.Errorf("%s wallet for %s not found: %v", tier, network, err)
+	}
+	return &wallet, nil
+}
+
+// ListSystemWallets 返回所有平台钱包
+func (s *TreasuryService) ListSystemWallets() ([]models.SystemWallet, error) {
+	var wallets []models.SystemWallet
+	err := s.db.Order("network ASC, tier ASC").Find(&wallets).Error
+	return wallets, err
+}
+
+// GetTransfers 分页返回内部划转记录，network 为空时返回全部
+func (s *TreasuryService) GetTransfers(network models.Network, page, pageSize int) ([]models.InternalTransfer, int64, error) {
+	var (
+		transfers []models.InternalTransfer
+		total     int64
+	)
+
+	query := s.db.Model(&models.InternalTransfer{})
+	if network != "" {
+		query = query.Where("network = ?", network)
+	}
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	err := query.Order("created_at DESC").
+		Offset((page - 1) * pageSize).
+		Limit(pageSize).
+		Find(&transfers).Error
+	if err != nil {
+		return nil, 0, err
+	}
+	return transfers, total, nil
+}
+
+// Sweep 将用户充值地址上超过阈值的资产归集到热钱包，代币先于原生币归集
+func (s *TreasuryService) Sweep() error {
+	for network, policy := range s.cfg.Treasury {
+		if _, exists := s.chains[network]; !exists {
+			continue
+		}
+		hot, err := s.SystemWallet(network, models.WalletTierHot)
+		if err != nil {
+			return err
+		}
+
+		assets, err := s.assets.List(network)
+		if err != nil {
+			return err
+		}
+		// 原生币最后归集，避免先转走代币归集所需的手续费
+		ordered := make([]models.Asset, 0, len(assets))
+		var native *models.Asset
+		for i := range assets {
+			if assets[i].IsNative() {
+				native = &assets[i]
+				continue
+			}
+			ordered = append(ordered, assets[i])
+		}
+		if native != nil {
+			ordered = append(ordered, *native)
+		}
+
+		// 钱包的每个地址（包括已轮换的旧地址）分别归集，划转记录归属于钱包
+		var owned []models.CryptoWallet
+		if err := s.db.Where("network = ? AND memo = ?", network, "").Find(&owned).Error; err != nil {
+			return err
+		}
+		var wallets []models.CryptoWallet
+		for i := range owned {
+			addresses, err := walletAddresses(s.db, &owned[i])
+			if err != nil {
+				return err
+			}
+			for _, address := range addresses {
+				wallet := owned[i]
+				wallet.Address = address
+				wallets = append(wallets, wallet)
+			}
+		}
+		// 共享充值地址不属于单个钱包，作为一个整体归集
+		if s.cfg.SharedDepositAddress(network) {
+			deposit, err := s.SystemWallet(network, models.WalletTierDeposit)
+			if err != nil {
+				return err
+			}
+			wallets = append(wallets, models.CryptoWallet{Network: network, Address: deposit.Address})
+		}
+
+		for i := range wallets {
+			// 上一次划转尚未确认时跳过，避免重复使用同一余额
+			inFlight, err := s.hasInFlight("from_address = ? OR to_address = ?", wallets[i].Address, wallets[i].Address)
+			if err != nil {
+				return err
+			}
+			if inFlight {
+				continue
+			}
+
+			for j := range ordered {
+				threshold, exists := policy.SweepThresholds[ordered[j].Symbol]
+				if !exists {
+					continue
+				}
+				swept, err := s.sweepAsset(hot, &wallets[i], &ordered[j], threshold)
+				if err != nil {
+					log.Printf("treasury: sweep %s from wallet %d: %v", ordered[j].Symbol, wallets[i].ID, err)
+				}
+				if swept {
+					// 每个地址每轮只发起一笔划转，保证 nonce 和余额按顺序使用
+					break
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// sweepAsset 归集单项资产，发起了划转（包括 gas 补充）时返回 true
+func (s *TreasuryService) sweepAsset(hot *models.SystemWallet, wallet *models.CryptoWallet, asset *models.Asset, threshold float64) (bool, error) {
+	chain, err := s.chains.Get(wallet.Network)
+	if err != nil {
+		return false, err
+	}
+
+	if asset.IsNative() {
+		balance, err := addressBalance(chain, wallet.Address)
+		if err != nil {
+			return false, err
+		}
+		quote, err := s.sweepQuote(chain, wallet)
+		if err != nil {
+			return false, err
+		}
+		value := new(big.Int).Sub(balance, quote.feeBaseUnits)
+		if value.Sign() <= 0 || fromBaseUnits(wallet.Network, value) < threshold {
+			return false, nil
+		}
+		return true, s.transfer(&models.InternalTransfer{
+			Network:     wallet.Network,
+			Asset:       asset.Symbol,
+			Kind:        models.InternalTransferSweep,
+			WalletID:    wallet.ID,
+			FromAddress: wallet.Address,
+			ToAddress:   hot.Address,
+		}, asset, value, quote)
+	}
+
+	tokenChain, ok := chain.(TokenChain)
+	if !ok {
+		return false, nil
+	}
+	value, err := tokenChain.GetTokenBalance(asset.ContractAddress, wallet.Address)
+	if err != nil {
+		return false, err
+	}
+	if value.Sign() <= 0 || fromUnits(value, asset.Decimals) < threshold {
+		return false, nil
+	}
+
+	quote, err := s.feeEstimator.Quote(wallet.Network, FeeSpeedSlow, true)
+	if err != nil {
+		return false, err
+	}
+	gas, err := chain.GetAddressBalance(wallet.Address)
+	if err != nil {
+		return false, err
+	}
+
+	// 充值地址没有足够的原生币支付代币转账手续费时，先由热钱包补充，下一轮再归集
+	if gas.Cmp(quote.feeBaseUnits) < 0 {
+		native, err := s.assets.Get(wallet.Network, "")
+		if err != nil {
+			return false, err
+		}
+		gasQuote, err := s.feeEstimator.Quote(wallet.Network, FeeSpeedNormal, false)
+		if err != nil {
+			return false, err
+		}
+		return true, s.transfer(&models.InternalTransfer{
+			Network:     wallet.Network,
+			Asset:       native.Symbol,
+			Kind:        models.InternalTransferGas,
+			WalletID:    wallet.ID,
+			FromAddress: hot.Address,
+			ToAddress:   wallet.Address,
+		}, native, new(big.Int).Sub(quote.feeBaseUnits, gas), gasQuote)
+	}
+
+	return true, s.transfer(&models.InternalTransfer{
+		Network:     wallet.Network,
+		Asset:       asset.Symbol,
+		Kind:        models.InternalTransferSweep,
+		WalletID:    wallet.ID,
+		FromAddress: wallet.Address,
+		ToAddress:   hot.Address,
+	}, asset, value, quote)
+}
+
+// sweepQuote 返回转出地址全部原生币余额的手续费报价，UTXO 链花费所有未花费输出且不找零
+func (s *TreasuryService) sweepQuote(chain Blockchain, wallet *models.CryptoWallet) (*FeeQuote, error) {
+	quote, err := s.feeEstimator.Quote(wallet.Network, FeeSpeedSlow, false)
+	if err != nil {
+		return nil, err
+	}
+
+	utxoChain, ok := chain.(UTXOChain)
+	if !ok {
+		return quote, nil
+	}
+	utxos, err := utxoChain.ListUnspent(wallet.Address)
+	if err != nil {
+		return nil, err
+	}
+	quote.GasLimit = transactionVSize(len(utxos), 1)
+	quote.feeBaseUnits = new(big.Int).Mul(quote.GasPrice, new(big.Int).SetUint64(quote.GasLimit))
+	quote.Fee = fromBaseUnits(wallet.Network, quote.feeBaseUnits)
+	return quote, nil
+}
+
+// Rebalance 检查热钱包原生币余额，超出额度时转入冷钱包，不足时由冷钱包补充
+func (s *TreasuryService) Rebalance() error {
+	for network, policy := range s.cfg.Treasury {
+		if policy.HotMax <= 0 {
+			continue
+		}
+		chain, err := s.chains.Get(network)
+		if err != nil {
+			continue
+		}
+
+		hot, err := s.SystemWallet(network, models.WalletTierHot)
+		if err != nil {
+			return err
+		}
+		cold, err := s.SystemWallet(network, models.WalletTierCold)
+		if err != nil {
+			return err
+		}
+
+		inFlight, err := s.hasInFlight("network = ? AND kind IN ?", network,
+			[]string{models.InternalTransferTopUp, models.InternalTransferOverflow})
+		if err != nil {
+			return err
+		}
+		if inFlight {
+			continue
+		}
+
+		value, err := addressBalance(chain, hot.Address)
+		if err != nil {
+			return err
+		}
+		balance := fromBaseUnits(network, value)
+
+		transfer := &models.InternalTransfer{Network: network}
+		var amount float64
+		switch {
+		case balance > policy.HotMax:
+			transfer.Kind = models.InternalTransferOverflow
+			transfer.FromAddress, transfer.ToAddress = hot.Address, cold.Address
+			amount = balance - policy.HotTarget
+		case balance < policy.HotMin:
+			transfer.Kind = models.InternalTransferTopUp
+			transfer.FromAddress, transfer.ToAddress = cold.Address, hot.Address
+			amount = policy.HotTarget - balance
+		default:
+			continue
+		}
+
+		native, err := s.assets.Get(network, "")
+		if err != nil {
+			return err
+		}
+		transfer.Asset = native.Symbol
+
+		quote, err := s.feeEstimator.Quote(network, FeeSpeedNormal, false)
+		if err != nil {
+			return err
+		}
+		value = toBaseUnits(network, amount)
+		if err := s.feeEstimator.RefineTransfer(network, quote, transfer.FromAddress, value); err != nil {
+			log.Printf("treasury: %s %s: %v", network, transfer.Kind, err)
+			continue
+		}
+
+		// 发送方余额不足时不写入注定失败的划转
+		available, err := addressBalance(chain, transfer.FromAddress)
+		if err != nil {
+			return err
+		}
+		if available.Cmp(new(big.Int).Add(value, quote.feeBaseUnits)) < 0 {
+			log.Printf("treasury: %s %s: insufficient balance in %s wallet", network, transfer.Kind, transfer.FromAddress)
+			continue
+		}
+		if err := s.transfer(transfer, native, value, quote); err != nil {
+			log.Printf("treasury: %s %s: %v", network, transfer.Kind, err)
+		}
+	}
+	return nil
+}
+
+// Refresh 跟踪在途划转的链上状态
+func (s *TreasuryService) Refresh() error {
+	var transfers []models.InternalTransfer
+	if err := s.db.Where("status = ?", models.CryptoTxStatusBroadcast).Find(&transfers).Error; err != nil {
+		return fmt.Errorf("failed to load internal transfers: %v", err)
+	}
+
+	for i := range transfers {
+		transfer := &transfers[i]
+		chain, err := s.chains.Get(transfer.Network)
+		if err != nil {
+			return err
+		}
+
+		chainTx, err := chain.GetTransaction(transfer.TxHash)
+		if errors.Is(err, ErrTransactionNotFound) {
+			if err := s.missingTransfer(chain, transfer); err != nil {
+				log.Printf("treasury: transfer %s: %v", transfer.TxHash, err)
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("treasury: transfer %s: %v", transfer.TxHash, err)
+			continue
+		}
+
+		updates := map[string]interface{}{
+			"block_number":        chainTx.BlockNumber,
+			"block_hash":          chainTx.BlockHash,
+			"missing_since_block": 0,
+		}
+		if chainTx.BlockNumber > 0 && chainTx.Fee != nil {
+			updates["fee"] = fromBaseUnits(transfer.Network, chainTx.Fee)
+		}
+		switch {
+		case chainTx.Status == "failed":
+			updates["status"] = models.CryptoTxStatusFailed
+			updates["failure_reason"] = "transaction failed on chain"
+		case chainTx.Status == "success" &&
+			chainTx.Confirmations >= s.cfg.Confirmations.Required(transfer.Network, transfer.Asset, transfer.Amount):
+			updates["status"] = models.CryptoTxStatusCompleted
+		}
+
+		err = s.db.Model(&models.InternalTransfer{}).
+			Where("id = ? AND status = ?", transfer.ID, models.CryptoTxStatusBroadcast).
+			Updates(updates).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// missingTransfer 与提现相同：链上查不到的划转先重新发送签名交易，
+// 持续 reorgDepth 个区块仍无法发送才标记失败，并释放其 nonce
+func (s *TreasuryService) missingTransfer(chain Blockchain, transfer *models.InternalTransfer) error {
+	resendErr := resendSigned(s.db, chain, transfer.TxHash)
+	if resendErr == nil {
+		if transfer.MissingSinceBlock > 0 {
+			return s.db.Model(&models.InternalTransfer{}).
+				Where("id = ?", transfer.ID).
+				Update("missing_since_block", 0).Error
+		}
+		return nil
+	}
+
+	tip := chain.CurrentBlock()
+	if transfer.MissingSinceBlock == 0 {
+		err := s.db.Model(&models.InternalTransfer{}).
+			Where("id = ?", transfer.ID).
+			Update("missing_since_block", tip).Error
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("transaction not found and could not be re-broadcast: %v", resendErr)
+	}
+	if tip < transfer.MissingSinceBlock+reorgDepth {
+		return fmt.Errorf("transaction missing since block %d and could not be re-broadcast: %v", transfer.MissingSinceBlock, resendErr)
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.InternalTransfer{}).
+			Where("id = ? AND status = ?", transfer.ID, models.CryptoTxStatusBroadcast).
+			Updates(map[string]interface{}{
+				"status":         models.CryptoTxStatusFailed,
+				"failure_reason": "transaction dropped and could not be re-broadcast",
+			})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		if transfer.Nonce != nil {
+			return s.broadcaster.nonceManager.Release(tx, transfer.Network, transfer.FromAddress, *transfer.Nonce)
+		}
+		return nil
+	})
+}
+
+// transfer 记录一笔内部划转并写入发件箱，提交后立即尝试广播
+func (s *TreasuryService) transfer(transfer *models.InternalTransfer, asset *models.Asset, value *big.Int, quote *FeeQuote) error {
+	var intent *models.BroadcastIntent
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		transfer.Amount = fromUnits(value, asset.Decimals)
+		transfer.Status = models.CryptoTxStatusCreated
+		if err := tx.Create(transfer).Error; err != nil {
+			return err
+		}
+
+		intent = &models.BroadcastIntent{
+			SourceType:     models.BroadcastSourceInternalTransfer,
+			SourceID:       transfer.ID,
+			Network:        transfer.Network,
+			FromAddress:    transfer.FromAddress,
+			ToAddress:      transfer.ToAddress,
+			Amount:         value.String(),
+			TokenContract:  asset.ContractAddress,
+			Fee:            formatBaseUnits(quote.feeBaseUnits),
+			GasPrice:       formatBaseUnits(quote.feeCap()),
+			MaxPriorityFee: formatBaseUnits(quote.MaxPriorityFeePerGas),
+			GasLimit:       quote.GasLimit,
+		}
+		return s.broadcaster.Enqueue(tx, intent)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := s.broadcaster.Process(intent.ID); err != nil {
+		log.Printf("treasury: broadcast of %s transfer %d deferred: %v", transfer.Kind, transfer.ID, err)
+	}
+	return nil
+}
+
+// hasInFlight 判断是否有尚未完成的内部划转，包括仍在发件箱中等待广播的
+func (s *TreasuryService) hasInFlight(query string, args ...interface{}) (bool, error) {
+	var count int64
+	err := s.db.Model(&models.InternalTransfer{}).
+		Where("status IN ?", []string{models.CryptoTxStatusCreated, models.CryptoTxStatusBroadcast}).
+		Where(query, args...).
+		Count(&count).Error
+	return count > 0, err
+}
+
+// onChainTransferStatuses 已被链接受的内部划转状态
+var onChainTransferStatuses = []string{models.CryptoTxStatusBroadcast, models.CryptoTxStatusCompleted}
+
+// internalAdjustment 返回用户地址链上余额相对系统余额的差额中由内部资金流动造成的部分：
+// 归集转出及其手续费、gas 补充转入，以及从热钱包发出（未经过用户地址）的提现
+func internalAdjustment(db *gorm.DB, wallet *models.CryptoWallet, assets []string, native bool) (float64, error) {
+	// 共享充值地址的归集不归属于单个钱包，对账时链上余额按备注另行计算
+	if wallet.Memo != "" {
+		return hotWithdrawalAdjustment(db, wallet, []string{wallet.Address}, assets, native)
+	}
+
+	addresses, err := walletAddresses(db, wallet)
+	if err != nil {
+		return 0, err
+	}
+
+	var received, sent, fees float64
+	if err := db.Model(&models.InternalTransfer{}).
+		Where("to_address IN ? AND asset IN ? AND status IN ?", addresses, assets, onChainTransferStatuses).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&received).Error; err != nil {
+		return 0, err
+	}
+	if err := db.Model(&models.InternalTransfer{}).
+		Where("from_address IN ? AND asset IN ? AND status IN ?", addresses, assets, onChainTransferStatuses).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&sent).Error; err != nil {
+		return 0, err
+	}
+	if native {
+		if err := db.Model(&models.InternalTransfer{}).
+			Where("from_address IN ? AND status IN ?", addresses, onChainTransferStatuses).
+			Select("COALESCE(SUM(fee), 0)").
+			Scan(&fees).Error; err != nil {
+			return 0, err
+		}
+	}
+
+	withdrawals, err := hotWithdrawalAdjustment(db, wallet, addresses, assets, native)
+	if err != nil {
+		return 0, err
+	}
+	return received - sent - fees + withdrawals, nil
+}
+
+// hotWithdrawalAdjustment 热钱包提现扣减了系统余额但没有经过用户地址，退款记录抵消其中已退回的部分
+func hotWithdrawalAdjustment(db *gorm.DB, wallet *models.CryptoWallet, addresses, assets []string, native bool) (float64, error) {
+	var withdrawn, withdrawnFee, refunded float64
+	hotWithdrawals := db.Model(&models.CryptoTransaction{}).
+		Where("wallet_id = ? AND type = ? AND from_address NOT IN ?", wallet.ID, models.TransactionWithdraw, addresses)
+	if err := hotWithdrawals.Session(&gorm.Session{}).
+		Where("asset IN ?", assets).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&withdrawn).Error; err != nil {
+		return 0, err
+	}
+	if native {
+		if err := hotWithdrawals.Session(&gorm.Session{}).
+			Select("COALESCE(SUM(fee), 0)").
+			Scan(&withdrawnFee).Error; err != nil {
+			return 0, err
+		}
+	}
+	if err := db.Model(&models.CryptoTransaction{}).
+		Where("wallet_id = ? AND type = ? AND from_address NOT IN ? AND asset IN ?",
+			wallet.ID, models.TransactionRefund, addresses, assets).
+		Select("COALESCE(SUM(amount), 0)").
+		Scan(&refunded).Error; err != nil {
+		return 0, err
+	}
+
+	return withdrawn + withdrawnFee - refunded, nil
+}
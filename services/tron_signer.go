@@ -0,0 +1,354 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// TRON contract types and their google.protobuf.Any type URLs
+const (
+	tronTransferContract        = 1
+	tronTriggerSmartContract    = 31
+	tronTransferContractURL     = "type.googleapis.com/protocol.TransferContract"
+	tronTriggerSmartContractURL = "type.googleapis.com/protocol.TriggerSmartContract"
+)
+
+// tronExpiration is how long a signed transaction stays valid. It covers the
+// broadcaster's retries of the same raw transaction.
+const tronExpiration = time.Hour
+
+// Protobuf wire types
+const (
+	protoVarint          = 0
+	protoFixed64         = 1
+	protoLengthDelimited = 2
+	protoFixed32         = 5
+)
+
+// tronSigner builds TransferContract transactions for TRX and
+// TriggerSmartContract calls of transfer(address,uint256) for TRC-20
+// tokens. The transaction references the current block (TaPoS) and its id
+// is the SHA-256 of the serialized raw data.
+type tronSigner struct {
+	chain Blockchain
+	clock config.Clock // Timestamp and expiration of signed transactions
+}
+
+func newTronSigner(chain Blockchain, clock config.Clock) *tronSigner {
+	return &tronSigner{chain: chain, clock: clock}
+}
+
+// SignTransaction signs a transfer from the key's address. opts.Fee becomes
+// the fee limit, opts.Memo the transaction data.
+func (s *tronSigner) SignTransaction(key *PrivateKey, to string, amount *big.Int, opts *TxOptions) (*SignedTransaction, error) {
+	if opts == nil {
+		opts = &TxOptions{}
+	}
+	owner, err := tronAddressBytes(key.Address(models.NetworkTRON, nil))
+	if err != nil {
+		return nil, err
+	}
+	recipient, err := tronAddressBytes(to)
+	if err != nil {
+		return nil, err
+	}
+
+	var contract bytes.Buffer
+	if opts.TokenContract != "" {
+		token, err := tronAddressBytes(opts.TokenContract)
+		if err != nil {
+			return nil, err
+		}
+		data, err := erc20TransferData(hex.EncodeToString(recipient[1:]), amount)
+		if err != nil {
+			return nil, err
+		}
+		var call bytes.Buffer
+		protoAppendBytes(&call, 1, owner)
+		protoAppendBytes(&call, 2, token)
+		protoAppendBytes(&call, 4, data)
+		protoAppendContract(&contract, tronTriggerSmartContract, tronTriggerSmartContractURL, call.Bytes())
+	} else {
+		if !amount.IsInt64() || amount.Sign() <= 0 {
+			return nil, fmt.Errorf("invalid amount")
+		}
+		var transfer bytes.Buffer
+		protoAppendBytes(&transfer, 1, owner)
+		protoAppendBytes(&transfer, 2, recipient)
+		protoAppendVarint(&transfer, 3, amount.Uint64())
+		protoAppendContract(&contract, tronTransferContract, tronTransferContractURL, transfer.Bytes())
+	}
+
+	// Reference block: bytes 6-8 of the height and 8-16 of the hash
+	height := s.chain.CurrentBlock()
+	blockHash, err := s.chain.GetBlockHash(height)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reference block: %v", err)
+	}
+	hashBytes, err := hex.DecodeString(strings.TrimPrefix(blockHash, "0x"))
+	if err != nil || len(hashBytes) != 32 {
+		return nil, fmt.Errorf("invalid reference block hash %q", blockHash)
+	}
+	heightBytes := new(big.Int).SetUint64(height).FillBytes(make([]byte, 8))
+	now := s.clock.Now()
+
+	var raw bytes.Buffer
+	protoAppendBytes(&raw, 1, heightBytes[6:8])
+	protoAppendBytes(&raw, 4, hashBytes[8:16])
+	protoAppendVarint(&raw, 8, uint64(now.Add(tronExpiration).UnixMilli()))
+	if opts.Memo != "" {
+		protoAppendBytes(&raw, 10, []byte(opts.Memo))
+	}
+	protoAppendBytes(&raw, 11, contract.Bytes())
+	protoAppendVarint(&raw, 14, uint64(now.UnixMilli()))
+	if opts.Fee != nil && opts.Fee.IsUint64() {
+		protoAppendVarint(&raw, 18, opts.Fee.Uint64())
+	}
+
+	txID := sha256.Sum256(raw.Bytes())
+	sig := key.sign(txID[:])
+	signature := append(sig.r.FillBytes(make([]byte, 32)), sig.s.FillBytes(make([]byte, 32))...)
+	signature = append(signature, sig.recovery+27)
+
+	var transaction bytes.Buffer
+	protoAppendBytes(&transaction, 1, raw.Bytes())
+	protoAppendBytes(&transaction, 2, signature)
+
+	return &SignedTransaction{Hash: hex.EncodeToString(txID[:]), Raw: hex.EncodeToString(transaction.Bytes())}, nil
+}
+
+// tronDecoder parses a signed transaction and checks that the signature was
+// made by the contract's owner. The fee limit is reported as the fee;
+// without one the transfer pays for its bandwidth at the chain's price.
+// Reference blocks and expiration are not checked.
+func tronDecoder(raw []byte, _ func(string, uint32) *UTXO) (*decodedTransaction, error) {
+	transaction, err := parseProto(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction: %v", err)
+	}
+	rawData, signature := transaction.bytes(1), transaction.bytes(2)
+	if len(signature) != 65 {
+		return nil, errInvalidSignature
+	}
+	fields, err := parseProto(rawData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid raw data: %v", err)
+	}
+	contracts := fields.all(11)
+	if len(contracts) != 1 {
+		return nil, fmt.Errorf("transaction must contain exactly one contract")
+	}
+	contract, err := parseProto(contracts[0].data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract: %v", err)
+	}
+	wrapper, err := parseProto(contract.bytes(2))
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract parameter: %v", err)
+	}
+	parameter, err := parseProto(wrapper.bytes(2))
+	if err != nil {
+		return nil, fmt.Errorf("invalid contract parameter: %v", err)
+	}
+
+	owner, err := tronAddressString(parameter.bytes(1))
+	if err != nil {
+		return nil, err
+	}
+	decoded := &decodedTransaction{From: owner, Options: &TxOptions{Memo: string(fields.bytes(10))}}
+	if feeLimit := fields.varint(18); feeLimit > 0 {
+		decoded.Options.Fee = new(big.Int).SetUint64(feeLimit)
+	}
+
+	switch contract.varint(1) {
+	case tronTransferContract:
+		if decoded.To, err = tronAddressString(parameter.bytes(2)); err != nil {
+			return nil, err
+		}
+		decoded.Amount = new(big.Int).SetUint64(parameter.varint(3))
+		decoded.Options.GasLimit = tronTransferBandwidth
+
+	case tronTriggerSmartContract:
+		data := parameter.bytes(4)
+		if len(data) != 68 || !bytes.Equal(data[:4], erc20TransferSelector) {
+			return nil, fmt.Errorf("only token transfer calls are supported")
+		}
+		if decoded.Options.TokenContract, err = tronAddressString(parameter.bytes(2)); err != nil {
+			return nil, err
+		}
+		decoded.To = base58CheckEncode(append([]byte{tronAddressPrefix}, data[16:36]...))
+		decoded.Amount = new(big.Int).SetBytes(data[36:])
+		decoded.Options.GasLimit = tronTokenBandwidth
+
+	default:
+		return nil, fmt.Errorf("unsupported contract type %d", contract.varint(1))
+	}
+
+	txID := sha256.Sum256(rawData)
+	recovery := signature[64]
+	if recovery >= 27 {
+		recovery -= 27
+	}
+	public, err := recoverPublicKey(txID[:], ecdsaSignature{
+		r:        new(big.Int).SetBytes(signature[:32]),
+		s:        new(big.Int).SetBytes(signature[32:64]),
+		recovery: recovery,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if publicKeyAddress(models.NetworkTRON, public) != owner {
+		return nil, fmt.Errorf("signature does not match owner address %s", owner)
+	}
+
+	decoded.Hash = hex.EncodeToString(txID[:])
+	return decoded, nil
+}
+
+// tronAddressBytes returns the 21 byte form of a TRON address
+func tronAddressBytes(address string) ([]byte, error) {
+	if err := validateTRONAddress(address); err != nil {
+		return nil, err
+	}
+	payload, _, _ := base58CheckDecode(address)
+	return payload, nil
+}
+
+func tronAddressString(data []byte) (string, error) {
+	if len(data) != 21 || data[0] != tronAddressPrefix {
+		return "", fmt.Errorf("invalid address %x", data)
+	}
+	return base58CheckEncode(data), nil
+}
+
+func protoAppendContract(buf *bytes.Buffer, contractType uint64, typeURL string, parameter []byte) {
+	var wrapper bytes.Buffer
+	protoAppendBytes(&wrapper, 1, []byte(typeURL))
+	protoAppendBytes(&wrapper, 2, parameter)
+
+	protoAppendVarint(buf, 1, contractType)
+	protoAppendBytes(buf, 2, wrapper.Bytes())
+}
+
+func protoAppendVarint(buf *bytes.Buffer, field int, value uint64) {
+	protoWriteVarint(buf, uint64(field)<<3|protoVarint)
+	protoWriteVarint(buf, value)
+}
+
+func protoAppendBytes(buf *bytes.Buffer, field int, data []byte) {
+	protoWriteVarint(buf, uint64(field)<<3|protoLengthDelimited)
+	protoWriteVarint(buf, uint64(len(data)))
+	buf.Write(data)
+}
+
+func protoWriteVarint(buf *bytes.Buffer, value uint64) {
+	for value >= 0x80 {
+		buf.WriteByte(byte(value) | 0x80)
+		value >>= 7
+	}
+	buf.WriteByte(byte(value))
+}
+
+// protoField is a decoded protobuf field, data holds length-delimited
+// values and value the others
+type protoField struct {
+	number int
+	value  uint64
+	data   []byte
+}
+
+type protoMessage []protoField
+
+// parseProto splits a protobuf message into its fields
+func parseProto(data []byte) (protoMessage, error) {
+	var message protoMessage
+	for len(data) > 0 {
+		key, n := protoReadVarint(data)
+		if n == 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+		data = data[n:]
+
+		field := protoField{number: int(key >> 3)}
+		switch key & 7 {
+		case protoVarint:
+			if field.value, n = protoReadVarint(data); n == 0 {
+				return nil, fmt.Errorf("invalid varint in field %d", field.number)
+			}
+			data = data[n:]
+		case protoLengthDelimited:
+			length, n := protoReadVarint(data)
+			if n == 0 || length > uint64(len(data)-n) {
+				return nil, fmt.Errorf("invalid length in field %d", field.number)
+			}
+			field.data = data[n : n+int(length)]
+			data = data[n+int(length):]
+		case protoFixed64:
+			if len(data) < 8 {
+				return nil, fmt.Errorf("truncated field %d", field.number)
+			}
+			data = data[8:]
+		case protoFixed32:
+			if len(data) < 4 {
+				return nil, fmt.Errorf("truncated field %d", field.number)
+			}
+			data = data[4:]
+		default:
+			return nil, fmt.Errorf("unsupported wire type %d", key&7)
+		}
+		message = append(message, field)
+	}
+	return message, nil
+}
+
+// protoReadVarint returns the varint at the start of data and its length,
+// which is zero when the varint is truncated or too long
+func protoReadVarint(data []byte) (uint64, int) {
+	var value uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		value |= uint64(data[i]&0x7f) << (7 * i)
+		if data[i] < 0x80 {
+			return value, i + 1
+		}
+	}
+	return 0, 0
+}
+
+func (m protoMessage) all(number int) []protoField {
+	var fields []protoField
+	for _, field := range m {
+		if field.number == number {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// bytes returns the last value of a length-delimited field, as protobuf
+// parsers do for repeated scalar fields
+func (m protoMessage) bytes(number int) []byte {
+	var data []byte
+	for _, field := range m {
+		if field.number == number {
+			data = field.data
+		}
+	}
+	return data
+}
+
+func (m protoMessage) varint(number int) uint64 {
+	var value uint64
+	for _, field := range m {
+		if field.number == number {
+			value = field.value
+		}
+	}
+	return value
+}
@@ -0,0 +1,31 @@
+package services
+
+import (
+	"github.com/panaceacode/wallet-demo/models"
+	"github.com/shopspring/decimal"
+	"math/big"
+)
+
+// toBaseUnits 将以币为单位的金额转换为链上最小单位（satoshi/wei/sun）
+func toBaseUnits(network models.Network, amount float64) *big.Int {
+	return toUnits(amount, network.Decimals())
+}
+
+// fromBaseUnits 将链上最小单位转换为以币为单位的金额
+func fromBaseUnits(network models.Network, value *big.Int) float64 {
+	return fromUnits(value, network.Decimals())
+}
+
+// toUnits 按精度将金额转换为最小单位，用于代币
+func toUnits(amount float64, decimals int32) *big.Int {
+	return decimal.NewFromFloat(amount).Shift(decimals).BigInt()
+}
+
+// fromUnits 按精度将最小单位转换为金额，用于代币
+func fromUnits(value *big.Int, decimals int32) float64 {
+	if value == nil {
+		return 0
+	}
+	amount, _ := decimal.NewFromBigInt(value, -decimals).Float64()
+	return amount
+}
@@ -0,0 +1,149 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"time"
+)
+
+// WalletAddressUsage 钱包地址及其收款情况
+type WalletAddressUsage struct {
+	models.CryptoWalletAddress
+	Used          bool       `json:"used"`     // 是否收到过已入账的充值
+	Deposits      int64      `json:"deposits"` // 已入账的充值笔数
+	LastDepositAt *time.Time `json:"last_deposit_at,omitempty"`
+}
+
+// walletAddresses 返回钱包拥有的所有地址，当前地址在前，轮换下来的旧地址按生成顺序在后
+func walletAddresses(db *gorm.DB, wallet *models.CryptoWallet) ([]string, error) {
+	var rows []string
+	if err := db.Model(&models.CryptoWalletAddress{}).
+		Where("wallet_id = ?", wallet.ID).
+		Order("sequence ASC").
+		Pluck("address", &rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to get wallet addresses: %v", err)
+	}
+
+	addresses := []string{wallet.Address}
+	for _, address := range rows {
+		if !containsAddress(addresses, address) {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
+
+// containsAddress 判断地址是否在列表中
+func containsAddress(addresses []string, address string) bool {
+	for _, a := range addresses {
+		if a == address {
+			return true
+		}
+	}
+	return false
+}
+
+// NewDepositAddress 为钱包生成新的充值地址并设为当前地址，原地址转为 retired 后继续监听入账。
+// 共享充值地址的钱包按备注区分，不支持轮换
+func (s *CryptoWalletService) NewDepositAddress(walletID uint) (*models.CryptoWalletAddress, error) {
+	var created *models.CryptoWalletAddress
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var wallet models.CryptoWallet
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&wallet, walletID).Error; err != nil {
+			return fmt.Errorf("wallet not found: %v", err)
+		}
+		if wallet.Memo != "" {
+			return fmt.Errorf("wallets on a shared deposit address cannot rotate addresses")
+		}
+
+		// 未使用的地址过多时拒绝，避免无限生成需要监听的地址
+		usage, err := addressUsage(tx, &wallet)
+		if err != nil {
+			return err
+		}
+		unused := 0
+		for _, address := range usage {
+			if !address.Used {
+				unused++
+			}
+		}
+		if s.cfg.AddressGapLimit > 0 && unused >= s.cfg.AddressGapLimit {
+			return fmt.Errorf("wallet already has %d unused addresses", unused)
+		}
+
+		now := s.cfg.Clock.Now()
+		if err := tx.Model(&models.CryptoWalletAddress{}).
+			Where("wallet_id = ? AND status = ?", wallet.ID, models.WalletAddressActive).
+			Updates(map[string]interface{}{
+				"status":     models.WalletAddressRetired,
+				"retired_at": &now,
+			}).Error; err != nil {
+			return err
+		}
+
+		address, err := s.keys.NewAddress(tx, wallet.Network)
+		if err != nil {
+			return err
+		}
+		created = &models.CryptoWalletAddress{
+			WalletID: wallet.ID,
+			Network:  wallet.Network,
+			Address:  address,
+			Status:   models.WalletAddressActive,
+		}
+		if len(usage) > 0 {
+			created.Sequence = usage[len(usage)-1].Sequence + 1
+		}
+		if err := tx.Create(created).Error; err != nil {
+			return err
+		}
+		return tx.Model(&wallet).Update("address", created.Address).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// ListAddresses 返回钱包的所有地址及其收款情况，按生成顺序排列
+func (s *CryptoWalletService) ListAddresses(walletID uint) ([]WalletAddressUsage, error) {
+	var wallet models.CryptoWallet
+	if err := s.db.First(&wallet, walletID).Error; err != nil {
+		return nil, fmt.Errorf("wallet not found: %v", err)
+	}
+	return addressUsage(s.db, &wallet)
+}
+
+// addressUsage 统计钱包每个地址已入账的充值
+func addressUsage(db *gorm.DB, wallet *models.CryptoWallet) ([]WalletAddressUsage, error) {
+	var addresses []models.CryptoWalletAddress
+	if err := db.Where("wallet_id = ?", wallet.ID).Order("sequence ASC").Find(&addresses).Error; err != nil {
+		return nil, fmt.Errorf("failed to get wallet addresses: %v", err)
+	}
+
+	var deposits []models.CryptoTransaction
+	if err := db.Select("to_address", "created_at").
+		Where("wallet_id = ? AND type = ?", wallet.ID, models.TransactionDeposit).
+		Find(&deposits).Error; err != nil {
+		return nil, err
+	}
+
+	usage := make([]WalletAddressUsage, len(addresses))
+	for i := range addresses {
+		usage[i].CryptoWalletAddress = addresses[i]
+		for j := range deposits {
+			if deposits[j].ToAddress != addresses[i].Address {
+				continue
+			}
+			usage[i].Used = true
+			usage[i].Deposits++
+			if usage[i].LastDepositAt == nil || deposits[j].CreatedAt.After(*usage[i].LastDepositAt) {
+				usage[i].LastDepositAt = &deposits[j].CreatedAt
+			}
+		}
+	}
+	return usage, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/shopspring/decimal"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
+	"time"
 )
 
 type WalletService struct {
@@ -17,6 +18,11 @@ func NewWalletService(db *gorm.DB) *WalletService {
 	return &WalletService{db: db}
 }
 
+// Now 返回数据库时钟的当前时间，与交易记录的 created_at 一致
+func (s *WalletService) Now() time.Time {
+	return s.db.NowFunc()
+}
+
 func (s *WalletService) CreateWallet(userID uint, currency string) (*models.Wallet, error) {
 	wallet := &models.Wallet{
 		UserID:   userID,
@@ -0,0 +1,213 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrNotApprover 操作员不在审批名单中
+var ErrNotApprover = errors.New("operator is not allowed to approve withdrawals")
+
+// PendingWithdrawal 等待审批的提现及已有的审批记录
+type PendingWithdrawal struct {
+	Transaction models.CryptoTransaction    `json:"transaction"`
+	Approvals   []models.WithdrawalApproval `json:"approvals"`
+}
+
+// approvalStatuses 仍由审批流程处理的提现状态
+var approvalStatuses = []string{models.CryptoTxStatusPendingApproval, models.CryptoTxStatusApproved}
+
+// ListPendingApprovals 返回所有等待审批或等待重试广播的提现
+func (s *CryptoWalletService) ListPendingApprovals() ([]PendingWithdrawal, error) {
+	var records []models.CryptoTransaction
+	err := s.db.Where("type = ? AND status IN ?", models.TransactionWithdraw, approvalStatuses).
+		Order("created_at ASC").
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
+
+	pending := make([]PendingWithdrawal, 0, len(records))
+	for _, record := range records {
+		var approvals []models.WithdrawalApproval
+		if err := s.db.Where("transaction_id = ?", record.ID).Find(&approvals).Error; err != nil {
+			return nil, err
+		}
+		pending = append(pending, PendingWithdrawal{Transaction: record, Approvals: approvals})
+	}
+	return pending, nil
+}
+
+// ApproveWithdrawal 记录操作员的批准，批准人数达到要求后将提现写入发件箱广播。
+// 对 approved 状态的提现再次批准时不再记录审批，只重试广播
+func (s *CryptoWalletService) ApproveWithdrawal(transactionID uint, operatorID, comment string) (*models.CryptoTransaction, error) {
+	if !s.cfg.Approvals.IsOperator(operatorID) {
+		return nil, ErrNotApprover
+	}
+
+	var (
+		record models.CryptoTransaction
+		intent *models.BroadcastIntent
+	)
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.lockPendingApproval(tx, transactionID, &record); err != nil {
+			return err
+		}
+		if record.Status == models.CryptoTxStatusPendingApproval {
+			if err := s.recordDecision(tx, record.ID, operatorID, models.ApprovalDecisionApproved, comment); err != nil {
+				return err
+			}
+
+			var approved int64
+			if err := tx.Model(&models.WithdrawalApproval{}).
+				Where("transaction_id = ? AND decision = ?", record.ID, models.ApprovalDecisionApproved).
+				Count(&approved).Error; err != nil {
+				return err
+			}
+			if approved < int64(record.ApprovalsRequired) {
+				return nil
+			}
+		}
+
+		var err error
+		intent, err = s.broadcastApproved(tx, &record)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	s.dispatch(intent, &record)
+	return &record, nil
+}
+
+// RejectWithdrawal 拒绝提现并释放冻结的本金和手续费，任一操作员拒绝即终止
+func (s *CryptoWalletService) RejectWithdrawal(transactionID uint, operatorID, reason string) (*models.CryptoTransaction, error) {
+	if !s.cfg.Approvals.IsOperator(operatorID) {
+		return nil, ErrNotApprover
+	}
+
+	var record models.CryptoTransaction
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := s.lockPendingApproval(tx, transactionID, &record); err != nil {
+			return err
+		}
+		if err := s.recordDecision(tx, record.ID, operatorID, models.ApprovalDecisionRejected, reason); err != nil {
+			return err
+		}
+
+		if reason == "" {
+			reason = "rejected by " + operatorID
+		}
+		if _, err := releaseWithdrawal(tx, &record, models.CryptoTxStatusRejected, true, reason, approvalStatuses); err != nil {
+			return err
+		}
+		record.Status = models.CryptoTxStatusRejected
+		record.FailureReason = reason
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// lockPendingApproval 锁定仍在审批期内的提现，包括等待重试广播的 approved 状态
+func (s *CryptoWalletService) lockPendingApproval(tx *gorm.DB, transactionID uint, record *models.CryptoTransaction) error {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(record, transactionID).Error; err != nil {
+		return fmt.Errorf("transaction not found: %v", err)
+	}
+	if record.Type != models.TransactionWithdraw ||
+		(record.Status != models.CryptoTxStatusPendingApproval && record.Status != models.CryptoTxStatusApproved) {
+		return fmt.Errorf("withdrawal is %s and not pending approval", record.Status)
+	}
+	if record.ApprovalExpiresAt != nil && s.cfg.Clock.Now().After(*record.ApprovalExpiresAt) {
+		return fmt.Errorf("withdrawal approval has expired")
+	}
+	return nil
+}
+
+// recordDecision 写入审批记录，同一操作员对同一笔提现只能审批一次
+func (s *CryptoWalletService) recordDecision(tx *gorm.DB, transactionID uint, operatorID, decision, comment string) error {
+	var count int64
+	if err := tx.Model(&models.WithdrawalApproval{}).
+		Where("transaction_id = ? AND operator_id = ?", transactionID, operatorID).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("operator %s has already reviewed this withdrawal", operatorID)
+	}
+
+	return tx.Create(&models.WithdrawalApproval{
+		TransactionID: transactionID,
+		OperatorID:    operatorID,
+		Decision:      decision,
+		Comment:       comment,
+	}).Error
+}
+
+// broadcastApproved 将已获批准的提现写入发件箱，手续费按当前行情重新报价。
+// 无法重新报价或冻结时审批记录照常提交，提现进入 approved 状态并记录原因，等待再次批准重试
+func (s *CryptoWalletService) broadcastApproved(tx *gorm.DB, record *models.CryptoTransaction) (*models.BroadcastIntent, error) {
+	asset, err := s.assets.Get(record.Network, record.Asset)
+	if err != nil {
+		return nil, err
+	}
+
+	quote, err := s.requoteApproved(tx, record, asset)
+	if err != nil {
+		record.Status = models.CryptoTxStatusApproved
+		record.FailureReason = truncate(err.Error(), 255)
+		return nil, tx.Model(record).Updates(map[string]interface{}{
+			"status":         record.Status,
+			"failure_reason": record.FailureReason,
+		}).Error
+	}
+
+	record.Status = models.CryptoTxStatusCreated
+	record.FailureReason = ""
+	if err := tx.Model(record).Updates(map[string]interface{}{
+		"status":         record.Status,
+		"fee":            record.Fee,
+		"failure_reason": "",
+	}).Error; err != nil {
+		return nil, err
+	}
+	intent := withdrawalIntent(record, asset, quote)
+	if err := s.broadcaster.Enqueue(tx, intent); err != nil {
+		return nil, err
+	}
+	return intent, nil
+}
+
+// requoteApproved 重新报价手续费。报价超出冻结的预估手续费时从用户的原生币余额补冻结差额，
+// 并将 record.Fee 更新为新的冻结额
+func (s *CryptoWalletService) requoteApproved(tx *gorm.DB, record *models.CryptoTransaction, asset *models.Asset) (*FeeQuote, error) {
+	value := toUnits(record.Amount, asset.Decimals)
+	quote, err := s.quoteFee(record.FromAddress, record.Network, asset, value, WithdrawOptions{MaxFee: record.Fee})
+	if err == nil {
+		return quote, nil
+	}
+
+	quote, err = s.quoteFee(record.FromAddress, record.Network, asset, value, WithdrawOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote fee: %v", err)
+	}
+	var wallet models.CryptoWallet
+	if err := tx.First(&wallet, record.WalletID).Error; err != nil {
+		return nil, fmt.Errorf("wallet not found: %v", err)
+	}
+	extra := quote.Fee - record.Fee
+	if wallet.Balance < extra {
+		return nil, fmt.Errorf("fee %v exceeds the reserved %v and the balance cannot cover the difference", quote.Fee, record.Fee)
+	}
+	if err := adjustBalance(tx, record.WalletID, record.Network, "", -extra); err != nil {
+		return nil, fmt.Errorf("failed to update balance: %v", err)
+	}
+	record.Fee = quote.Fee
+	return quote, nil
+}
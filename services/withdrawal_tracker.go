@@ -0,0 +1,237 @@
+package services
+
+import (
+	"fmt"
+	"github.com/panaceacode/wallet-demo/config"
+	"github.com/panaceacode/wallet-demo/models"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"log"
+	"time"
+)
+
+// WithdrawalTracker 轮询链上状态，推进提现交易的生命周期
+type WithdrawalTracker struct {
+	db     *gorm.DB
+	chains Chains
+	cfg    *config.CryptoConfig
+
+	*poller
+}
+
+func NewWithdrawalTracker(db *gorm.DB, chains Chains, cfg *config.CryptoConfig, interval time.Duration) *WithdrawalTracker {
+	return &WithdrawalTracker{
+		db:     db,
+		chains: chains,
+		cfg:    cfg,
+		poller: newPoller(interval),
+	}
+}
+
+// Start 启动后台轮询
+func (t *WithdrawalTracker) Start() {
+	t.run("withdrawal tracker", t.Poll)
+}
+
+// Poll 检查所有在途的提现交易
+func (t *WithdrawalTracker) Poll() error {
+	var pending []models.CryptoTransaction
+	err := t.db.Where("type = ? AND status IN ?", models.TransactionWithdraw, []string{
+		models.CryptoTxStatusBroadcast,
+		models.CryptoTxStatusConfirming,
+	}).Find(&pending).Error
+	if err != nil {
+		return fmt.Errorf("failed to load pending withdrawals: %v", err)
+	}
+
+	for i := range pending {
+		if err := t.track(&pending[i]); err != nil {
+			log.Printf("withdrawal tracker: tx %s: %v", pending[i].TxHash, err)
+		}
+	}
+	return t.expireApprovals()
+}
+
+// expireApprovals 取消超过审批期限（包括等待重试广播）的大额提现，释放冻结的本金和手续费
+func (t *WithdrawalTracker) expireApprovals() error {
+	var expired []models.CryptoTransaction
+	err := t.db.Where("type = ? AND status IN ? AND approval_expires_at < ?",
+		models.TransactionWithdraw, approvalStatuses, t.cfg.Clock.Now()).
+		Find(&expired).Error
+	if err != nil {
+		return fmt.Errorf("failed to load expired approvals: %v", err)
+	}
+
+	for i := range expired {
+		err := t.db.Transaction(func(tx *gorm.DB) error {
+			_, err := releaseWithdrawal(tx, &expired[i], models.CryptoTxStatusExpired, true,
+				"approval expired", approvalStatuses)
+			return err
+		})
+		if err != nil {
+			log.Printf("withdrawal tracker: expire withdrawal %d: %v", expired[i].ID, err)
+		}
+	}
+	return nil
+}
+
+func (t *WithdrawalTracker) track(record *models.CryptoTransaction) error {
+	chain, err := t.chains.Get(record.Network)
+	if err != nil {
+		return err
+	}
+
+	chainTx, err := chain.GetTransaction(record.TxHash)
+	if err != nil {
+		return fmt.Errorf("failed to get transaction: %v", err)
+	}
+
+	if chainTx.Status == "failed" {
+		return t.fail(record, chainTx, "transaction failed on chain")
+	}
+
+	status := models.CryptoTxStatusBroadcast
+	if chainTx.Confirmations > 0 {
+		status = models.CryptoTxStatusConfirming
+	}
+	required := t.cfg.Confirmations.Required(record.Network, record.Asset, record.Amount)
+	if chainTx.Status == "success" && chainTx.Confirmations >= required {
+		status = models.CryptoTxStatusCompleted
+	}
+
+	updates := map[string]interface{}{
+		"required_confirmations": required,
+		"status":                 status,
+		"confirmations":          chainTx.Confirmations,
+		"block_number":           chainTx.BlockNumber,
+		"block_hash":             chainTx.BlockHash,
+	}
+
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		current, err := lockInFlight(tx, record)
+		if err != nil || current == nil {
+			return err
+		}
+		if err := settleFee(tx, current, chainTx); err != nil {
+			return err
+		}
+		return tx.Model(current).Updates(updates).Error
+	})
+}
+
+// fail 将提现标记为失败，并退回已扣减的金额
+func (t *WithdrawalTracker) fail(record *models.CryptoTransaction, chainTx *BlockchainTransaction, reason string) error {
+	return t.db.Transaction(func(tx *gorm.DB) error {
+		current, err := lockInFlight(tx, record)
+		if err != nil || current == nil {
+			return err
+		}
+		// 链上失败的交易仍会消耗手续费，只退回本金
+		if err := settleFee(tx, current, chainTx); err != nil {
+			return err
+		}
+		_, err = refundWithdrawal(tx, current, false, reason, []string{
+			models.CryptoTxStatusBroadcast,
+			models.CryptoTxStatusConfirming,
+		})
+		return err
+	})
+}
+
+// lockInFlight 锁定仍在途且仍跟踪同一笔交易的提现记录，已进入终态或已被替换时返回 nil，
+// 避免覆盖并发的处理结果
+func lockInFlight(tx *gorm.DB, record *models.CryptoTransaction) (*models.CryptoTransaction, error) {
+	var current models.CryptoTransaction
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&current, record.ID).Error; err != nil {
+		return nil, fmt.Errorf("transaction not found: %v", err)
+	}
+	if current.TxHash != record.TxHash {
+		return nil, nil
+	}
+	if current.Status != models.CryptoTxStatusBroadcast && current.Status != models.CryptoTxStatusConfirming {
+		return nil, nil
+	}
+	return &current, nil
+}
+
+// settleFee 交易上链后按实际手续费与冻结的手续费多退少补，并记录实际手续费。
+// 未上链的交易没有回执，手续费保持冻结；已结算的记录再次调用时差额为零。需在事务内调用
+func settleFee(tx *gorm.DB, record *models.CryptoTransaction, chainTx *BlockchainTransaction) error {
+	if chainTx.Fee == nil || chainTx.BlockNumber == 0 {
+		return nil
+	}
+
+	fee := fromBaseUnits(record.Network, chainTx.Fee)
+	if fee != record.Fee {
+		if err := adjustBalance(tx, record.WalletID, record.Network, "", record.Fee-fee); err != nil {
+			return fmt.Errorf("failed to update balance: %v", err)
+		}
+	}
+	record.Fee = fee
+	return tx.Model(record).Updates(map[string]interface{}{
+		"fee":      fee,
+		"gas_used": chainTx.GasUsed,
+	}).Error
+}
+
+// refundWithdrawal 仅当提现仍处于 fromStatuses 之一时将其置为失败，
+// 同时退回本金（refundFee 时连同手续费）并写入关联原交易的退款记录，需在事务内调用。
+// 代币提现的本金退回代币余额，手续费以原生币单独退回
+func refundWithdrawal(tx *gorm.DB, record *models.CryptoTransaction, refundFee bool, reason string, fromStatuses []string) (bool, error) {
+	return releaseWithdrawal(tx, record, models.CryptoTxStatusFailed, refundFee, reason, fromStatuses)
+}
+
+// releaseWithdrawal 与 refundWithdrawal 相同，但将提现置为指定的终态，
+// 用于审批被拒绝或超时时释放冻结的资金
+func releaseWithdrawal(tx *gorm.DB, record *models.CryptoTransaction, status string, refundFee bool, reason string, fromStatuses []string) (bool, error) {
+	result := tx.Model(&models.CryptoTransaction{}).
+		Where("id = ? AND status IN ?", record.ID, fromStatuses).
+		Updates(map[string]interface{}{
+			"status":         status,
+			"failure_reason": reason,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		// 已被其他流程处理
+		return false, nil
+	}
+
+	type refundItem struct {
+		asset  string
+		amount float64
+	}
+	refunds := []refundItem{{record.Asset, record.Amount}}
+	if refundFee && record.Fee > 0 {
+		if isNativeAsset(record.Network, record.Asset) {
+			refunds[0].amount += record.Fee
+		} else {
+			refunds = append(refunds, refundItem{record.Network.NativeSymbol(), record.Fee})
+		}
+	}
+
+	for _, item := range refunds {
+		asset, amount := item.asset, item.amount
+		if err := adjustBalance(tx, record.WalletID, record.Network, asset, amount); err != nil {
+			return false, fmt.Errorf("failed to refund balance: %v", err)
+		}
+
+		refund := &models.CryptoTransaction{
+			WalletID:         record.WalletID,
+			Type:             models.TransactionRefund,
+			Network:          record.Network,
+			Asset:            asset,
+			FromAddress:      record.FromAddress,
+			ToAddress:        record.FromAddress,
+			Amount:           amount,
+			Status:           models.CryptoTxStatusCompleted,
+			RefTransactionID: record.ID,
+		}
+		valueTransaction(tx, refund)
+		if err := tx.Create(refund).Error; err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}